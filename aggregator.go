@@ -4,19 +4,24 @@ import (
 	"encoding/json"
 	"errors"
 	"maps"
+	"slices"
 
 	"go.uber.org/zap"
 )
 
 const (
-	strategyMerge = "merge"
-	strategyArray = "array"
+	strategyMerge        = "merge"
+	strategyArray        = "array"
+	strategyFirstSuccess = "first_success"
+	strategyRace         = "race"
 )
 
 type AggregatedResponse struct {
-	Data    json.RawMessage
-	Errors  []JSONError
-	Partial bool
+	Data     json.RawMessage
+	Errors   []JSONError
+	Partial  bool
+	Fallback bool // Some data comes from upstream fallbacks.
+	Status   int  // Client status of a failed response set by error mapping. Zero means the default.
 }
 
 type aggregator interface {
@@ -29,23 +34,106 @@ type defaultAggregator struct {
 
 // aggregate combines multiple upstream responses based on the route's strategy.
 // Single responses are returned as-is. Multiple responses are aggregated either
-// by merging JSON objects ("merge") or creating a JSON array ("array").
+// by merging JSON objects ("merge") or creating a JSON array ("array"). The "first_success"
+// and "race" strategies return the first successful response as-is.
 // Upstream errors respect allowPartialResults: partial results may be included
-// if allowed; otherwise a single error response is returned.
+// if allowed; otherwise a single error response is returned. Errors are mapped
+// by the error mapping rules of the route before aggregation. XML, form and text
+// bodies are decoded to JSON by their Content-Type, see decodeBody.
 func (a *defaultAggregator) aggregate(responses []UpstreamResponse, aggregation AggregationConfig) AggregatedResponse {
-	if len(responses) == 1 {
-		return a.rawResponse(responses)
-	}
-
-	switch aggregation.Strategy {
-	case strategyMerge:
-		return a.mergeResponses(responses, aggregation.AllowPartialResults)
-	case strategyArray:
-		return a.arrayOfResponses(responses, aggregation.AllowPartialResults)
+	responses, status := a.mapErrors(responses, aggregation.ErrorMapping)
+
+	var aggregated AggregatedResponse
+
+	switch {
+	case len(responses) == 1:
+		aggregated = a.rawResponse(responses)
+	case aggregation.Strategy == strategyMerge:
+		aggregated = a.mergeResponses(responses, aggregation.AllowPartialResults)
+	case aggregation.Strategy == strategyArray:
+		aggregated = a.arrayOfResponses(responses, aggregation.AllowPartialResults)
+	case aggregation.Strategy == strategyFirstSuccess, aggregation.Strategy == strategyRace:
+		aggregated = a.firstSuccessfulResponse(responses)
 	default:
 		a.log.Error("unknown aggregation strategy", zap.String("strategy", aggregation.Strategy))
 		return AggregatedResponse{}
 	}
+
+	if len(aggregated.Errors) > 0 && !aggregated.Partial {
+		aggregated.Status = status
+	}
+
+	return aggregated
+}
+
+// mapErrors returns a copy of the responses whose errors are attributed to their upstreams and rewritten by
+// the first matching error mapping rule, and the client status of the first failed upstream whose rule sets one.
+func (a *defaultAggregator) mapErrors(responses []UpstreamResponse, rules []ErrorMappingConfig) ([]UpstreamResponse, int) {
+	var (
+		mapped = slices.Clone(responses)
+		status int
+	)
+
+	for i := range mapped {
+		resp := &mapped[i]
+		if resp.Err == nil && len(resp.Errors) == 0 {
+			continue
+		}
+
+		errs := a.upstreamErrors(*resp)
+		rule := matchErrorMapping(rules, *resp)
+
+		for j := range errs {
+			errs[j].Upstream = resp.Upstream
+
+			if rule == nil {
+				continue
+			}
+
+			if rule.Code != "" {
+				errs[j].Code = rule.Code
+			}
+
+			if rule.Message != "" {
+				errs[j].Message = rule.Message
+			}
+		}
+
+		if rule != nil && status == 0 {
+			status = rule.Status
+		}
+
+		resp.Errors = errs
+	}
+
+	return mapped, status
+}
+
+// matchErrorMapping returns the first rule matching the error of a failed response, or nil if there is none.
+func matchErrorMapping(rules []ErrorMappingConfig, resp UpstreamResponse) *ErrorMappingConfig {
+	if resp.Err == nil {
+		return nil
+	}
+
+	for i := range rules {
+		rule := &rules[i]
+
+		if rule.Upstream != "" && rule.Upstream != resp.Name {
+			continue
+		}
+
+		if rule.Kind != "" && rule.Kind != string(resp.Err.Kind) {
+			continue
+		}
+
+		if rule.UpstreamStatus != 0 && rule.UpstreamStatus != resp.Err.StatusCode {
+			continue
+		}
+
+		return rule
+	}
+
+	return nil
 }
 
 func (a *defaultAggregator) rawResponse(responses []UpstreamResponse) AggregatedResponse {
@@ -57,7 +145,7 @@ func (a *defaultAggregator) rawResponse(responses []UpstreamResponse) Aggregated
 	if resp.Err != nil {
 		return AggregatedResponse{
 			Data:    nil,
-			Errors:  []JSONError{a.mapUpstreamError(resp.Err)},
+			Errors:  a.upstreamErrors(resp),
 			Partial: false,
 		}
 	}
@@ -66,41 +154,57 @@ func (a *defaultAggregator) rawResponse(responses []UpstreamResponse) Aggregated
 		return AggregatedResponse{}
 	}
 
+	body, err := decodeBody(resp.Headers, resp.Body)
+	if err != nil {
+		a.log.Warn("failed to decode response", zap.Error(err))
+
+		return jsonParseError()
+	}
+
 	return AggregatedResponse{
-		Data:    resp.Body,
-		Errors:  nil,
-		Partial: false,
+		Data:     body,
+		Errors:   slices.Clone(resp.Errors), // Cloned, so request IDs are not attached to cached errors.
+		Partial:  len(resp.Errors) > 0,
+		Fallback: resp.Fallback,
 	}
 }
 
 func (a *defaultAggregator) mergeResponses(responses []UpstreamResponse, allowPartialResults bool) AggregatedResponse {
 	merged := make(map[string]any)
 
-	var aggregationErrors []JSONError
+	var (
+		aggregationErrors []JSONError
+		fallback          bool
+	)
 
 	for _, resp := range responses {
-		var obj map[string]any
+		var v any
+
+		// Skipped upstreams are neither results nor failures.
+		if resp.Skipped {
+			continue
+		}
 
 		// Handle upstream error.
 		if resp.Err != nil {
-			mapped := a.mapUpstreamError(resp.Err)
+			mapped := a.upstreamErrors(resp)
 
 			a.log.Warn(
 				"upstream has errors",
 				zap.Bool("allow_partial_results", allowPartialResults),
 				zap.String("upstream_error", resp.Err.Unwrap().Error()),
-				zap.String("mapped_error", mapped.Message),
+				zap.String("mapped_error", mapped[0].Message),
 			)
 
 			if !allowPartialResults {
 				return AggregatedResponse{
 					Data:    nil,
-					Errors:  []JSONError{mapped},
+					Errors:  mapped,
 					Partial: false,
 				}
 			}
 
-			aggregationErrors = append(aggregationErrors, mapped)
+			aggregationErrors = append(aggregationErrors, mapped...)
 
 			continue
 		}
@@ -109,8 +213,17 @@ func (a *defaultAggregator) mergeResponses(responses []UpstreamResponse, allowPa
 			continue
 		}
 
-		// Handle JSON unmarshaling error as internal.
-		if err := json.Unmarshal(resp.Body, &obj); err != nil {
+		// Handle decoding and JSON unmarshaling errors as internal.
+		body, err := decodeBody(resp.Headers, resp.Body)
+		if err == nil {
+			err = json.Unmarshal(body, &v)
+		}
+
+		if _, ok := v.(map[string]any); err == nil && !ok && v != nil && resp.Upstream == "" {
+			err = errors.New("response of an upstream without alias is not a JSON object")
+		}
+
+		if err != nil {
 			a.log.Warn(
 				"failed to unmarshal response",
 				zap.Bool("allow_partial_results", allowPartialResults),
@@ -129,7 +242,17 @@ func (a *defaultAggregator) mergeResponses(responses []UpstreamResponse, allowPa
 			continue
 		}
 
-		maps.Copy(merged, obj)
+		// Values which are not objects, like plain text, are merged under the alias of their upstream.
+		switch obj := v.(type) {
+		case nil:
+		case map[string]any:
+			maps.Copy(merged, obj)
+		default:
+			merged[resp.Upstream] = obj
+		}
+
+		aggregationErrors = append(aggregationErrors, resp.Errors...)
+		fallback = fallback || resp.Fallback
 	}
 
 	data, err := json.Marshal(merged)
@@ -138,9 +261,10 @@ func (a *defaultAggregator) mergeResponses(responses []UpstreamResponse, allowPa
 	}
 
 	aggregationResponse := AggregatedResponse{
-		Data:    data,
-		Errors:  dedupeErrors(aggregationErrors),
-		Partial: len(aggregationErrors) > 0,
+		Data:     data,
+		Errors:   dedupeErrors(aggregationErrors),
+		Partial:  len(aggregationErrors) > 0,
+		Fallback: fallback,
 	}
 
 	return aggregationResponse
@@ -149,29 +273,37 @@ func (a *defaultAggregator) mergeResponses(responses []UpstreamResponse, allowPa
 func (a *defaultAggregator) arrayOfResponses(responses []UpstreamResponse, allowPartialResults bool) AggregatedResponse {
 	var arr []json.RawMessage
 
-	var aggregationErrors []JSONError
+	var (
+		aggregationErrors []JSONError
+		fallback          bool
+	)
 
 	for _, resp := range responses {
+		// Skipped upstreams are neither results nor failures.
+		if resp.Skipped {
+			continue
+		}
+
 		// Handle upstream error.
 		if resp.Err != nil {
-			mapped := a.mapUpstreamError(resp.Err)
+			mapped := a.upstreamErrors(resp)
 
 			a.log.Warn(
 				"upstream has errors",
 				zap.Bool("allow_partial_results", allowPartialResults),
 				zap.String("upstream_error", resp.Err.Unwrap().Error()),
-				zap.String("mapped_error", mapped.Message),
+				zap.String("mapped_error", mapped[0].Message),
 			)
 
 			if !allowPartialResults {
 				return AggregatedResponse{
 					Data:    nil,
-					Errors:  []JSONError{mapped},
+					Errors:  mapped,
 					Partial: false,
 				}
 			}
 
-			aggregationErrors = append(aggregationErrors, mapped)
+			aggregationErrors = append(aggregationErrors, mapped...)
 
 			continue
 		}
@@ -180,7 +312,30 @@ func (a *defaultAggregator) arrayOfResponses(responses []UpstreamResponse, allow
 			continue
 		}
 
-		arr = append(arr, resp.Body)
+		body, err := decodeBody(resp.Headers, resp.Body)
+		if err != nil {
+			a.log.Warn(
+				"failed to decode response",
+				zap.Bool("allow_partial_results", allowPartialResults),
+				zap.Error(err),
+			)
+
+			if !allowPartialResults {
+				return jsonParseError()
+			}
+
+			aggregationErrors = append(aggregationErrors, JSONError{
+				Code:    ErrorCodeUpstreamMalformed,
+				Message: "upstream malformed",
+			})
+
+			continue
+		}
+
+		arr = append(arr, body)
+
+		aggregationErrors = append(aggregationErrors, resp.Errors...)
+		fallback = fallback || resp.Fallback
 	}
 
 	data, err := json.Marshal(arr)
@@ -189,14 +344,65 @@ func (a *defaultAggregator) arrayOfResponses(responses []UpstreamResponse, allow
 	}
 
 	aggregationResponse := AggregatedResponse{
-		Data:    data,
-		Errors:  dedupeErrors(aggregationErrors),
-		Partial: len(aggregationErrors) > 0,
+		Data:     data,
+		Errors:   dedupeErrors(aggregationErrors),
+		Partial:  len(aggregationErrors) > 0,
+		Fallback: fallback,
 	}
 
 	return aggregationResponse
 }
 
+// firstSuccessfulResponse returns the first successful response. If there is none,
+// all upstream errors are returned.
+func (a *defaultAggregator) firstSuccessfulResponse(responses []UpstreamResponse) AggregatedResponse {
+	var aggregationErrors []JSONError
+
+	for _, resp := range responses {
+		if resp.Skipped {
+			continue
+		}
+
+		if resp.Err != nil {
+			a.log.Warn("upstream has errors", zap.Error(resp.Err.Unwrap()))
+
+			aggregationErrors = append(aggregationErrors, a.upstreamErrors(resp)...)
+
+			continue
+		}
+
+		body, err := decodeBody(resp.Headers, resp.Body)
+		if err != nil {
+			a.log.Warn("failed to decode response", zap.Error(err))
+
+			return jsonParseError()
+		}
+
+		return AggregatedResponse{
+			Data:     body,
+			Errors:   slices.Clone(resp.Errors),
+			Partial:  len(resp.Errors) > 0,
+			Fallback: resp.Fallback,
+		}
+	}
+
+	return AggregatedResponse{
+		Data:    nil,
+		Errors:  dedupeErrors(aggregationErrors),
+		Partial: false,
+	}
+}
+
+// upstreamErrors returns the errors of a failed upstream response: the errors reported by the upstream
+// if there are any, or the mapped upstream error otherwise.
+func (a *defaultAggregator) upstreamErrors(resp UpstreamResponse) []JSONError {
+	if len(resp.Errors) > 0 {
+		return slices.Clone(resp.Errors)
+	}
+
+	return []JSONError{a.mapUpstreamError(resp.Err)}
+}
+
 func (a *defaultAggregator) mapUpstreamError(err error) JSONError {
 	var ue *UpstreamError
 
@@ -218,6 +424,41 @@ func (a *defaultAggregator) mapUpstreamError(err error) JSONError {
 			Code:    ErrorCodeUpstreamError,
 			Message: "upstream error",
 		}
+	case UpstreamMalformed:
+		return JSONError{
+			Code:    ErrorCodeUpstreamMalformed,
+			Message: "upstream malformed",
+		}
+	case UpstreamDependencyFailed:
+		return JSONError{
+			Code:    ErrorCodeDependencyFailed,
+			Message: "upstream dependency failed",
+		}
+	case UpstreamCircuitOpen:
+		return JSONError{
+			Code:    ErrorCodeUpstreamCircuitOpen,
+			Message: "upstream circuit breaker is open",
+		}
+	case UpstreamBodyTooLarge:
+		return JSONError{
+			Code:    ErrorCodeUpstreamTooLarge,
+			Message: "upstream response too large",
+		}
+	case UpstreamReadError:
+		return JSONError{
+			Code:    ErrorCodeUpstreamReadFailed,
+			Message: "cannot read upstream response",
+		}
+	case UpstreamCanceled:
+		return JSONError{
+			Code:    ErrorCodeUpstreamCanceled,
+			Message: "upstream request canceled",
+		}
+	case UpstreamPolicyViolation:
+		return JSONError{
+			Code:    ErrorCodePolicyViolation,
+			Message: "upstream response violates policy",
+		}
 	default:
 		return JSONError{
 			Code:    ErrorCodeInternal,
@@ -253,15 +494,15 @@ func jsonParseError() AggregatedResponse {
 }
 
 func dedupeErrors(errs []JSONError) []JSONError {
-	seen := make(map[string]struct{})
+	seen := make(map[JSONError]struct{})
 	out := make([]JSONError, 0, len(errs))
 
 	for _, e := range errs {
-		if _, ok := seen[e.Code]; ok {
+		if _, ok := seen[e]; ok {
 			continue
 		}
 
-		seen[e.Code] = struct{}{}
+		seen[e] = struct{}{}
 		out = append(out, e)
 	}
 
@@ -2,6 +2,8 @@ package tokka
 
 import (
 	"encoding/json"
+	"errors"
+	"net/http"
 	"reflect"
 	"testing"
 
@@ -153,3 +155,121 @@ func TestAggregator_RawResponse(t *testing.T) {
 		t.Errorf("got %s, want %s", string(aggregated.Data), string([]byte(`{"a":1}`)))
 	}
 }
+
+func TestAggregator_FirstSuccess(t *testing.T) {
+	agg := newTestAggregator()
+
+	responses := []UpstreamResponse{
+		{Err: &UpstreamError{Kind: UpstreamConnection, Err: errors.New("connection refused")}},
+		{Body: []byte(`{"a":1}`)},
+		{Skipped: true},
+	}
+
+	aggregated := agg.aggregate(responses, AggregationConfig{Strategy: strategyFirstSuccess})
+
+	if string(aggregated.Data) != `{"a":1}` || len(aggregated.Errors) != 0 {
+		t.Errorf("unexpected aggregation: %s %+v", aggregated.Data, aggregated.Errors)
+	}
+
+	aggregated = agg.aggregate([]UpstreamResponse{responses[0], responses[2]}, AggregationConfig{Strategy: strategyRace})
+
+	if aggregated.Data != nil || len(aggregated.Errors) != 1 || aggregated.Errors[0].Code != ErrorCodeUpstreamUnavailable {
+		t.Errorf("unexpected aggregation: %s %+v", aggregated.Data, aggregated.Errors)
+	}
+}
+
+func TestAggregator_ErrorMapping(t *testing.T) {
+	agg := newTestAggregator()
+
+	responses := []UpstreamResponse{
+		{Status: http.StatusOK, Body: []byte(`{"a":1}`), Name: "profile"},
+		{
+			Name:     "order",
+			Upstream: "orders",
+			Err:      &UpstreamError{Kind: UpstreamPolicyViolation, StatusCode: http.StatusNotFound, Err: errors.New("status 404 not allowed")},
+		},
+		{
+			Name: "billing",
+			Err:  &UpstreamError{Kind: UpstreamCircuitOpen, Err: errors.New("upstream circuit breaker is open")},
+		},
+	}
+
+	aggregation := AggregationConfig{
+		Strategy: strategyMerge,
+		ErrorMapping: []ErrorMappingConfig{
+			{Upstream: "order", UpstreamStatus: http.StatusNotFound, Status: http.StatusNotFound, Code: "ORDER_NOT_FOUND", Message: "order not found"},
+			{Kind: string(UpstreamCircuitOpen), Status: http.StatusServiceUnavailable},
+		},
+	}
+
+	res := agg.aggregate(responses, aggregation)
+	if res.Partial || res.Status != http.StatusNotFound {
+		t.Fatalf("expected failed response with status 404, got %+v", res)
+	}
+
+	// Rules match upstream names, errors report aliases only.
+	expected := []JSONError{{Code: "ORDER_NOT_FOUND", Message: "order not found", Upstream: "orders"}}
+	if !reflect.DeepEqual(res.Errors, expected) {
+		t.Errorf("expected errors %+v, got %+v", expected, res.Errors)
+	}
+
+	aggregation.AllowPartialResults = true
+
+	res = agg.aggregate(responses, aggregation)
+	if !res.Partial || res.Status != 0 {
+		t.Fatalf("expected partial response without status, got %+v", res)
+	}
+
+	expected = append(expected, JSONError{Code: ErrorCodeUpstreamCircuitOpen, Message: "upstream circuit breaker is open"})
+	if !reflect.DeepEqual(res.Errors, expected) {
+		t.Errorf("expected errors %+v, got %+v", expected, res.Errors)
+	}
+
+	if responses[1].Errors != nil {
+		t.Errorf("expected responses not to be modified, got %+v", responses[1].Errors)
+	}
+}
+
+func TestAggregator_Merge_DecodedBodies(t *testing.T) {
+	agg := newTestAggregator()
+
+	responses := []UpstreamResponse{
+		{Upstream: "profile", Body: []byte(`{"id":1}`), Headers: http.Header{"Content-Type": []string{"application/json"}}},
+		{
+			Upstream: "legacy",
+			Body:     []byte(`<?xml version="1.0"?><account xmlns="urn:legacy" type="gold"><balance>10.5</balance><card>1</card><card>2</card></account>`),
+			Headers:  http.Header{"Content-Type": []string{"application/xml; charset=utf-8"}},
+		},
+		{Upstream: "settings", Body: []byte(`theme=dark&lang=en&lang=de`), Headers: http.Header{"Content-Type": []string{"application/x-www-form-urlencoded"}}},
+		{Upstream: "motd", Body: []byte(`hello`), Headers: http.Header{"Content-Type": []string{"text/plain; charset=utf-8"}}},
+	}
+
+	aggregated := agg.aggregate(responses, AggregationConfig{Strategy: strategyMerge})
+	if len(aggregated.Errors) > 0 {
+		t.Fatalf("unexpected errors: %+v", aggregated.Errors)
+	}
+
+	var got map[string]any
+	if err := json.Unmarshal(aggregated.Data, &got); err != nil {
+		t.Fatalf("failed to unmarshal result: %v", err)
+	}
+
+	want := map[string]any{
+		"id":      float64(1),
+		"account": map[string]any{"@type": "gold", "balance": "10.5", "card": []any{"1", "2"}},
+		"theme":   "dark",
+		"lang":    []any{"en", "de"},
+		"motd":    "hello",
+	}
+
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+
+	responses[1].Body = []byte(`<account><balance>`)
+
+	aggregated = agg.aggregate(responses, AggregationConfig{Strategy: strategyMerge})
+	if len(aggregated.Errors) != 1 || aggregated.Errors[0].Code != ErrorCodeUpstreamMalformed {
+		t.Errorf("expected malformed error, got %+v", aggregated.Errors)
+	}
+}
@@ -0,0 +1,139 @@
+package tokka
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/starwalkn/tokka/internal/circuitbreaker"
+)
+
+// Manual circuit breaker actions.
+const (
+	CircuitBreakerForceOpen  = "force_open"
+	CircuitBreakerForceClose = "force_close"
+	CircuitBreakerReset      = "reset"
+)
+
+var (
+	ErrCircuitBreakerNotFound      = errors.New("circuit breaker not found")
+	ErrUnknownCircuitBreakerAction = errors.New("unknown circuit breaker action")
+)
+
+// recordBreakerOutcome records the outcome of a call in the circuit breaker of its upstream. Calls canceled
+// by the caller, like race losers, hedges or calls of requests past their deadline, say nothing about the
+// upstream health, so they only release the half-open trial slot.
+func recordBreakerOutcome(
+	ctx context.Context,
+	cb *circuitbreaker.CircuitBreaker,
+	uerr *UpstreamError,
+	failure bool,
+	duration time.Duration,
+) {
+	if ctx.Err() != nil || (uerr != nil && uerr.Kind == UpstreamCanceled) {
+		cb.Release()
+		return
+	}
+
+	cb.Record(failure, duration)
+}
+
+// CircuitBreakerStatus describes the circuit breaker of a route upstream.
+type CircuitBreakerStatus struct {
+	Route       string     `json:"route"`
+	Upstream    string     `json:"upstream"`
+	State       string     `json:"state"`
+	Forced      bool       `json:"forced"`
+	Failures    int        `json:"failures"`
+	Requests    int        `json:"requests"`
+	NextRetryAt *time.Time `json:"next_retry_at,omitempty"`
+}
+
+// CircuitBreakers returns the status of all upstream circuit breakers.
+func (r *Router) CircuitBreakers() []CircuitBreakerStatus {
+	statuses := make([]CircuitBreakerStatus, 0)
+
+	r.eachCircuitBreaker(func(route *Route, upstream string, cb *circuitbreaker.CircuitBreaker) {
+		s := cb.Snapshot()
+
+		status := CircuitBreakerStatus{
+			Route:    route.Method + " " + route.Path,
+			Upstream: upstream,
+			State:    s.State.String(),
+			Forced:   s.Forced,
+			Failures: s.Failures,
+			Requests: s.Requests,
+		}
+
+		if !s.NextRetryAt.IsZero() {
+			status.NextRetryAt = &s.NextRetryAt
+		}
+
+		statuses = append(statuses, status)
+	})
+
+	return statuses
+}
+
+// ControlCircuitBreaker applies a manual action to all circuit breakers of the upstream with the given name.
+func (r *Router) ControlCircuitBreaker(upstream, action string) error {
+	var apply func(cb *circuitbreaker.CircuitBreaker)
+
+	switch action {
+	case CircuitBreakerForceOpen:
+		apply = (*circuitbreaker.CircuitBreaker).ForceOpen
+	case CircuitBreakerForceClose:
+		apply = (*circuitbreaker.CircuitBreaker).ForceClose
+	case CircuitBreakerReset:
+		apply = (*circuitbreaker.CircuitBreaker).Reset
+	default:
+		return ErrUnknownCircuitBreakerAction
+	}
+
+	found := false
+
+	r.eachCircuitBreaker(func(_ *Route, name string, cb *circuitbreaker.CircuitBreaker) {
+		if name != upstream {
+			return
+		}
+
+		found = true
+
+		apply(cb)
+	})
+
+	if !found {
+		return ErrCircuitBreakerNotFound
+	}
+
+	r.log.Warn("upstream circuit breaker controlled manually", zap.String("upstream", upstream), zap.String("action", action))
+
+	return nil
+}
+
+func (r *Router) eachCircuitBreaker(fn func(route *Route, upstream string, cb *circuitbreaker.CircuitBreaker)) {
+	for i := range r.Routes {
+		for _, u := range r.Routes[i].Upstreams {
+			switch u := u.(type) {
+			case *httpUpstream:
+				if u.circuitBreaker != nil {
+					fn(&r.Routes[i], u.name, u.circuitBreaker)
+				}
+			case *grpcUpstream:
+				if u.circuitBreaker != nil {
+					fn(&r.Routes[i], u.name, u.circuitBreaker)
+				}
+			case *graphqlUpstream:
+				if u.circuitBreaker != nil {
+					fn(&r.Routes[i], u.name, u.circuitBreaker)
+				}
+			case *staticUpstream:
+				if u.circuitBreaker != nil {
+					fn(&r.Routes[i], u.name, u.circuitBreaker)
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,104 @@
+package tokka
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/starwalkn/tokka/internal/circuitbreaker"
+)
+
+func TestRouter_ControlCircuitBreaker(t *testing.T) {
+	router := &Router{
+		Routes: []Route{
+			{
+				Method: "GET",
+				Path:   "/users",
+				Upstreams: []Upstream{
+					&httpUpstream{name: "users", circuitBreaker: circuitbreaker.New(1, time.Hour)},
+					&httpUpstream{name: "orders"},
+				},
+			},
+		},
+		log: zap.NewNop(),
+	}
+
+	if err := router.ControlCircuitBreaker("users", CircuitBreakerForceOpen); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	statuses := router.CircuitBreakers()
+	if len(statuses) != 1 {
+		t.Fatalf("expected 1 circuit breaker, got %d", len(statuses))
+	}
+
+	if statuses[0].Route != "GET /users" || statuses[0].State != "open" || !statuses[0].Forced {
+		t.Errorf("unexpected circuit breaker status: %+v", statuses[0])
+	}
+
+	if err := router.ControlCircuitBreaker("orders", CircuitBreakerReset); !errors.Is(err, ErrCircuitBreakerNotFound) {
+		t.Errorf("expected ErrCircuitBreakerNotFound, got %v", err)
+	}
+
+	if err := router.ControlCircuitBreaker("users", "explode"); !errors.Is(err, ErrUnknownCircuitBreakerAction) {
+		t.Errorf("expected ErrUnknownCircuitBreakerAction, got %v", err)
+	}
+}
+
+func TestHttpUpstream_Call_BreakerOutcome(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-time.After(200 * time.Millisecond):
+		case <-r.Context().Done():
+		}
+	}))
+	defer upstream.Close()
+
+	cb := circuitbreaker.New(1, 10*time.Millisecond)
+	u := &httpUpstream{url: upstream.URL, timeout: time.Second, client: http.DefaultClient, circuitBreaker: cb}
+
+	call := func(ctx context.Context) *UpstreamResponse {
+		return u.Call(ctx, httptest.NewRequest(http.MethodGet, "/", nil), nil, UpstreamRetryPolicy{})
+	}
+
+	// Calls canceled by the caller are not recorded.
+	ctx, cancel := context.WithTimeout(t.Context(), 20*time.Millisecond)
+	call(ctx)
+	cancel()
+
+	if state := cb.State(); state != circuitbreaker.Closed {
+		t.Fatalf("expected closed breaker after canceled call, got %s", state)
+	}
+
+	// Timeouts of the upstream call are failures.
+	u.timeout = 20 * time.Millisecond
+
+	if resp := call(t.Context()); resp.Err == nil || resp.Err.Kind != UpstreamTimeout {
+		t.Fatalf("expected timeout, got %+v", resp.Err)
+	}
+
+	if state := cb.State(); state != circuitbreaker.Open {
+		t.Fatalf("expected open breaker after timeout, got %s", state)
+	}
+
+	// A canceled half-open probe neither closes the breaker nor keeps its slot.
+	time.Sleep(20 * time.Millisecond)
+
+	ctx, cancel = context.WithTimeout(t.Context(), 10*time.Millisecond)
+	u.timeout = time.Second
+	call(ctx)
+	cancel()
+
+	if state := cb.State(); state != circuitbreaker.HalfOpen {
+		t.Fatalf("expected half-open breaker after canceled probe, got %s", state)
+	}
+
+	if !cb.Allow() {
+		t.Error("expected canceled probe to release its slot")
+	}
+}
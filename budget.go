@@ -0,0 +1,42 @@
+package tokka
+
+import "sync"
+
+const budgetMaxTokens = 10 // Maximum number of extra requests which can be accumulated in a budget.
+
+// budget is a token bucket which limits extra requests (retries, hedges) relative to regular requests.
+// Every regular request deposits ratio tokens and every extra request withdraws one token.
+type budget struct {
+	mu     sync.Mutex
+	ratio  float64
+	tokens float64
+}
+
+func newBudget(ratio, initialTokens float64) *budget {
+	return &budget{
+		ratio:  ratio,
+		tokens: min(initialTokens, budgetMaxTokens),
+	}
+}
+
+// deposit adds the ratio share of an extra request to the budget.
+func (b *budget) deposit() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.tokens = min(b.tokens+b.ratio, budgetMaxTokens)
+}
+
+// withdraw takes an extra request from the budget. It reports false if the budget is exhausted.
+func (b *budget) withdraw() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.tokens < 1 {
+		return false
+	}
+
+	b.tokens--
+
+	return true
+}
@@ -1,20 +1,25 @@
 package tokka
 
 import (
+	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
+	"os"
+	"regexp"
 	"slices"
-	"time"
 
 	"go.uber.org/zap"
+	"golang.org/x/sync/singleflight"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/dynamicpb"
 
+	"github.com/starwalkn/tokka/internal/cache"
 	"github.com/starwalkn/tokka/internal/circuitbreaker"
 	"github.com/starwalkn/tokka/internal/metric"
 )
 
-func initMinimalRouter(routesCount int, log *zap.Logger) *Router {
-	metrics := metric.NewNop()
-
+func initMinimalRouter(routesCount int, metrics metric.Metrics, log *zap.Logger) *Router {
 	return &Router{
 		dispatcher: &defaultDispatcher{
 			log:     log.Named("dispatcher"),
@@ -89,53 +94,236 @@ func initPlugins(cfgs []PluginConfig, log *zap.Logger) []Plugin {
 	return plugins
 }
 
-func initUpstreams(cfgs []UpstreamConfig) []Upstream {
+func initUpstreams(cfgs []UpstreamConfig, metrics metric.Metrics, log *zap.Logger) []Upstream {
 	upstreams := make([]Upstream, 0, len(cfgs))
 
-	//nolint:mnd // be configurable in future
-	transport := &http.Transport{
-		MaxIdleConns:        100,
-		MaxIdleConnsPerHost: 10,
-		IdleConnTimeout:     90 * time.Second,
-		ForceAttemptHTTP2:   true,
-	}
+	upstreamTransports := make(transports)
+
+	// gRPC requires HTTP/2, which is negotiated with TLS or used with prior knowledge (h2c) otherwise.
+	grpcTransport := upstreamTransports.get(transportKey{h2c: true})
+
+	// Descriptor sets by path, so upstreams sharing one load it once.
+	descriptorSets := make(map[string]*protoregistry.Files)
 
 	for _, cfg := range cfgs {
+		conditions, err := initConditions(cfg.When)
+		if err != nil {
+			log.Fatal("invalid upstream conditions", zap.String("name", cfg.Name), zap.String("url", cfg.URL), zap.Error(err))
+		}
+
+		if cfg.Protocol != "" && cfg.Protocol != protocolH2C {
+			log.Fatal("unknown upstream protocol", zap.String("name", cfg.Name), zap.String("protocol", cfg.Protocol))
+		}
+
+		rawURL := cfg.URL
+
+		socket, err := resolveUnixUpstream(&cfg)
+		if err != nil {
+			log.Fatal("invalid unix socket upstream", zap.String("name", cfg.Name), zap.String("url", rawURL), zap.Error(err))
+		}
+
 		policy := UpstreamPolicy{
 			AllowedStatuses:     cfg.Policy.AllowedStatuses,
 			RequireBody:         cfg.Policy.RequireBody,
 			MapStatusCodes:      cfg.Policy.MapStatusCodes,
 			MaxResponseBodySize: cfg.Policy.MaxResponseBodySize,
 			RetryPolicy: UpstreamRetryPolicy{
-				MaxRetries:      cfg.Policy.RetryConfig.MaxRetries,
-				RetryOnStatuses: cfg.Policy.RetryConfig.RetryOnStatuses,
-				BackoffDelay:    cfg.Policy.RetryConfig.BackoffDelay,
+				MaxRetries:         cfg.Policy.RetryConfig.MaxRetries,
+				RetryOnStatuses:    cfg.Policy.RetryConfig.RetryOnStatuses,
+				BackoffDelay:       cfg.Policy.RetryConfig.BackoffDelay,
+				Backoff:            cfg.Policy.RetryConfig.Backoff,
+				BackoffMultiplier:  cfg.Policy.RetryConfig.BackoffMultiplier,
+				MaxBackoffDelay:    cfg.Policy.RetryConfig.MaxBackoffDelay,
+				Jitter:             cfg.Policy.RetryConfig.Jitter,
+				RespectRetryAfter:  cfg.Policy.RetryConfig.RespectRetryAfter,
+				RetryNonIdempotent: cfg.Policy.RetryConfig.RetryNonIdempotent,
+				BudgetRatio:        cfg.Policy.RetryConfig.BudgetRatio,
 			},
 			CircuitBreaker: UpstreamCircuitBreaker{
-				Enabled:      cfg.Policy.CircuitBreakerConfig.Enabled,
-				MaxFailures:  cfg.Policy.CircuitBreakerConfig.MaxFailures,
-				ResetTimeout: cfg.Policy.CircuitBreakerConfig.ResetTimeout,
+				Enabled:               cfg.Policy.CircuitBreakerConfig.Enabled,
+				MaxFailures:           cfg.Policy.CircuitBreakerConfig.MaxFailures,
+				ResetTimeout:          cfg.Policy.CircuitBreakerConfig.ResetTimeout,
+				FailureRateThreshold:  cfg.Policy.CircuitBreakerConfig.FailureRateThreshold,
+				SlowCallRateThreshold: cfg.Policy.CircuitBreakerConfig.SlowCallRateThreshold,
+				SlowCallDuration:      cfg.Policy.CircuitBreakerConfig.SlowCallDuration,
+				WindowType:            cfg.Policy.CircuitBreakerConfig.WindowType,
+				WindowSize:            cfg.Policy.CircuitBreakerConfig.WindowSize,
+				WindowDuration:        cfg.Policy.CircuitBreakerConfig.WindowDuration,
+				MinimumRequests:       cfg.Policy.CircuitBreakerConfig.MinimumRequests,
+				HalfOpenProbes:        cfg.Policy.CircuitBreakerConfig.HalfOpenProbes,
+			},
+			Hedging: UpstreamHedgingPolicy{
+				Enabled:      cfg.Policy.HedgingConfig.Enabled,
+				URLs:         cfg.Policy.HedgingConfig.URLs,
+				Delay:        cfg.Policy.HedgingConfig.Delay,
+				Percentile:   cfg.Policy.HedgingConfig.Percentile,
+				MaxExtraLoad: cfg.Policy.HedgingConfig.MaxExtraLoad,
+			},
+			RequestTransform: UpstreamRequestTransform{
+				Rename:   cfg.Transform.Request.Rename,
+				Remove:   cfg.Transform.Request.Remove,
+				Set:      cfg.Transform.Request.Set,
+				Template: cfg.Transform.Request.Template,
+			},
+			ResponseTransform: UpstreamResponseTransform{
+				Extract: cfg.Transform.Response.Extract,
+				Allow:   cfg.Transform.Response.Allow,
+				Deny:    cfg.Transform.Response.Deny,
+				Rename:  cfg.Transform.Response.Rename,
+				Flatten: cfg.Transform.Response.Flatten,
+			},
+			Cache: UpstreamCachePolicy{
+				Enabled:              cfg.Policy.CacheConfig.Enabled,
+				TTL:                  cfg.Policy.CacheConfig.TTL,
+				StaleWhileRevalidate: cfg.Policy.CacheConfig.StaleWhileRevalidate,
+				StaleIfError:         cfg.Policy.CacheConfig.StaleIfError,
+				MaxEntries:           cfg.Policy.CacheConfig.MaxEntries,
+				MaxSize:              cfg.Policy.CacheConfig.MaxSize,
+				KeyHeaders:           cfg.Policy.CacheConfig.Key.Headers,
+				KeyQuery:             cfg.Policy.CacheConfig.Key.Query,
+				KeyClaims:            cfg.Policy.CacheConfig.Key.Claims,
+				KeyIgnoreHeaders:     cfg.Policy.CacheConfig.Key.IgnoreHeaders,
 			},
+			Coalescing: UpstreamCoalescingPolicy{
+				Enabled:       cfg.Policy.CoalescingConfig.Enabled,
+				IgnoreHeaders: cfg.Policy.CoalescingConfig.IgnoreHeaders,
+			},
+			DependsOn: cfg.DependsOn,
+			When:      conditions,
+			Alias:     cfg.Alias,
+		}
+
+		if policy.Cache.Enabled {
+			policy.Cache.store = cache.New[*cacheEntry](policy.Cache.MaxEntries, policy.Cache.MaxSize)
+		}
+
+		name := cfg.Name
+		if name == "" {
+			name = fmt.Sprintf("%s_%s", cfg.Method, rawURL)
+		}
+
+		if err = validateCircuitBreaker(policy.CircuitBreaker); err != nil {
+			log.Fatal("invalid upstream circuit breaker", zap.String("name", cfg.Name), zap.String("url", rawURL), zap.Error(err))
 		}
 
 		var circuitBreaker *circuitbreaker.CircuitBreaker
 		if policy.CircuitBreaker.Enabled {
-			circuitBreaker = circuitbreaker.New(policy.CircuitBreaker.MaxFailures, policy.CircuitBreaker.ResetTimeout)
+			circuitBreaker = initCircuitBreaker(name, policy.CircuitBreaker, metrics, log)
+		}
+
+		if err = validateRetryPolicy(policy.RetryPolicy); err != nil {
+			log.Fatal("invalid upstream retry policy", zap.String("name", cfg.Name), zap.String("url", rawURL), zap.Error(err))
+		}
+
+		if request := policy.RequestTransform; request.Template != nil && (len(request.Rename) > 0 || len(request.Remove) > 0 || len(request.Set) > 0) {
+			log.Fatal("request transform template excludes rename, remove and set", zap.String("name", cfg.Name), zap.String("url", rawURL))
+		}
+
+		var retryBudget *budget
+		if policy.RetryPolicy.BudgetRatio > 0 {
+			retryBudget = newBudget(policy.RetryPolicy.BudgetRatio, budgetMaxTokens)
+		}
+
+		var hedging *hedger
+		if policy.Hedging.Enabled && len(policy.Hedging.URLs) > 0 {
+			hedging = newHedger(policy.Hedging)
+		}
+
+		if isGRPCURL(cfg.URL) {
+			upstream, err := initGRPCUpstream(cfg, name, policy, descriptorSets)
+			if err != nil {
+				log.Fatal("invalid grpc upstream", zap.String("name", cfg.Name), zap.String("url", rawURL), zap.Error(err))
+			}
+
+			upstream.client = &http.Client{Transport: grpcTransport}
+			upstream.circuitBreaker = circuitBreaker
+
+			upstreams = append(upstreams, upstream)
+
+			continue
+		}
+
+		var coalescing *singleflight.Group
+		if policy.Coalescing.Enabled {
+			coalescing = &singleflight.Group{}
+		}
+
+		method, contentType := cfg.Method, ""
+
+		if cfg.GraphQL.Query != "" {
+			if method != "" && method != http.MethodPost {
+				log.Fatal("graphql upstreams support only POST method", zap.String("name", cfg.Name), zap.String("method", method))
+			}
+
+			method, contentType = http.MethodPost, "application/json"
+		}
+
+		// Request transforms produce JSON bodies, except for text templates.
+		if _, text := cfg.Transform.Request.Template.(string); policy.RequestTransform.enabled() && !text {
+			contentType = "application/json"
 		}
 
 		upstream := &httpUpstream{
-			name:                fmt.Sprintf("%s_%s", cfg.Method, cfg.URL),
+			name:                name,
 			url:                 cfg.URL,
-			method:              cfg.Method,
+			method:              method,
 			timeout:             cfg.Timeout,
 			headers:             cfg.Headers,
 			forwardHeaders:      cfg.ForwardHeaders,
 			forwardQueryStrings: cfg.ForwardQueryStrings,
+			contentType:         contentType,
 			policy:              policy,
 			client: &http.Client{
-				Transport: transport,
+				Transport: upstreamTransports.get(transportKey{socket: socket, h2c: cfg.Protocol == protocolH2C}),
 			},
 			circuitBreaker: circuitBreaker,
+			hedger:         hedging,
+			retryBudget:    retryBudget,
+			coalescing:     coalescing,
+			metrics:        metrics,
+		}
+
+		if cfg.Static.Enabled {
+			if cfg.GraphQL.Query != "" || cfg.Policy.FallbackConfig.URL != "" {
+				log.Fatal("static upstreams support neither graphql nor fallback url", zap.String("name", cfg.Name))
+			}
+
+			static, err := newStaticTransport(cfg.Static)
+			if err != nil {
+				log.Fatal("invalid static upstream", zap.String("name", cfg.Name), zap.Error(err))
+			}
+
+			upstream.client = &http.Client{
+				Transport: static,
+				// Configured redirects are returned as is.
+				CheckRedirect: func(*http.Request, []*http.Request) error {
+					return http.ErrUseLastResponse
+				},
+			}
+		}
+
+		if cfg.Policy.FallbackConfig.Enabled {
+			upstream.policy.Fallback, err = initFallback(cfg.Policy.FallbackConfig, upstream)
+			if err != nil {
+				log.Fatal("invalid upstream fallback", zap.String("name", cfg.Name), zap.String("url", rawURL), zap.Error(err))
+			}
+		}
+
+		if cfg.Static.Enabled {
+			upstreams = append(upstreams, &staticUpstream{httpUpstream: upstream})
+
+			continue
+		}
+
+		if cfg.GraphQL.Query != "" {
+			// The alternate upstream serves the same query.
+			if alternate, ok := upstream.policy.Fallback.alternate.(*httpUpstream); ok {
+				upstream.policy.Fallback.alternate = newGraphQLUpstream(alternate, cfg.GraphQL)
+			}
+
+			upstreams = append(upstreams, newGraphQLUpstream(upstream, cfg.GraphQL))
+
+			continue
 		}
 
 		upstreams = append(upstreams, upstream)
@@ -144,7 +332,13 @@ func initUpstreams(cfgs []UpstreamConfig) []Upstream {
 	return upstreams
 }
 
-func initRoute(cfg RouteConfig, globalMiddlewares []Middleware, globalMiddlewareIndices map[string]int, log *zap.Logger) Route {
+func initRoute(
+	cfg RouteConfig,
+	globalMiddlewares []Middleware,
+	globalMiddlewareIndices map[string]int,
+	metrics metric.Metrics,
+	log *zap.Logger,
+) Route {
 	var (
 		globalMiddlewaresCopy = append([]Middleware(nil), globalMiddlewares...)
 		localMiddlewares      = make([]Middleware, 0, len(cfg.Middlewares))
@@ -176,13 +370,448 @@ func initRoute(cfg RouteConfig, globalMiddlewares []Middleware, globalMiddleware
 
 	middlewares := append(globalMiddlewaresCopy, localMiddlewares...) //nolint:gocritic // because i am retard
 
+	if err := validateUpstreamDependencies(cfg.Upstreams, cfg.Aggregation.Strategy); err != nil {
+		log.Fatal("invalid upstream dependencies", zap.String("route", cfg.Method+" "+cfg.Path), zap.Error(err))
+	}
+
+	if err := validateRouteMode(cfg); err != nil {
+		log.Fatal("invalid route mode", zap.String("route", cfg.Method+" "+cfg.Path), zap.Error(err))
+	}
+
+	if err := validateResponseMode(cfg); err != nil {
+		log.Fatal("invalid route response mode", zap.String("route", cfg.Method+" "+cfg.Path), zap.Error(err))
+	}
+
+	if err := validatePropagation(cfg); err != nil {
+		log.Fatal("invalid route propagation", zap.String("route", cfg.Method+" "+cfg.Path), zap.Error(err))
+	}
+
+	if err := validateErrorMapping(cfg); err != nil {
+		log.Fatal("invalid route error mapping", zap.String("route", cfg.Method+" "+cfg.Path), zap.Error(err))
+	}
+
 	return Route{
 		Path:                 cfg.Path,
 		Method:               cfg.Method,
-		Upstreams:            initUpstreams(cfg.Upstreams),
+		Mode:                 cfg.Mode,
+		ResponseMode:         cfg.ResponseMode,
+		Upstreams:            initUpstreams(cfg.Upstreams, metrics, log),
 		Aggregation:          cfg.Aggregation,
 		MaxParallelUpstreams: cfg.MaxParallelUpstreams,
+		MaxBodySize:          cfg.MaxBodySize,
+		WebSocket:            cfg.WebSocket,
+		SSE:                  cfg.SSE,
+		Propagate:            cfg.Propagate,
 		Plugins:              initPlugins(cfg.Plugins, log),
 		Middlewares:          middlewares,
 	}
 }
+
+func validateRouteMode(cfg RouteConfig) error {
+	switch cfg.Mode {
+	case "", routeModeBuffered:
+		return nil
+	case routeModeStream, routeModeWebSocket:
+		if len(cfg.Upstreams) != 1 {
+			return fmt.Errorf("%s mode requires exactly one upstream, got %d", cfg.Mode, len(cfg.Upstreams))
+		}
+
+		// The upstream is proxied as is, which only plain HTTP upstreams support.
+		switch u := cfg.Upstreams[0]; {
+		case isGRPCURL(u.URL):
+			return fmt.Errorf("%s mode does not support grpc upstreams", cfg.Mode)
+		case u.GraphQL.Query != "":
+			return fmt.Errorf("%s mode does not support graphql upstreams", cfg.Mode)
+		case u.Static.Enabled:
+			return fmt.Errorf("%s mode does not support static upstreams", cfg.Mode)
+		case cfg.Mode == routeModeWebSocket && u.Protocol == protocolH2C:
+			// The HTTP/1.1 upgrade handshake cannot run over an HTTP/2 only transport.
+			return errors.New("websocket mode does not support h2c upstreams")
+		}
+
+		return nil
+	default:
+		return fmt.Errorf("unknown route mode %q", cfg.Mode)
+	}
+}
+
+func validateResponseMode(cfg RouteConfig) error {
+	switch cfg.ResponseMode {
+	case "", responseModeEnvelope:
+		return nil
+	case responseModeRaw:
+		if cfg.Mode != "" && cfg.Mode != routeModeBuffered {
+			return fmt.Errorf("raw responses require buffered mode, got %q", cfg.Mode)
+		}
+
+		if len(cfg.Upstreams) > 1 && cfg.Aggregation.Strategy != strategyFirstSuccess && cfg.Aggregation.Strategy != strategyRace {
+			return errors.New("raw responses of several upstreams require the first_success or race strategy")
+		}
+
+		return nil
+	default:
+		return fmt.Errorf("unknown response mode %q", cfg.ResponseMode)
+	}
+}
+
+func validatePropagation(cfg RouteConfig) error {
+	// Raw responses keep the status of the returned upstream response.
+	if cfg.ResponseMode == responseModeRaw && (cfg.Propagate.Status || cfg.Propagate.StatusUpstream != "") {
+		return errors.New("raw responses do not support status propagation")
+	}
+
+	if cfg.Propagate.StatusUpstream != "" {
+		if !slices.ContainsFunc(cfg.Upstreams, func(u UpstreamConfig) bool { return u.Name == cfg.Propagate.StatusUpstream }) {
+			return fmt.Errorf("unknown status upstream %q", cfg.Propagate.StatusUpstream)
+		}
+
+		return nil
+	}
+
+	if cfg.Propagate.Status && len(cfg.Upstreams) != 1 {
+		return errors.New("status_upstream is required to propagate the status of multiple upstreams")
+	}
+
+	return nil
+}
+
+func validateErrorMapping(cfg RouteConfig) error {
+	for i, rule := range cfg.Aggregation.ErrorMapping {
+		if rule.Upstream == "" && rule.Kind == "" && rule.UpstreamStatus == 0 {
+			return fmt.Errorf("rule %d has no conditions", i)
+		}
+
+		if rule.Upstream != "" && !slices.ContainsFunc(cfg.Upstreams, func(u UpstreamConfig) bool { return u.Name == rule.Upstream }) {
+			return fmt.Errorf("rule %d: unknown upstream %q", i, rule.Upstream)
+		}
+
+		if rule.Kind != "" && !slices.Contains(upstreamErrorKinds, UpstreamErrorKind(rule.Kind)) {
+			return fmt.Errorf("rule %d: unknown error kind %q", i, rule.Kind)
+		}
+
+		if rule.Status != 0 && (rule.Status < 100 || rule.Status > 599) { //nolint:mnd // valid status range
+			return fmt.Errorf("rule %d: invalid status %d", i, rule.Status)
+		}
+	}
+
+	return nil
+}
+
+func validateRetryPolicy(policy UpstreamRetryPolicy) error {
+	switch policy.Backoff {
+	case "", backoffFixed, backoffExponential:
+	default:
+		return fmt.Errorf("unknown backoff %q", policy.Backoff)
+	}
+
+	switch policy.Jitter {
+	case "", jitterNone, jitterFull, jitterDecorrelated:
+	default:
+		return fmt.Errorf("unknown jitter %q", policy.Jitter)
+	}
+
+	return nil
+}
+
+func validateCircuitBreaker(policy UpstreamCircuitBreaker) error {
+	switch policy.WindowType {
+	case "", circuitbreaker.WindowCount, circuitbreaker.WindowTime:
+	default:
+		return fmt.Errorf("unknown window type %q", policy.WindowType)
+	}
+
+	if policy.FailureRateThreshold < 0 || policy.FailureRateThreshold > 100 {
+		return fmt.Errorf("failure rate threshold %v is out of range [0, 100]", policy.FailureRateThreshold)
+	}
+
+	if policy.SlowCallRateThreshold < 0 || policy.SlowCallRateThreshold > 100 {
+		return fmt.Errorf("slow call rate threshold %v is out of range [0, 100]", policy.SlowCallRateThreshold)
+	}
+
+	if policy.SlowCallRateThreshold > 0 && policy.SlowCallDuration <= 0 {
+		return errors.New("slow call rate threshold requires slow call duration")
+	}
+
+	return nil
+}
+
+// initCircuitBreaker creates an upstream circuit breaker which logs its state transitions and exports its state in metrics.
+func initCircuitBreaker(
+	upstream string,
+	policy UpstreamCircuitBreaker,
+	metrics metric.Metrics,
+	log *zap.Logger,
+) *circuitbreaker.CircuitBreaker {
+	cb := circuitbreaker.NewWithConfig(circuitbreaker.Config{
+		MaxFailures:           policy.MaxFailures,
+		ResetTimeout:          policy.ResetTimeout,
+		FailureRateThreshold:  policy.FailureRateThreshold,
+		SlowCallRateThreshold: policy.SlowCallRateThreshold,
+		SlowCallDuration:      policy.SlowCallDuration,
+		WindowType:            policy.WindowType,
+		WindowSize:            policy.WindowSize,
+		WindowDuration:        policy.WindowDuration,
+		MinimumRequests:       policy.MinimumRequests,
+		HalfOpenProbes:        policy.HalfOpenProbes,
+		OnStateChange: func(from, to circuitbreaker.State) {
+			log.Info("upstream circuit breaker state changed",
+				zap.String("upstream", upstream),
+				zap.Stringer("from", from),
+				zap.Stringer("to", to),
+			)
+
+			metrics.IncCircuitBreakerTransitionsTotal(upstream, to.String())
+		},
+	})
+
+	metrics.RegisterCircuitBreaker(upstream, func() metric.CircuitBreakerStats {
+		s := cb.Snapshot()
+
+		return metric.CircuitBreakerStats{
+			State:       int(s.State),
+			Failures:    s.Failures,
+			NextRetryAt: s.NextRetryAt,
+		}
+	})
+
+	return cb
+}
+
+// initGRPCUpstream resolves the gRPC method of the upstream in its descriptor set. Hedging, coalescing,
+// fallbacks and retry budgets are supported by HTTP upstreams only.
+func initGRPCUpstream(
+	cfg UpstreamConfig,
+	name string,
+	policy UpstreamPolicy,
+	descriptorSets map[string]*protoregistry.Files,
+) (*grpcUpstream, error) {
+	switch {
+	case policy.Hedging.Enabled:
+		return nil, errors.New("hedging is not supported by grpc upstreams")
+	case policy.Coalescing.Enabled:
+		return nil, errors.New("coalescing is not supported by grpc upstreams")
+	case cfg.Policy.FallbackConfig.Enabled:
+		return nil, errors.New("fallback is not supported by grpc upstreams")
+	case policy.RetryPolicy.BudgetRatio > 0:
+		return nil, errors.New("retry budget is not supported by grpc upstreams")
+	case cfg.GRPC.DescriptorSet == "":
+		return nil, errors.New("descriptor set is required")
+	}
+
+	files, ok := descriptorSets[cfg.GRPC.DescriptorSet]
+	if !ok {
+		var err error
+
+		files, err = loadDescriptorSet(cfg.GRPC.DescriptorSet)
+		if err != nil {
+			return nil, fmt.Errorf("cannot load descriptor set: %w", err)
+		}
+
+		descriptorSets[cfg.GRPC.DescriptorSet] = files
+	}
+
+	target, method, err := resolveGRPCMethod(cfg.URL, files)
+	if err != nil {
+		return nil, err
+	}
+
+	return &grpcUpstream{
+		name:                name,
+		url:                 target,
+		timeout:             cfg.Timeout,
+		headers:             cfg.Headers,
+		forwardHeaders:      cfg.ForwardHeaders,
+		forwardQueryStrings: cfg.ForwardQueryStrings,
+		policy:              policy,
+		method:              method,
+		types:               dynamicpb.NewTypes(files),
+	}, nil
+}
+
+// initFallback creates the fallback policy of the upstream. The alternate upstream inherits the upstream
+// settings except the URL, and is called without retries, hedging, coalescing and circuit breaking.
+func initFallback(cfg UpstreamFallbackConfig, upstream *httpUpstream) (UpstreamFallbackPolicy, error) {
+	policy := UpstreamFallbackPolicy{
+		Enabled:  true,
+		URL:      cfg.URL,
+		Stale:    cfg.Stale,
+		StaleTTL: cfg.StaleTTL,
+		Status:   cfg.Status,
+	}
+
+	if cfg.Body != nil {
+		body, err := json.Marshal(cfg.Body)
+		if err != nil {
+			return UpstreamFallbackPolicy{}, fmt.Errorf("cannot marshal fallback body: %w", err)
+		}
+
+		policy.Body = body
+	}
+
+	if cfg.URL == "" && !cfg.Stale && cfg.Body == nil {
+		return UpstreamFallbackPolicy{}, errors.New("fallback has no url, stale or body")
+	}
+
+	if cfg.URL != "" {
+		alternate := *upstream
+		alternate.url = cfg.URL
+		alternate.circuitBreaker = nil
+		alternate.hedger = nil
+		alternate.retryBudget = nil
+		alternate.coalescing = nil
+
+		policy.alternate = &alternate
+	}
+
+	if cfg.Stale {
+		policy.stale = &staleResponse{}
+	}
+
+	return policy, nil
+}
+
+func initConditions(cfgs []ConditionConfig) ([]UpstreamCondition, error) {
+	conditions := make([]UpstreamCondition, 0, len(cfgs))
+
+	for _, cfg := range cfgs {
+		switch cfg.Source {
+		case conditionSourceHeader, conditionSourceQuery, conditionSourceClaim, conditionSourcePath:
+		default:
+			return nil, fmt.Errorf("unknown condition source %q", cfg.Source)
+		}
+
+		if cfg.Name == "" {
+			return nil, fmt.Errorf("condition on %s has no name", cfg.Source)
+		}
+
+		condition := UpstreamCondition{
+			Source: cfg.Source,
+			Name:   cfg.Name,
+			Equals: cfg.Equals,
+			In:     cfg.In,
+			Exists: cfg.Exists,
+		}
+
+		if cfg.Matches != "" {
+			re, err := regexp.Compile(cfg.Matches)
+			if err != nil {
+				return nil, fmt.Errorf("invalid condition pattern %q: %w", cfg.Matches, err)
+			}
+
+			condition.Matches = re
+		}
+
+		conditions = append(conditions, condition)
+	}
+
+	return conditions, nil
+}
+
+// upstreamTemplateRefs returns the names of upstreams referenced by the templates of the upstream: its URLs,
+// GraphQL variables, static body and request transform.
+func upstreamTemplateRefs(cfg UpstreamConfig) []string {
+	values := []any{
+		cfg.URL,
+		cfg.Policy.FallbackConfig.URL,
+		cfg.GraphQL.Variables,
+		cfg.Static.Body,
+		cfg.Transform.Request.Set,
+		cfg.Transform.Request.Template,
+	}
+
+	for _, u := range cfg.Policy.HedgingConfig.URLs {
+		values = append(values, u)
+	}
+
+	// Unreadable body files are reported when the static upstream is created.
+	if cfg.Static.BodyFile != "" {
+		if body, err := os.ReadFile(cfg.Static.BodyFile); err == nil {
+			values = append(values, string(body))
+		}
+	}
+
+	var refs []string
+	for _, v := range values {
+		refs = append(refs, templateUpstreamRefs(v)...)
+	}
+
+	return refs
+}
+
+// validateUpstreamDependencies checks that upstream names are unique, all dependencies
+// refer to named upstreams of the same route and the dependency graph has no cycles.
+// Dependencies are not allowed for the first_success and race strategies.
+func validateUpstreamDependencies(cfgs []UpstreamConfig, strategy string) error {
+	deps := make(map[string][]string, len(cfgs))
+
+	for _, cfg := range cfgs {
+		if len(cfg.DependsOn) > 0 && (strategy == strategyFirstSuccess || strategy == strategyRace) {
+			return fmt.Errorf("upstream dependencies are not supported by the %s strategy", strategy)
+		}
+
+		// Responses of upstreams are available to templates only after they complete.
+		for _, ref := range upstreamTemplateRefs(cfg) {
+			if !slices.Contains(cfg.DependsOn, ref) {
+				return fmt.Errorf("upstream %s %s references upstream %q which is not in depends_on", cfg.Method, cfg.URL, ref)
+			}
+		}
+
+		if cfg.Name == "" {
+			if len(cfg.DependsOn) > 0 {
+				return fmt.Errorf("upstream %s %s has dependencies but no name", cfg.Method, cfg.URL)
+			}
+
+			continue
+		}
+
+		if _, ok := deps[cfg.Name]; ok {
+			return fmt.Errorf("duplicate upstream name %q", cfg.Name)
+		}
+
+		deps[cfg.Name] = cfg.DependsOn
+	}
+
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+
+	state := make(map[string]int, len(deps))
+
+	var visit func(name string) error
+	visit = func(name string) error {
+		switch state[name] {
+		case visiting:
+			return fmt.Errorf("dependency cycle detected at upstream %q", name)
+		case visited:
+			return nil
+		}
+
+		state[name] = visiting
+
+		for _, dep := range deps[name] {
+			if _, ok := deps[dep]; !ok {
+				return fmt.Errorf("upstream %q depends on unknown upstream %q", name, dep)
+			}
+
+			if err := visit(dep); err != nil {
+				return err
+			}
+		}
+
+		state[name] = visited
+
+		return nil
+	}
+
+	for name := range deps {
+		if state[name] == unvisited {
+			if err := visit(name); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
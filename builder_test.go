@@ -0,0 +1,204 @@
+package tokka
+
+import (
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/starwalkn/tokka/internal/metric"
+)
+
+func TestValidateUpstreamDependencies(t *testing.T) {
+	tests := []struct {
+		name     string
+		cfgs     []UpstreamConfig
+		strategy string
+		wantErr  bool
+	}{
+		{
+			name: "valid dag",
+			cfgs: []UpstreamConfig{
+				{Name: "order"},
+				{Name: "customer", DependsOn: []string{"order"}},
+				{Name: "invoice", DependsOn: []string{"order", "customer"}},
+				{URL: "http://unnamed.local"},
+			},
+		},
+		{
+			name:    "unknown dependency",
+			cfgs:    []UpstreamConfig{{Name: "a", DependsOn: []string{"b"}}},
+			wantErr: true,
+		},
+		{
+			name:    "duplicate names",
+			cfgs:    []UpstreamConfig{{Name: "a"}, {Name: "a"}},
+			wantErr: true,
+		},
+		{
+			name:    "unnamed upstream with dependencies",
+			cfgs:    []UpstreamConfig{{Name: "a"}, {DependsOn: []string{"a"}}},
+			wantErr: true,
+		},
+		{
+			name: "url template without dependency",
+			cfgs: []UpstreamConfig{
+				{Name: "order"},
+				{Name: "customer", URL: "http://customer.local/${upstreams.order.customer_id}"},
+			},
+			wantErr: true,
+		},
+		{
+			name: "url template with dependency",
+			cfgs: []UpstreamConfig{
+				{Name: "order"},
+				{Name: "customer", URL: "http://customer.local/${ upstreams.order.customer_id }", DependsOn: []string{"order"}},
+			},
+		},
+		{
+			name: "request transform template without dependency",
+			cfgs: []UpstreamConfig{
+				{Name: "order"},
+				{
+					Name: "customer",
+					Transform: UpstreamTransformConfig{
+						Request: RequestTransformConfig{Set: map[string]any{"id": "${upstreams.order.id}"}},
+					},
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "graphql variables template without dependency",
+			cfgs: []UpstreamConfig{
+				{Name: "order"},
+				{
+					Name: "customer",
+					GraphQL: UpstreamGraphQLConfig{
+						Variables: map[string]any{"ids": []any{"${upstreams.order.id}"}},
+					},
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "cycle",
+			cfgs: []UpstreamConfig{
+				{Name: "a", DependsOn: []string{"c"}},
+				{Name: "b", DependsOn: []string{"a"}},
+				{Name: "c", DependsOn: []string{"b"}},
+			},
+			wantErr: true,
+		},
+		{
+			name:     "dependencies with race strategy",
+			cfgs:     []UpstreamConfig{{Name: "a"}, {Name: "b", DependsOn: []string{"a"}}},
+			strategy: strategyRace,
+			wantErr:  true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateUpstreamDependencies(tt.cfgs, tt.strategy)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("unexpected error: %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestValidateRouteMode(t *testing.T) {
+	tests := []struct {
+		name     string
+		mode     string
+		upstream UpstreamConfig
+		wantErr  bool
+	}{
+		{name: "stream http upstream", mode: routeModeStream, upstream: UpstreamConfig{URL: "http://files.local"}},
+		{name: "websocket http upstream", mode: routeModeWebSocket, upstream: UpstreamConfig{URL: "ws://chat.local"}},
+		{name: "stream grpc upstream", mode: routeModeStream, upstream: UpstreamConfig{URL: "grpc://users.local/users.Users/Get"}, wantErr: true},
+		{
+			name:     "websocket graphql upstream",
+			mode:     routeModeWebSocket,
+			upstream: UpstreamConfig{URL: "http://graph.local", GraphQL: UpstreamGraphQLConfig{Query: "{ me { id } }"}},
+			wantErr:  true,
+		},
+		{name: "stream h2c upstream", mode: routeModeStream, upstream: UpstreamConfig{URL: "http://files.local", Protocol: protocolH2C}},
+		{name: "websocket h2c upstream", mode: routeModeWebSocket, upstream: UpstreamConfig{URL: "ws://chat.local", Protocol: protocolH2C}, wantErr: true},
+		{name: "stream static upstream", mode: routeModeStream, upstream: UpstreamConfig{Static: UpstreamStaticConfig{Enabled: true}}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateRouteMode(RouteConfig{Mode: tt.mode, Upstreams: []UpstreamConfig{tt.upstream}})
+			if (err != nil) != tt.wantErr {
+				t.Errorf("unexpected error: %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestValidatePropagation(t *testing.T) {
+	tests := []struct {
+		name    string
+		cfg     RouteConfig
+		wantErr bool
+	}{
+		{
+			name: "single upstream",
+			cfg:  RouteConfig{Upstreams: []UpstreamConfig{{}}, Propagate: PropagationConfig{Status: true}},
+		},
+		{
+			name:    "multiple upstreams without status upstream",
+			cfg:     RouteConfig{Upstreams: []UpstreamConfig{{Name: "a"}, {Name: "b"}}, Propagate: PropagationConfig{Status: true}},
+			wantErr: true,
+		},
+		{
+			name:    "unknown status upstream",
+			cfg:     RouteConfig{Upstreams: []UpstreamConfig{{Name: "a"}}, Propagate: PropagationConfig{Status: true, StatusUpstream: "b"}},
+			wantErr: true,
+		},
+		{
+			name: "raw response",
+			cfg: RouteConfig{
+				ResponseMode: responseModeRaw,
+				Upstreams:    []UpstreamConfig{{Name: "a"}},
+				Propagate:    PropagationConfig{Status: true, StatusUpstream: "a"},
+			},
+			wantErr: true,
+		},
+		{
+			name: "raw response headers",
+			cfg: RouteConfig{
+				ResponseMode: responseModeRaw,
+				Upstreams:    []UpstreamConfig{{Name: "a"}},
+				Propagate:    PropagationConfig{Headers: []string{"Cache-Control"}},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validatePropagation(tt.cfg)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("unexpected error: %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestInitUpstreams_Alias(t *testing.T) {
+	upstreams := initUpstreams([]UpstreamConfig{
+		{Name: "order", Timeout: time.Second, Static: UpstreamStaticConfig{Enabled: true}},
+		{Name: "billing", Alias: "payments", Timeout: time.Second, Static: UpstreamStaticConfig{Enabled: true}},
+	}, metric.NewNop(), zap.NewNop())
+
+	if alias := upstreams[0].Policy().Alias; alias != "" {
+		t.Errorf("expected no alias without configuration, got %q", alias)
+	}
+
+	if alias := upstreams[1].Policy().Alias; alias != "payments" {
+		t.Errorf("expected alias %q, got %q", "payments", alias)
+	}
+}
@@ -93,13 +93,13 @@ func (m *Middleware) Handler(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		authHeader := r.Header.Get("Authorization")
 		if authHeader == "" {
-			http.Error(w, "missing authorization header", http.StatusUnauthorized)
+			unauthorized(w, r, "missing authorization header")
 			return
 		}
 
 		parts := strings.SplitN(authHeader, " ", 2) //nolint:mnd // it is not magic, it is a fuckin auth header parts
 		if len(parts) != 2 || !strings.EqualFold(parts[0], "Bearer") {
-			http.Error(w, "invalid authorization header", http.StatusUnauthorized)
+			unauthorized(w, r, "invalid authorization header")
 			return
 		}
 
@@ -113,50 +113,51 @@ func (m *Middleware) Handler(next http.Handler) http.Handler {
 			jwt.WithLeeway(defaultLeeway),
 		)
 		if err != nil || !token.Valid {
-			http.Error(w, "invalid token", http.StatusUnauthorized)
+			unauthorized(w, r, "invalid token")
 			return
 		}
 
 		claims, ok := token.Claims.(*jwt.MapClaims)
 		if !ok {
-			http.Error(w, "invalid token claims", http.StatusUnauthorized)
+			unauthorized(w, r, "invalid token claims")
 			return
 		}
 
 		expirationTime, err := claims.GetExpirationTime()
 		if err != nil {
-			http.Error(w, "invalid token expiration time", http.StatusUnauthorized)
+			unauthorized(w, r, "invalid token expiration time")
 			return
 		}
 
 		if expirationTime.Before(time.Now()) {
-			http.Error(w, "token expired", http.StatusUnauthorized)
+			unauthorized(w, r, "token expired")
 			return
 		}
 
 		issuer, err := claims.GetIssuer()
 		if err != nil {
-			http.Error(w, "invalid token issuer", http.StatusUnauthorized)
+			unauthorized(w, r, "invalid token issuer")
 			return
 		}
 
 		if issuer != m.Issuer {
-			http.Error(w, "invalid token issuer", http.StatusUnauthorized)
+			unauthorized(w, r, "invalid token issuer")
 			return
 		}
 
 		audience, err := claims.GetAudience()
 		if err != nil {
-			http.Error(w, "invalid token audience", http.StatusUnauthorized)
+			unauthorized(w, r, "invalid token audience")
 			return
 		}
 
 		if !slices.Contains(audience, m.Audience) {
-			http.Error(w, "invalid token audience", http.StatusUnauthorized)
+			unauthorized(w, r, "invalid token audience")
 			return
 		}
 
 		ctx := context.WithValue(r.Context(), ctxKeyClaims{}, claims)
+		ctx = tokka.WithClaims(ctx, *claims)
 
 		next.ServeHTTP(w, r.WithContext(ctx))
 	})
@@ -194,3 +195,13 @@ func (m *Middleware) newKeyResolver(cfg JWTConfig) (keyResolver, error) {
 		return nil, fmt.Errorf("unsupported signing method: %s", cfg.Alg)
 	}
 }
+
+// unauthorized writes the 401 error as plain text, or as Problem Details if they are enabled.
+func unauthorized(w http.ResponseWriter, r *http.Request, message string) {
+	if !tokka.ProblemDetailsEnabled() {
+		http.Error(w, message, http.StatusUnauthorized)
+		return
+	}
+
+	tokka.WriteRequestError(w, r, tokka.ErrorCodeUnauthorized, message, http.StatusUnauthorized)
+}
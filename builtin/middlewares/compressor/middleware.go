@@ -53,7 +53,10 @@ func (m *Middleware) Handler(next http.Handler) http.Handler {
 	}
 
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		if !strings.Contains(r.Header.Get("Accept-Encoding"), m.alg) {
+		// Upgraded connections (e.g. WebSocket) cannot be compressed, and compressing event streams would
+		// buffer events.
+		if !strings.Contains(r.Header.Get("Accept-Encoding"), m.alg) || r.Header.Get("Upgrade") != "" ||
+			strings.Contains(r.Header.Get("Accept"), "text/event-stream") {
 			next.ServeHTTP(w, r)
 			return
 		}
@@ -99,3 +102,8 @@ type compressorResponseWriter struct {
 func (w *compressorResponseWriter) Write(b []byte) (int, error) {
 	return w.Writer.Write(b)
 }
+
+// Unwrap returns the original writer, so http.ResponseController can reach it.
+func (w *compressorResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
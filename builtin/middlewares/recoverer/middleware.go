@@ -63,6 +63,11 @@ func (m *Middleware) Handler(next http.Handler) http.Handler {
 					log.Error(msg)
 				}
 
+				if tokka.ProblemDetailsEnabled() {
+					tokka.WriteRequestError(w, r, tokka.ErrorCodeInternal, "internal server error", http.StatusInternalServerError)
+					return
+				}
+
 				w.Header().Set("Content-Type", "application/json")
 				w.WriteHeader(http.StatusInternalServerError)
 				_, _ = w.Write([]byte(`{"error": "internal server error"}`))
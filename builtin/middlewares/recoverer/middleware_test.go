@@ -9,6 +9,8 @@ import (
 
 	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
+
+	"github.com/starwalkn/tokka"
 )
 
 func newTestLogger(buf *bytes.Buffer) *zap.Logger {
@@ -50,6 +52,34 @@ func TestRecovererMiddleware_PanicRecovered(t *testing.T) {
 	}
 }
 
+func TestRecovererMiddleware_ProblemDetails(t *testing.T) {
+	if err := tokka.SetErrorFormat(tokka.ErrorFormatProblem); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	t.Cleanup(func() { _ = tokka.SetErrorFormat("") })
+
+	m := &Middleware{
+		enabled: true,
+		log:     zap.NewNop(),
+	}
+	handler := m.Handler(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {
+		panic("boom")
+	}))
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	rec := httptest.NewRecorder()
+
+	handler.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusInternalServerError {
+		t.Fatalf("expected 500, got %d", rec.Code)
+	}
+
+	if ct := rec.Header().Get("Content-Type"); ct != "application/problem+json" {
+		t.Errorf("unexpected Content-Type: %s", ct)
+	}
+}
+
 func TestRecovererMiddleware_NoPanic(t *testing.T) {
 	buf := new(bytes.Buffer)
 	m := &Middleware{
@@ -0,0 +1,408 @@
+package tokka
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/starwalkn/tokka/internal/cache"
+)
+
+// UpstreamCachePolicy describes caching of upstream responses. Freshness follows the Cache-Control and Expires
+// response headers; TTL, StaleWhileRevalidate and StaleIfError are used when the upstream does not specify them.
+type UpstreamCachePolicy struct {
+	Enabled              bool
+	TTL                  time.Duration // Freshness of responses without max-age or Expires.
+	StaleWhileRevalidate time.Duration // How long a stale response is served while it is refreshed in background.
+	StaleIfError         time.Duration // How long a stale response is served if the upstream fails.
+	MaxEntries           int
+	MaxSize              int64    // Maximum total size of cached responses in bytes.
+	KeyHeaders           []string // Request headers which are part of the cache key besides the sent ones.
+	KeyQuery             []string // Query parameters which are part of the cache key besides the sent ones. "*" means all.
+	KeyClaims            []string // JWT claims which are part of the cache key.
+	KeyIgnoreHeaders     []string // Sent headers which are not a part of the cache key.
+
+	store *cache.LRU[*cacheEntry]
+}
+
+type cacheEntry struct {
+	resp                      *UpstreamResponse
+	path                      string // URL path of the upstream request, used to purge entries.
+	freshUntil                time.Time
+	staleWhileRevalidateUntil time.Time
+	staleIfErrorUntil         time.Time
+	revalidating              atomic.Bool
+}
+
+// response returns a copy of the cached response.
+func (e *cacheEntry) response() *UpstreamResponse {
+	return &UpstreamResponse{
+		Status:  e.resp.Status,
+		Headers: e.resp.Headers.Clone(),
+		Body:    e.resp.Body,
+	}
+}
+
+// cacheableUpstream is implemented by upstreams whose responses can be cached.
+type cacheableUpstream interface {
+	// requestKey returns the identity of the request sent to the upstream (see requestIdentity) and the sent
+	// headers it includes. It reports false if the request cannot be cached.
+	requestKey(ctx context.Context, original *http.Request, originalBody []byte, ignoreHeaders []string) (string, http.Header, bool)
+}
+
+// requestIgnoredHeaders are sent headers which are never a part of cache keys, because they differ for every request.
+var requestIgnoredHeaders = []string{"X-Request-Id"}
+
+// cacheableStatuses are statuses which are cacheable by default according to RFC 9110.
+var cacheableStatuses = []int{
+	http.StatusOK,
+	http.StatusNonAuthoritativeInfo,
+	http.StatusNoContent,
+	http.StatusMultipleChoices,
+	http.StatusMovedPermanently,
+	http.StatusNotFound,
+	http.StatusMethodNotAllowed,
+	http.StatusGone,
+	http.StatusRequestURITooLong,
+	http.StatusNotImplemented,
+}
+
+// cachedCall serves the upstream response from the cache if possible, and calls the upstream otherwise.
+func (d *defaultDispatcher) cachedCall(ctx context.Context, u Upstream, original *http.Request, originalBody []byte) *UpstreamResponse {
+	policy := u.Policy()
+
+	c := policy.Cache
+
+	cu, ok := u.(cacheableUpstream)
+	if !c.Enabled || c.store == nil || !ok {
+		return u.Call(ctx, original, originalBody, policy.RetryPolicy)
+	}
+
+	request, sent, ok := cu.requestKey(ctx, original, originalBody, append(slices.Clone(requestIgnoredHeaders), c.KeyIgnoreHeaders...))
+	if !ok {
+		return u.Call(ctx, original, originalBody, policy.RetryPolicy)
+	}
+
+	key := c.key(ctx, u.Name(), request, original)
+	path := requestPath(request)
+	now := time.Now()
+
+	entry, cached := c.store.Get(key)
+
+	if cached && now.Before(entry.freshUntil) {
+		d.metrics.IncCacheHitsTotal(u.Name())
+		return entry.response()
+	}
+
+	if cached && now.Before(entry.staleWhileRevalidateUntil) {
+		d.metrics.IncCacheHitsTotal(u.Name())
+
+		if entry.revalidating.CompareAndSwap(false, true) {
+			// The original request is not used after the handler returns, so the revalidation gets a copy.
+			revalidated := original.Clone(context.WithoutCancel(ctx))
+			revalidated.Body = http.NoBody
+
+			go func() {
+				defer entry.revalidating.Store(false)
+
+				resp := u.Call(revalidated.Context(), revalidated, originalBody, policy.RetryPolicy)
+				if resp.Err == nil {
+					c.storeResponse(key, path, revalidated, sent, resp)
+				}
+			}()
+		}
+
+		return entry.response()
+	}
+
+	d.metrics.IncCacheMissesTotal(u.Name())
+
+	resp := u.Call(ctx, original, originalBody, policy.RetryPolicy)
+	if resp.Err != nil {
+		if cached && resp.Err.Kind != UpstreamCanceled && time.Now().Before(entry.staleIfErrorUntil) {
+			return entry.response()
+		}
+
+		return resp
+	}
+
+	c.storeResponse(key, path, original, sent, resp)
+
+	return resp
+}
+
+// isCacheableMethod reports whether responses to requests with the given method can be cached.
+func isCacheableMethod(method string) bool {
+	return method == http.MethodGet || method == http.MethodHead
+}
+
+// requestKey builds the request sent to the upstream. Only GET and HEAD requests are cacheable, so GraphQL
+// upstreams and upstreams configured with other methods are never cached.
+func (u *httpUpstream) requestKey(
+	ctx context.Context,
+	original *http.Request,
+	originalBody []byte,
+	ignoreHeaders []string,
+) (string, http.Header, bool) {
+	if !isCacheableMethod(u.resolveMethod(original)) {
+		return "", nil, false
+	}
+
+	req, err := u.newRequest(ctx, u.url, original, originalBody)
+	if err != nil {
+		return "", nil, false
+	}
+
+	header := identityHeaders(req.Header, ignoreHeaders)
+
+	return requestIdentity(req, nil, header), header, true
+}
+
+// requestPath returns the URL path of the request identity, see requestIdentity.
+func requestPath(request string) string {
+	line, _, _ := strings.Cut(request, "\n")
+	_, rawURL, _ := strings.Cut(line, " ")
+
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return ""
+	}
+
+	return u.Path
+}
+
+// key returns the cache key of the request: the upstream name and the identity of the request sent to the
+// upstream, followed by the selected query parameters, headers and claims of the original request.
+func (p UpstreamCachePolicy) key(ctx context.Context, upstream, request string, original *http.Request) string {
+	var b strings.Builder
+
+	b.WriteString(upstream)
+	b.WriteByte(' ')
+	b.WriteString(request)
+
+	if len(p.KeyQuery) > 0 {
+		query := original.URL.Query()
+
+		if !slices.Contains(p.KeyQuery, "*") {
+			selected := make(url.Values, len(p.KeyQuery))
+
+			for _, name := range p.KeyQuery {
+				if values, ok := query[name]; ok {
+					selected[name] = values
+				}
+			}
+
+			query = selected
+		}
+
+		if len(query) > 0 {
+			b.WriteString("\nquery: ")
+			b.WriteString(query.Encode()) // Encode sorts by key.
+		}
+	}
+
+	for _, name := range p.KeyHeaders {
+		b.WriteString("\nheader " + http.CanonicalHeaderKey(name) + ": " + strings.Join(original.Header.Values(name), ","))
+	}
+
+	claims := ClaimsFromContext(ctx)
+
+	for _, name := range p.KeyClaims {
+		b.WriteString("\nclaim " + name + ": " + stringifyValue(claims[name]))
+	}
+
+	return b.String()
+}
+
+// storeResponse caches the upstream response if HTTP caching rules allow it. Path is the URL path of the
+// upstream request and sent are its headers which are a part of the key.
+func (p UpstreamCachePolicy) storeResponse(key, path string, original *http.Request, sent http.Header, resp *UpstreamResponse) {
+	if !slices.Contains(cacheableStatuses, resp.Status) {
+		return
+	}
+
+	keyed := func(name string) bool {
+		_, ok := sent[http.CanonicalHeaderKey(name)]
+		return ok || containsHeader(p.KeyHeaders, name)
+	}
+
+	// The key includes every header sent to the upstream except the ignored ones, so the response may vary
+	// only by those. Headers which are not sent are the same for all requests.
+	for _, value := range resp.Headers.Values("Vary") {
+		for name := range strings.SplitSeq(value, ",") {
+			name = strings.TrimSpace(name)
+
+			ignored := containsHeader(requestIgnoredHeaders, name) || containsHeader(p.KeyIgnoreHeaders, name)
+			if name == "*" || (ignored && !keyed(name)) {
+				return
+			}
+		}
+	}
+
+	directives := parseCacheControl(resp.Headers.Get("Cache-Control"))
+
+	if _, ok := directives["no-store"]; ok {
+		return
+	}
+
+	if _, ok := directives["no-cache"]; ok {
+		return
+	}
+
+	if _, ok := directives["private"]; ok {
+		return
+	}
+
+	// Responses to authorized requests are shared between users unless Authorization is a part of the key.
+	if original.Header.Get("Authorization") != "" && !keyed("Authorization") {
+		_, public := directives["public"]
+		_, sMaxAge := directives["s-maxage"]
+
+		if !public && !sMaxAge {
+			return
+		}
+	}
+
+	now := time.Now()
+
+	freshness := p.freshness(directives, resp.Headers, now)
+	staleWhileRevalidate := directiveSeconds(directives, "stale-while-revalidate", p.StaleWhileRevalidate)
+	staleIfError := directiveSeconds(directives, "stale-if-error", p.StaleIfError)
+
+	if freshness <= 0 && staleWhileRevalidate <= 0 && staleIfError <= 0 {
+		return
+	}
+
+	freshUntil := now.Add(max(freshness, 0))
+
+	entry := &cacheEntry{
+		resp: &UpstreamResponse{
+			Status:  resp.Status,
+			Headers: resp.Headers.Clone(),
+			Body:    resp.Body,
+		},
+		path:                      path,
+		freshUntil:                freshUntil,
+		staleWhileRevalidateUntil: freshUntil.Add(staleWhileRevalidate),
+		staleIfErrorUntil:         freshUntil.Add(staleIfError),
+	}
+
+	p.store.Set(key, entry, responseSize(resp))
+}
+
+// freshness returns the freshness lifetime of the response.
+func (p UpstreamCachePolicy) freshness(directives map[string]string, headers http.Header, now time.Time) time.Duration {
+	if seconds, ok := directives["s-maxage"]; ok {
+		return parseSeconds(seconds)
+	}
+
+	if seconds, ok := directives["max-age"]; ok {
+		return parseSeconds(seconds)
+	}
+
+	if expires := headers.Get("Expires"); expires != "" {
+		expiresAt, err := http.ParseTime(expires)
+		if err != nil {
+			return 0 // Invalid Expires means already expired.
+		}
+
+		date, err := http.ParseTime(headers.Get("Date"))
+		if err != nil {
+			date = now
+		}
+
+		return expiresAt.Sub(date)
+	}
+
+	return p.TTL
+}
+
+// purge removes cached responses to upstream requests with the path or its sub-paths, or all of them if the
+// path is empty, and returns the number of removed entries.
+func (p UpstreamCachePolicy) purge(path string) int {
+	if p.store == nil {
+		return 0
+	}
+
+	path = strings.TrimSuffix(path, "/")
+
+	return p.store.DeleteFunc(func(_ string, entry *cacheEntry) bool {
+		return path == "" || entry.path == path || strings.HasPrefix(entry.path, path+"/")
+	})
+}
+
+// PurgeCache removes cached responses of the named upstream in all routes. If path is not empty, only
+// responses to upstream requests with the path or its sub-paths are removed. It returns the number of
+// removed entries.
+func (r *Router) PurgeCache(upstream, path string) int {
+	removed := 0
+
+	for _, route := range r.Routes {
+		for _, u := range route.Upstreams {
+			if u.Name() == upstream {
+				removed += u.Policy().Cache.purge(path)
+			}
+		}
+	}
+
+	return removed
+}
+
+func parseCacheControl(value string) map[string]string {
+	directives := make(map[string]string)
+
+	for part := range strings.SplitSeq(value, ",") {
+		name, arg, _ := strings.Cut(strings.TrimSpace(part), "=")
+		if name == "" {
+			continue
+		}
+
+		directives[strings.ToLower(name)] = strings.Trim(arg, `"`)
+	}
+
+	return directives
+}
+
+func directiveSeconds(directives map[string]string, name string, fallback time.Duration) time.Duration {
+	if seconds, ok := directives[name]; ok {
+		return parseSeconds(seconds)
+	}
+
+	return fallback
+}
+
+func parseSeconds(value string) time.Duration {
+	seconds, err := strconv.Atoi(value)
+	if err != nil || seconds < 0 {
+		return 0
+	}
+
+	return time.Duration(seconds) * time.Second
+}
+
+// containsHeader reports whether the header names contain the name, ignoring case.
+func containsHeader(names []string, name string) bool {
+	return slices.ContainsFunc(names, func(n string) bool {
+		return strings.EqualFold(n, name)
+	})
+}
+
+// responseSize approximates the memory used by the cached response.
+func responseSize(resp *UpstreamResponse) int64 {
+	size := len(resp.Body)
+
+	for name, values := range resp.Headers {
+		size += len(name)
+
+		for _, v := range values {
+			size += len(v)
+		}
+	}
+
+	return int64(size)
+}
@@ -0,0 +1,254 @@
+package tokka
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/starwalkn/tokka/internal/cache"
+	"github.com/starwalkn/tokka/internal/metric"
+)
+
+func newCachedUpstream(url string, policy UpstreamCachePolicy) *httpUpstream {
+	policy.Enabled = true
+	policy.store = cache.New[*cacheEntry](100, 0)
+
+	return &httpUpstream{
+		name:    "users",
+		url:     url,
+		method:  http.MethodGet,
+		timeout: 500 * time.Millisecond,
+		client:  http.DefaultClient,
+		policy:  UpstreamPolicy{Cache: policy},
+	}
+}
+
+func TestDispatcher_CachedCall(t *testing.T) {
+	tests := []struct {
+		name          string
+		cacheControl  string
+		policy        UpstreamCachePolicy
+		authorization string
+		expectedCalls int32
+	}{
+		{name: "max-age", cacheControl: "max-age=60", expectedCalls: 1},
+		{name: "default ttl", policy: UpstreamCachePolicy{TTL: time.Minute}, expectedCalls: 1},
+		{name: "no ttl", expectedCalls: 3},
+		{name: "no-store", cacheControl: "no-store", policy: UpstreamCachePolicy{TTL: time.Minute}, expectedCalls: 3},
+		{name: "expired", cacheControl: "max-age=0", expectedCalls: 3},
+		{name: "authorized", cacheControl: "max-age=60", authorization: "Bearer x", expectedCalls: 3},
+		{name: "authorized public", cacheControl: "public, max-age=60", authorization: "Bearer x", expectedCalls: 1},
+		{
+			name:          "authorized keyed",
+			cacheControl:  "max-age=60",
+			policy:        UpstreamCachePolicy{KeyHeaders: []string{"authorization"}},
+			authorization: "Bearer x",
+			expectedCalls: 1,
+		},
+	}
+
+	for _, tt := range tests {
+		var calls atomic.Int32
+
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+			calls.Add(1)
+
+			if tt.cacheControl != "" {
+				w.Header().Set("Cache-Control", tt.cacheControl)
+			}
+
+			_, _ = w.Write([]byte(`{"ok":true}`))
+		}))
+
+		d := &defaultDispatcher{log: zap.NewNop(), metrics: metric.NewNop()}
+		u := newCachedUpstream(server.URL, tt.policy)
+
+		for range 3 {
+			req := httptest.NewRequest(http.MethodGet, "http://example.com/users", nil)
+			if tt.authorization != "" {
+				req.Header.Set("Authorization", tt.authorization)
+			}
+
+			resp := d.cachedCall(req.Context(), u, req, nil)
+			if resp.Err != nil || string(resp.Body) != `{"ok":true}` {
+				t.Errorf("%s: unexpected response: %+v", tt.name, resp)
+			}
+		}
+
+		if calls.Load() != tt.expectedCalls {
+			t.Errorf("%s: expected %d upstream calls, got %d", tt.name, tt.expectedCalls, calls.Load())
+		}
+
+		server.Close()
+	}
+}
+
+func TestDispatcher_CachedCall_StaleIfError(t *testing.T) {
+	var failing atomic.Bool
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		if failing.Load() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+
+		w.Header().Set("Cache-Control", "max-age=0, stale-if-error=60")
+		_, _ = w.Write([]byte(`{"ok":true}`))
+	}))
+	defer server.Close()
+
+	d := &defaultDispatcher{log: zap.NewNop(), metrics: metric.NewNop()}
+	u := newCachedUpstream(server.URL, UpstreamCachePolicy{})
+	req := httptest.NewRequest(http.MethodGet, "http://example.com/users", nil)
+
+	if resp := d.cachedCall(req.Context(), u, req, nil); resp.Err != nil {
+		t.Fatalf("unexpected error: %v", resp.Err)
+	}
+
+	failing.Store(true)
+
+	resp := d.cachedCall(req.Context(), u, req, nil)
+	if resp.Err != nil || string(resp.Body) != `{"ok":true}` {
+		t.Errorf("expected stale response, got %+v", resp)
+	}
+}
+
+func TestUpstreamCachePolicy_Key(t *testing.T) {
+	policy := UpstreamCachePolicy{
+		KeyHeaders: []string{"x-tenant"},
+		KeyQuery:   []string{"b", "a"},
+		KeyClaims:  []string{"sub"},
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "http://example.com/users?c=3&b=2&a=1", nil)
+	req.Header.Set("X-Tenant", "acme")
+
+	ctx := WithClaims(req.Context(), map[string]any{"sub": "42"})
+
+	expected := "users GET http://users.local/v1/users\nquery: a=1&b=2\nheader X-Tenant: acme\nclaim sub: 42"
+	if key := policy.key(ctx, "users", "GET http://users.local/v1/users", req); key != expected {
+		t.Errorf("expected key %q, got %q", expected, key)
+	}
+}
+
+func TestDispatcher_CachedCall_RequestIdentity(t *testing.T) {
+	var calls atomic.Int32
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+
+		w.Header().Set("Cache-Control", "max-age=60")
+
+		if r.URL.Query().Has("vary") {
+			w.Header().Set("Vary", "X-Request-Id")
+		}
+
+		_, _ = w.Write([]byte(`{"id":"` + r.URL.Query().Get("id") + `","tenant":"` + r.Header.Get("X-Tenant") + `"}`))
+	}))
+	defer server.Close()
+
+	d := &defaultDispatcher{log: zap.NewNop(), metrics: metric.NewNop()}
+
+	u := newCachedUpstream(server.URL, UpstreamCachePolicy{})
+	u.forwardQueryStrings = []string{"*"}
+	u.forwardHeaders = []string{"X-Tenant", "X-Request-Id"}
+
+	call := func(u Upstream, method, target, tenant string) *UpstreamResponse {
+		req := httptest.NewRequest(method, target, nil)
+		req.Header.Set("X-Tenant", tenant)
+		req.Header.Set("X-Request-Id", strconv.Itoa(int(calls.Load())))
+
+		return d.cachedCall(req.Context(), u, req, nil)
+	}
+
+	// Forwarded query strings and headers separate entries, the request ID does not.
+	for range 2 {
+		for _, target := range []string{"http://example.com/users?id=1", "http://example.com/users?id=2"} {
+			for _, tenant := range []string{"a", "b"} {
+				resp := call(u, http.MethodGet, target, tenant)
+
+				expected := `{"id":"` + target[len(target)-1:] + `","tenant":"` + tenant + `"}`
+				if resp.Err != nil || string(resp.Body) != expected {
+					t.Errorf("expected %s, got %s (%v)", expected, resp.Body, resp.Err)
+				}
+			}
+		}
+	}
+
+	if got := calls.Load(); got != 4 {
+		t.Errorf("expected 4 upstream calls, got %d", got)
+	}
+
+	// Responses varying by headers which are not a part of the key are not cached.
+	calls.Store(0)
+
+	for range 2 {
+		call(u, http.MethodGet, "http://example.com/users?id=1&vary", "a")
+	}
+
+	if got := calls.Load(); got != 2 {
+		t.Errorf("expected 2 upstream calls for varying responses, got %d", got)
+	}
+
+	// The upstream method decides whether responses are cached.
+	calls.Store(0)
+
+	post := newCachedUpstream(server.URL, UpstreamCachePolicy{})
+	post.method = http.MethodPost
+
+	for range 2 {
+		call(post, http.MethodGet, "http://example.com/users?id=1", "a")
+	}
+
+	if got := calls.Load(); got != 2 {
+		t.Errorf("expected 2 upstream calls for a POST upstream, got %d", got)
+	}
+}
+
+func TestRouter_PurgeCache(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write([]byte(`{"ok":true}`))
+	}))
+	defer server.Close()
+
+	orders := newCachedUpstream(server.URL+"/v1/orders/1", UpstreamCachePolicy{TTL: time.Minute})
+	orders.name = "orders"
+
+	upstreams := []Upstream{
+		newCachedUpstream(server.URL+"/v1/users/1", UpstreamCachePolicy{TTL: time.Minute}),
+		newCachedUpstream(server.URL+"/v1/users/2", UpstreamCachePolicy{TTL: time.Minute}),
+		orders,
+	}
+
+	d := &defaultDispatcher{log: zap.NewNop(), metrics: metric.NewNop()}
+
+	for _, u := range upstreams {
+		req := httptest.NewRequest(http.MethodGet, "http://example.com/", nil)
+		d.cachedCall(req.Context(), u, req, nil)
+	}
+
+	r := &Router{Routes: []Route{{Upstreams: upstreams}}}
+
+	steps := []struct {
+		upstream string
+		path     string
+		want     int
+	}{
+		{upstream: "users", path: "/v1/users/1", want: 1},
+		{upstream: "orders", path: "/v1/users", want: 0},
+		{upstream: "users", path: "/v1/user", want: 0},
+		{upstream: "users", path: "/v1/", want: 1},
+		{upstream: "orders", want: 1},
+	}
+
+	for _, step := range steps {
+		if got := r.PurgeCache(step.upstream, step.path); got != step.want {
+			t.Errorf("purge %s %q: expected %d removed entries, got %d", step.upstream, step.path, step.want, got)
+		}
+	}
+}
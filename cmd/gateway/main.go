@@ -23,20 +23,21 @@ func main() {
 	cfg := tokka.LoadConfig(cfgPath)
 	log := logger.New(cfg.Debug)
 
-	if cfg.Dashboard.Enable {
-		dashboardServer := dashboard.NewServer(&cfg, log.Named("dashboard"))
-		go dashboardServer.Start()
-	}
-
 	routerConfigSet := tokka.RouterConfigSet{
 		Version:     cfg.Version,
 		Routes:      cfg.Routes,
 		Middlewares: cfg.Middlewares,
 		Features:    cfg.Features,
 		Metrics:     cfg.Server.Metrics,
+		ErrorFormat: cfg.Server.ErrorFormat,
 	}
 	mainRouter := tokka.NewRouter(routerConfigSet, log.Named("router"))
 
+	if cfg.Dashboard.Enable {
+		dashboardServer := dashboard.NewServer(&cfg, mainRouter, log.Named("dashboard"))
+		go dashboardServer.Start()
+	}
+
 	mux := http.NewServeMux()
 
 	if cfg.Server.Metrics.Enabled {
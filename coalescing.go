@@ -0,0 +1,110 @@
+package tokka
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"maps"
+	"net/http"
+	"slices"
+	"strings"
+)
+
+// UpstreamCoalescingPolicy describes coalescing of identical concurrent upstream requests.
+type UpstreamCoalescingPolicy struct {
+	Enabled       bool
+	IgnoreHeaders []string // Forwarded headers which are not a part of the coalescing key.
+}
+
+// isCoalescible reports whether requests with the given method can share one upstream call.
+func isCoalescible(method string) bool {
+	return method == http.MethodGet || method == http.MethodHead
+}
+
+// coalescedCall shares one upstream call between identical concurrent requests. Requests are identical
+// if they have the same method, URL and forwarded headers, so credentials like Authorization are always
+// a part of the key when forwarded.
+//
+// The shared call is not canceled when the request which started it is canceled, since other requests may
+// wait for it. Each caller receives its own copy of the response.
+func (u *httpUpstream) coalescedCall(
+	ctx context.Context,
+	original *http.Request,
+	originalBody []byte,
+	retryPolicy UpstreamRetryPolicy,
+) *UpstreamResponse {
+	key, err := u.coalescingKey(ctx, original)
+	if err != nil {
+		return u.callWithRetries(ctx, original, originalBody, retryPolicy)
+	}
+
+	ch := u.coalescing.DoChan(key, func() (any, error) {
+		return u.callWithRetries(context.WithoutCancel(ctx), original, originalBody, retryPolicy), nil
+	})
+
+	select {
+	case res := <-ch:
+		return copyUpstreamResponse(res.Val.(*UpstreamResponse)) //nolint:errcheck,forcetypeassert // always a response
+	case <-ctx.Done():
+		return &UpstreamResponse{
+			Err: &UpstreamError{
+				Kind: UpstreamCanceled,
+				Err:  ctx.Err(),
+			},
+		}
+	}
+}
+
+// coalescingKey returns the key of the request sent to the upstream: its method, URL and forwarded headers.
+func (u *httpUpstream) coalescingKey(ctx context.Context, original *http.Request) (string, error) {
+	req, err := u.newRequest(ctx, u.url, original, nil)
+	if err != nil {
+		return "", err
+	}
+
+	return requestIdentity(req, nil, identityHeaders(req.Header, u.policy.Coalescing.IgnoreHeaders)), nil
+}
+
+// requestIdentity describes the request sent to an upstream: its method and URL on the first line, followed
+// by the given headers sorted by name and the SHA-256 of the body if it is not empty.
+func requestIdentity(req *http.Request, body []byte, header http.Header) string {
+	var b strings.Builder
+
+	b.WriteString(req.Method + " " + req.URL.String())
+
+	for _, name := range slices.Sorted(maps.Keys(header)) {
+		b.WriteString("\n" + name + ": " + strings.Join(header.Values(name), ","))
+	}
+
+	if len(body) > 0 {
+		b.WriteString(fmt.Sprintf("\nbody: %x", sha256.Sum256(body)))
+	}
+
+	return b.String()
+}
+
+// identityHeaders returns the headers without the ignored ones.
+func identityHeaders(header http.Header, ignore []string) http.Header {
+	identity := make(http.Header, len(header))
+
+	for name, values := range header {
+		if !containsHeader(ignore, name) {
+			identity[name] = values
+		}
+	}
+
+	return identity
+}
+
+// copyUpstreamResponse returns a copy of the response which can be modified without affecting the original.
+func copyUpstreamResponse(resp *UpstreamResponse) *UpstreamResponse {
+	cp := *resp
+	cp.Headers = resp.Headers.Clone()
+
+	if resp.Err != nil {
+		uerr := *resp.Err
+		cp.Err = &uerr
+	}
+
+	return &cp
+}
@@ -0,0 +1,78 @@
+package tokka
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"golang.org/x/sync/singleflight"
+
+	"github.com/starwalkn/tokka/internal/metric"
+)
+
+func TestHttpUpstream_CoalescedCall(t *testing.T) {
+	var calls atomic.Int32
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		time.Sleep(100 * time.Millisecond)
+
+		_, _ = w.Write([]byte(`{"user":"` + r.Header.Get("Authorization") + `"}`))
+	}))
+	defer server.Close()
+
+	u := &httpUpstream{
+		name:           "users",
+		url:            server.URL,
+		method:         http.MethodGet,
+		timeout:        time.Second,
+		forwardHeaders: []string{"Authorization", "X-Request-ID"},
+		client:         http.DefaultClient,
+		coalescing:     &singleflight.Group{},
+		metrics:        metric.NewNop(),
+		policy: UpstreamPolicy{
+			Coalescing: UpstreamCoalescingPolicy{
+				Enabled:       true,
+				IgnoreHeaders: []string{"x-request-id"},
+			},
+		},
+	}
+
+	var wg sync.WaitGroup
+
+	responses := make([]*UpstreamResponse, 10)
+
+	for i := range responses {
+		wg.Go(func() {
+			req := httptest.NewRequest(http.MethodGet, "http://example.com/users", nil)
+			req.Header.Set("X-Request-ID", string(rune('a'+i)))
+			req.Header.Set("Authorization", "alice")
+
+			if i%2 == 1 {
+				req.Header.Set("Authorization", "bob")
+			}
+
+			responses[i] = u.Call(req.Context(), req, nil, UpstreamRetryPolicy{})
+		})
+	}
+
+	wg.Wait()
+
+	if calls.Load() != 2 {
+		t.Errorf("expected 2 upstream calls, got %d", calls.Load())
+	}
+
+	for i, resp := range responses {
+		expected := `{"user":"alice"}`
+		if i%2 == 1 {
+			expected = `{"user":"bob"}`
+		}
+
+		if resp.Err != nil || string(resp.Body) != expected {
+			t.Errorf("response %d: expected %s, got %+v", i, expected, resp)
+		}
+	}
+}
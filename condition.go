@@ -0,0 +1,106 @@
+package tokka
+
+import (
+	"net/http"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+const (
+	conditionSourceHeader = "header"
+	conditionSourceQuery  = "query"
+	conditionSourceClaim  = "claim"
+	conditionSourcePath   = "path"
+)
+
+// UpstreamCondition is a predicate evaluated against the incoming request.
+//
+// The value is taken from Source (header, query, claim or path) by Name. Query values and claims
+// holding lists are split into separate values. The condition holds if any of the values satisfies
+// all configured operators. A condition without operators only requires the value to be present.
+type UpstreamCondition struct {
+	Source  string
+	Name    string
+	Equals  string
+	In      []string
+	Matches *regexp.Regexp
+	Exists  *bool
+}
+
+// matches reports whether the condition holds for the request.
+func (c UpstreamCondition) matches(req *http.Request) bool {
+	values := c.values(req)
+
+	if c.Exists != nil && !*c.Exists {
+		return len(values) == 0
+	}
+
+	return slices.ContainsFunc(values, c.matchesValue)
+}
+
+func (c UpstreamCondition) matchesValue(v string) bool {
+	if c.Equals != "" && v != c.Equals {
+		return false
+	}
+
+	if len(c.In) > 0 && !slices.Contains(c.In, v) {
+		return false
+	}
+
+	if c.Matches != nil && !c.Matches.MatchString(v) {
+		return false
+	}
+
+	return true
+}
+
+func (c UpstreamCondition) values(req *http.Request) []string {
+	switch c.Source {
+	case conditionSourceHeader:
+		return req.Header.Values(c.Name)
+	case conditionSourceQuery:
+		var values []string
+
+		for _, v := range req.URL.Query()[c.Name] {
+			values = append(values, strings.Split(v, ",")...)
+		}
+
+		return values
+	case conditionSourcePath:
+		if v, ok := PathParams(req.Context())[c.Name]; ok {
+			return []string{v}
+		}
+
+		return nil
+	case conditionSourceClaim:
+		claim, ok := ClaimsFromContext(req.Context())[c.Name]
+		if !ok {
+			return nil
+		}
+
+		if list, isList := claim.([]any); isList {
+			values := make([]string, 0, len(list))
+			for _, v := range list {
+				values = append(values, stringifyValue(v))
+			}
+
+			return values
+		}
+
+		return []string{stringifyValue(claim)}
+	default:
+		return nil
+	}
+}
+
+// conditionsMatch reports whether all conditions hold for the request.
+func conditionsMatch(conditions []UpstreamCondition, req *http.Request) bool {
+	for _, c := range conditions {
+		if !c.matches(req) {
+			return false
+		}
+	}
+
+	return true
+}
@@ -3,6 +3,7 @@ package tokka
 import (
 	"encoding/json"
 	"log"
+	"net/http"
 	"os"
 	"path/filepath"
 	"runtime"
@@ -13,8 +14,12 @@ import (
 )
 
 const (
-	defaultUpstreamTimeout = 3 * time.Second
-	defaultServerTimeout   = 5 * time.Second
+	defaultUpstreamTimeout     = 3 * time.Second
+	defaultServerTimeout       = 5 * time.Second
+	defaultMaxBodySize         = 5 << 20 // 5MB.
+	defaultHedgingDelay        = 100 * time.Millisecond
+	defaultHedgingMaxExtraLoad = 10 // Percent.
+	defaultCacheMaxEntries     = 1000
 )
 
 type GatewayConfig struct {
@@ -30,9 +35,11 @@ type GatewayConfig struct {
 }
 
 type ServerConfig struct {
-	Port    int           `json:"port" yaml:"port" toml:"port"`
-	Timeout time.Duration `json:"timeout" yaml:"timeout" toml:"timeout"`
-	Metrics MetricsConfig `json:"metrics" yaml:"metrics" toml:"metrics"`
+	Port        int           `json:"port" yaml:"port" toml:"port"`
+	Timeout     time.Duration `json:"timeout" yaml:"timeout" toml:"timeout"`
+	MaxBodySize int64         `json:"max_body_size" yaml:"max_body_size" toml:"max_body_size"`
+	ErrorFormat string        `json:"error_format" yaml:"error_format" toml:"error_format"`
+	Metrics     MetricsConfig `json:"metrics" yaml:"metrics" toml:"metrics"`
 }
 
 type MetricsConfig struct {
@@ -41,34 +48,133 @@ type MetricsConfig struct {
 }
 
 type DashboardConfig struct {
-	Enable  bool `json:"enable" yaml:"enable" toml:"enable"`
-	Port    int  `json:"port" yaml:"port" toml:"port"`
-	Timeout int  `json:"timeout" yaml:"timeout" toml:"timeout"`
+	Enable  bool                 `json:"enable" yaml:"enable" toml:"enable"`
+	Port    int                  `json:"port" yaml:"port" toml:"port"`
+	Timeout int                  `json:"timeout" yaml:"timeout" toml:"timeout"`
+	Admin   DashboardAdminConfig `json:"admin" yaml:"admin" toml:"admin"`
+}
+
+// DashboardAdminConfig enables dashboard endpoints which change the gateway state. They require the token
+// as a bearer token.
+type DashboardAdminConfig struct {
+	Enable bool   `json:"enable" yaml:"enable" toml:"enable"`
+	Token  string `json:"token" yaml:"token" toml:"token"`
 }
 
 type RouteConfig struct {
 	Path                 string             `json:"path" yaml:"path" toml:"path"`
 	Method               string             `json:"method" yaml:"method" toml:"method"`
+	Mode                 string             `json:"mode" yaml:"mode" toml:"mode"`
+	ResponseMode         string             `json:"response_mode" yaml:"response_mode" toml:"response_mode"`
 	Plugins              []PluginConfig     `json:"plugins" yaml:"plugins" toml:"plugins"`
 	Middlewares          []MiddlewareConfig `json:"middlewares" yaml:"middlewares" toml:"middlewares"`
 	Upstreams            []UpstreamConfig   `json:"upstreams" yaml:"upstreams" toml:"upstreams"`
 	Aggregation          AggregationConfig  `json:"aggregation" yaml:"aggregation" toml:"aggregation"`
 	MaxParallelUpstreams int64              `json:"max_parallel_upstreams" yaml:"max_parallel_upstreams" toml:"max_parallel_upstreams"`
+	MaxBodySize          int64              `json:"max_body_size" yaml:"max_body_size" toml:"max_body_size"`
+	WebSocket            WebSocketConfig    `json:"websocket" yaml:"websocket" toml:"websocket"`
+	SSE                  SSEConfig          `json:"sse" yaml:"sse" toml:"sse"`
+	Propagate            PropagationConfig  `json:"propagate" yaml:"propagate" toml:"propagate"`
+}
+
+type PropagationConfig struct {
+	Status         bool     `json:"status" yaml:"status" toml:"status"`
+	StatusUpstream string   `json:"status_upstream" yaml:"status_upstream" toml:"status_upstream"`
+	Headers        []string `json:"headers" yaml:"headers" toml:"headers"`
+}
+
+type SSEConfig struct {
+	HeartbeatInterval time.Duration `json:"heartbeat_interval" yaml:"heartbeat_interval" toml:"heartbeat_interval"`
+}
+
+type WebSocketConfig struct {
+	IdleTimeout    time.Duration `json:"idle_timeout" yaml:"idle_timeout" toml:"idle_timeout"`
+	MaxMessageSize int64         `json:"max_message_size" yaml:"max_message_size" toml:"max_message_size"`
 }
 
 type AggregationConfig struct {
-	Strategy            string `json:"strategy" yaml:"strategy" toml:"strategy"`
-	AllowPartialResults bool   `json:"allow_partial_results" yaml:"allow_partial_results" toml:"allow_partial_results"`
+	Strategy            string               `json:"strategy" yaml:"strategy" toml:"strategy"`
+	AllowPartialResults bool                 `json:"allow_partial_results" yaml:"allow_partial_results" toml:"allow_partial_results"`
+	ErrorMapping        []ErrorMappingConfig `json:"error_mapping" yaml:"error_mapping" toml:"error_mapping"`
+}
+
+// ErrorMappingConfig maps upstream errors matching all of the set conditions to a client error.
+type ErrorMappingConfig struct {
+	Upstream       string `json:"upstream" yaml:"upstream" toml:"upstream"`
+	Kind           string `json:"kind" yaml:"kind" toml:"kind"`
+	UpstreamStatus int    `json:"upstream_status" yaml:"upstream_status" toml:"upstream_status"`
+	Status         int    `json:"status" yaml:"status" toml:"status"`
+	Code           string `json:"code" yaml:"code" toml:"code"`
+	Message        string `json:"message" yaml:"message" toml:"message"`
 }
 
 type UpstreamConfig struct {
-	URL                 string               `json:"url" yaml:"url" toml:"url"`
-	Method              string               `json:"method" yaml:"method" toml:"method"`
-	Timeout             time.Duration        `json:"timeout" yaml:"timeout" toml:"timeout"`
-	Headers             map[string]string    `json:"headers" yaml:"headers" toml:"headers"`
-	ForwardHeaders      []string             `json:"forward_headers" yaml:"forward_headers" toml:"forward_headers"`
-	ForwardQueryStrings []string             `json:"forward_query_strings" yaml:"forward_query_strings" toml:"forward_query_strings"`
-	Policy              UpstreamPolicyConfig `json:"policy" yaml:"policy" toml:"policy"`
+	Name                string                  `json:"name" yaml:"name" toml:"name"`
+	Alias               string                  `json:"alias" yaml:"alias" toml:"alias"`
+	DependsOn           []string                `json:"depends_on" yaml:"depends_on" toml:"depends_on"`
+	When                []ConditionConfig       `json:"when" yaml:"when" toml:"when"`
+	URL                 string                  `json:"url" yaml:"url" toml:"url"`
+	Method              string                  `json:"method" yaml:"method" toml:"method"`
+	Protocol            string                  `json:"protocol" yaml:"protocol" toml:"protocol"`
+	Timeout             time.Duration           `json:"timeout" yaml:"timeout" toml:"timeout"`
+	Headers             map[string]string       `json:"headers" yaml:"headers" toml:"headers"`
+	ForwardHeaders      []string                `json:"forward_headers" yaml:"forward_headers" toml:"forward_headers"`
+	ForwardQueryStrings []string                `json:"forward_query_strings" yaml:"forward_query_strings" toml:"forward_query_strings"`
+	Policy              UpstreamPolicyConfig    `json:"policy" yaml:"policy" toml:"policy"`
+	Transform           UpstreamTransformConfig `json:"transform" yaml:"transform" toml:"transform"`
+	GRPC                UpstreamGRPCConfig      `json:"grpc" yaml:"grpc" toml:"grpc"`
+	GraphQL             UpstreamGraphQLConfig   `json:"graphql" yaml:"graphql" toml:"graphql"`
+	Static              UpstreamStaticConfig    `json:"static" yaml:"static" toml:"static"`
+}
+
+type UpstreamGraphQLConfig struct {
+	Query         string         `json:"query" yaml:"query" toml:"query"`
+	OperationName string         `json:"operation_name" yaml:"operation_name" toml:"operation_name"`
+	Variables     map[string]any `json:"variables" yaml:"variables" toml:"variables"`
+}
+
+type UpstreamGRPCConfig struct {
+	DescriptorSet string `json:"descriptor_set" yaml:"descriptor_set" toml:"descriptor_set"`
+}
+
+type UpstreamStaticConfig struct {
+	Enabled       bool              `json:"enabled" yaml:"enabled" toml:"enabled"`
+	Status        int               `json:"status" yaml:"status" toml:"status"`
+	Headers       map[string]string `json:"headers" yaml:"headers" toml:"headers"`
+	Body          any               `json:"body" yaml:"body" toml:"body"`
+	BodyFile      string            `json:"body_file" yaml:"body_file" toml:"body_file"`
+	Latency       time.Duration     `json:"latency" yaml:"latency" toml:"latency"`
+	FailureRate   float64           `json:"failure_rate" yaml:"failure_rate" toml:"failure_rate"`
+	FailureStatus int               `json:"failure_status" yaml:"failure_status" toml:"failure_status"`
+}
+
+type ConditionConfig struct {
+	Source  string   `json:"source" yaml:"source" toml:"source"`
+	Name    string   `json:"name" yaml:"name" toml:"name"`
+	Equals  string   `json:"equals" yaml:"equals" toml:"equals"`
+	In      []string `json:"in" yaml:"in" toml:"in"`
+	Matches string   `json:"matches" yaml:"matches" toml:"matches"`
+	Exists  *bool    `json:"exists" yaml:"exists" toml:"exists"`
+}
+
+type UpstreamTransformConfig struct {
+	Request  RequestTransformConfig  `json:"request" yaml:"request" toml:"request"`
+	Response ResponseTransformConfig `json:"response" yaml:"response" toml:"response"`
+}
+
+type RequestTransformConfig struct {
+	Rename   map[string]string `json:"rename" yaml:"rename" toml:"rename"`
+	Remove   []string          `json:"remove" yaml:"remove" toml:"remove"`
+	Set      map[string]any    `json:"set" yaml:"set" toml:"set"`
+	Template any               `json:"template" yaml:"template" toml:"template"`
+}
+
+type ResponseTransformConfig struct {
+	Extract string            `json:"extract" yaml:"extract" toml:"extract"`
+	Allow   []string          `json:"allow" yaml:"allow" toml:"allow"`
+	Deny    []string          `json:"deny" yaml:"deny" toml:"deny"`
+	Rename  map[string]string `json:"rename" yaml:"rename" toml:"rename"`
+	Flatten []string          `json:"flatten" yaml:"flatten" toml:"flatten"`
 }
 
 type UpstreamPolicyConfig struct {
@@ -79,18 +185,76 @@ type UpstreamPolicyConfig struct {
 
 	RetryConfig          UpstreamRetryConfig          `json:"retry" yaml:"retry" toml:"retry"`
 	CircuitBreakerConfig UpstreamCircuitBreakerConfig `json:"circuit_breaker" yaml:"circuit_breaker" toml:"circuit_breaker"`
+	HedgingConfig        UpstreamHedgingConfig        `json:"hedging" yaml:"hedging" toml:"hedging"`
+	FallbackConfig       UpstreamFallbackConfig       `json:"fallback" yaml:"fallback" toml:"fallback"`
+	CacheConfig          UpstreamCacheConfig          `json:"cache" yaml:"cache" toml:"cache"`
+	CoalescingConfig     UpstreamCoalescingConfig     `json:"coalescing" yaml:"coalescing" toml:"coalescing"`
 }
 
 type UpstreamRetryConfig struct {
-	MaxRetries      int           `json:"max_retries" yaml:"max_retries" toml:"max_retries"`
-	RetryOnStatuses []int         `json:"retry_on_statuses" yaml:"retry_on_statuses" toml:"retry_on_statuses"`
-	BackoffDelay    time.Duration `json:"backoff_delay" yaml:"backoff_delay" toml:"backoff_delay"`
+	MaxRetries         int           `json:"max_retries" yaml:"max_retries" toml:"max_retries"`
+	RetryOnStatuses    []int         `json:"retry_on_statuses" yaml:"retry_on_statuses" toml:"retry_on_statuses"`
+	BackoffDelay       time.Duration `json:"backoff_delay" yaml:"backoff_delay" toml:"backoff_delay"`
+	Backoff            string        `json:"backoff" yaml:"backoff" toml:"backoff"`
+	BackoffMultiplier  float64       `json:"backoff_multiplier" yaml:"backoff_multiplier" toml:"backoff_multiplier"`
+	MaxBackoffDelay    time.Duration `json:"max_backoff_delay" yaml:"max_backoff_delay" toml:"max_backoff_delay"`
+	Jitter             string        `json:"jitter" yaml:"jitter" toml:"jitter"`
+	RespectRetryAfter  bool          `json:"respect_retry_after" yaml:"respect_retry_after" toml:"respect_retry_after"`
+	RetryNonIdempotent bool          `json:"retry_non_idempotent" yaml:"retry_non_idempotent" toml:"retry_non_idempotent"`
+	BudgetRatio        float64       `json:"budget_ratio" yaml:"budget_ratio" toml:"budget_ratio"`
 }
 
 type UpstreamCircuitBreakerConfig struct {
+	Enabled               bool          `json:"enabled" yaml:"enabled" toml:"enabled"`
+	MaxFailures           int           `json:"max_failures" yaml:"max_failures" toml:"max_failures"`
+	ResetTimeout          time.Duration `json:"reset_timeout" yaml:"reset_timeout" toml:"reset_timeout"`
+	FailureRateThreshold  float64       `json:"failure_rate_threshold" yaml:"failure_rate_threshold" toml:"failure_rate_threshold"`
+	SlowCallRateThreshold float64       `json:"slow_call_rate_threshold" yaml:"slow_call_rate_threshold" toml:"slow_call_rate_threshold"`
+	SlowCallDuration      time.Duration `json:"slow_call_duration" yaml:"slow_call_duration" toml:"slow_call_duration"`
+	WindowType            string        `json:"window_type" yaml:"window_type" toml:"window_type"`
+	WindowSize            int           `json:"window_size" yaml:"window_size" toml:"window_size"`
+	WindowDuration        time.Duration `json:"window_duration" yaml:"window_duration" toml:"window_duration"`
+	MinimumRequests       int           `json:"minimum_requests" yaml:"minimum_requests" toml:"minimum_requests"`
+	HalfOpenProbes        int           `json:"half_open_probes" yaml:"half_open_probes" toml:"half_open_probes"`
+}
+
+type UpstreamHedgingConfig struct {
 	Enabled      bool          `json:"enabled" yaml:"enabled" toml:"enabled"`
-	MaxFailures  int           `json:"max_failures" yaml:"max_failures" toml:"max_failures"`
-	ResetTimeout time.Duration `json:"reset_timeout" yaml:"reset_timeout" toml:"reset_timeout"`
+	URLs         []string      `json:"urls" yaml:"urls" toml:"urls"`
+	Delay        time.Duration `json:"delay" yaml:"delay" toml:"delay"`
+	Percentile   float64       `json:"percentile" yaml:"percentile" toml:"percentile"`
+	MaxExtraLoad float64       `json:"max_extra_load" yaml:"max_extra_load" toml:"max_extra_load"`
+}
+
+type UpstreamFallbackConfig struct {
+	Enabled  bool          `json:"enabled" yaml:"enabled" toml:"enabled"`
+	URL      string        `json:"url" yaml:"url" toml:"url"`
+	Stale    bool          `json:"stale" yaml:"stale" toml:"stale"`
+	StaleTTL time.Duration `json:"stale_ttl" yaml:"stale_ttl" toml:"stale_ttl"`
+	Body     any           `json:"body" yaml:"body" toml:"body"`
+	Status   int           `json:"status" yaml:"status" toml:"status"`
+}
+
+type UpstreamCacheConfig struct {
+	Enabled              bool           `json:"enabled" yaml:"enabled" toml:"enabled"`
+	TTL                  time.Duration  `json:"ttl" yaml:"ttl" toml:"ttl"`
+	StaleWhileRevalidate time.Duration  `json:"stale_while_revalidate" yaml:"stale_while_revalidate" toml:"stale_while_revalidate"`
+	StaleIfError         time.Duration  `json:"stale_if_error" yaml:"stale_if_error" toml:"stale_if_error"`
+	MaxEntries           int            `json:"max_entries" yaml:"max_entries" toml:"max_entries"`
+	MaxSize              int64          `json:"max_size" yaml:"max_size" toml:"max_size"`
+	Key                  CacheKeyConfig `json:"key" yaml:"key" toml:"key"`
+}
+
+type CacheKeyConfig struct {
+	Headers       []string `json:"headers" yaml:"headers" toml:"headers"`
+	Query         []string `json:"query" yaml:"query" toml:"query"`
+	Claims        []string `json:"claims" yaml:"claims" toml:"claims"`
+	IgnoreHeaders []string `json:"ignore_headers" yaml:"ignore_headers" toml:"ignore_headers"`
+}
+
+type UpstreamCoalescingConfig struct {
+	Enabled       bool     `json:"enabled" yaml:"enabled" toml:"enabled"`
+	IgnoreHeaders []string `json:"ignore_headers" yaml:"ignore_headers" toml:"ignore_headers"`
 }
 
 type PluginConfig struct {
@@ -146,7 +310,15 @@ func ensureDefaults(cfg GatewayConfig) GatewayConfig {
 		cfg.Server.Timeout = defaultServerTimeout
 	}
 
+	if cfg.Server.MaxBodySize == 0 {
+		cfg.Server.MaxBodySize = defaultMaxBodySize
+	}
+
 	for i := range cfg.Routes {
+		if cfg.Routes[i].MaxBodySize == 0 {
+			cfg.Routes[i].MaxBodySize = cfg.Server.MaxBodySize
+		}
+
 		if cfg.Routes[i].MaxParallelUpstreams < 1 {
 			cfg.Routes[i].MaxParallelUpstreams = 2 * int64(runtime.NumCPU()) //nolint:mnd // shut up mnd
 		}
@@ -155,6 +327,28 @@ func ensureDefaults(cfg GatewayConfig) GatewayConfig {
 			if cfg.Routes[i].Upstreams[j].Timeout == 0 {
 				cfg.Routes[i].Upstreams[j].Timeout = defaultUpstreamTimeout
 			}
+
+			if hedging := &cfg.Routes[i].Upstreams[j].Policy.HedgingConfig; hedging.Enabled {
+				if hedging.Delay == 0 {
+					hedging.Delay = defaultHedgingDelay
+				}
+
+				if hedging.MaxExtraLoad == 0 {
+					hedging.MaxExtraLoad = defaultHedgingMaxExtraLoad
+				}
+			}
+
+			if fallback := &cfg.Routes[i].Upstreams[j].Policy.FallbackConfig; fallback.Enabled && fallback.Status == 0 {
+				fallback.Status = http.StatusOK
+			}
+
+			if c := &cfg.Routes[i].Upstreams[j].Policy.CacheConfig; c.Enabled && c.MaxEntries == 0 && c.MaxSize == 0 {
+				c.MaxEntries = defaultCacheMaxEntries
+			}
+
+			if static := &cfg.Routes[i].Upstreams[j].Static; static.Enabled && static.Status == 0 {
+				static.Status = http.StatusOK
+			}
 		}
 	}
 
@@ -1,6 +1,9 @@
 package tokka
 
-import "net/http"
+import (
+	"context"
+	"net/http"
+)
 
 // Context is the internal interface that holds the request and response objects.
 type Context interface {
@@ -26,3 +29,30 @@ func (c *defaultContext) Request() *http.Request       { return c.req }
 func (c *defaultContext) Response() *http.Response     { return c.resp }
 func (c *defaultContext) SetRequest(r *http.Request)   { c.req = r }
 func (c *defaultContext) SetResponse(r *http.Response) { c.resp = r }
+
+type (
+	ctxKeyClaims     struct{}
+	ctxKeyPathParams struct{}
+)
+
+// WithClaims returns a copy of ctx carrying the claims of an authenticated request.
+// Auth middlewares use it to expose claims to upstream conditions.
+func WithClaims(ctx context.Context, claims map[string]any) context.Context {
+	return context.WithValue(ctx, ctxKeyClaims{}, claims)
+}
+
+// ClaimsFromContext returns the claims stored by WithClaims or nil.
+func ClaimsFromContext(ctx context.Context) map[string]any {
+	claims, _ := ctx.Value(ctxKeyClaims{}).(map[string]any)
+	return claims
+}
+
+func withPathParams(ctx context.Context, params map[string]string) context.Context {
+	return context.WithValue(ctx, ctxKeyPathParams{}, params)
+}
+
+// PathParams returns the path parameters matched by the route pattern, e.g. {id} in /users/{id}.
+func PathParams(ctx context.Context) map[string]string {
+	params, _ := ctx.Value(ctxKeyPathParams{}).(map[string]string)
+	return params
+}
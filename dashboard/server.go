@@ -1,7 +1,9 @@
 package dashboard
 
 import (
+	"crypto/subtle"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"path/filepath"
@@ -13,44 +15,124 @@ import (
 )
 
 type Server struct {
-	cfg *tokka.GatewayConfig
-	log *zap.Logger
+	cfg    *tokka.GatewayConfig
+	router *tokka.Router
+	log    *zap.Logger
 }
 
-func NewServer(cfg *tokka.GatewayConfig, log *zap.Logger) *Server {
+func NewServer(cfg *tokka.GatewayConfig, router *tokka.Router, log *zap.Logger) *Server {
 	return &Server{
-		cfg: cfg,
-		log: log,
+		cfg:    cfg,
+		router: router,
+		log:    log,
 	}
 }
 
 func (s *Server) Start() {
+	addr := fmt.Sprintf(":%d", s.cfg.Dashboard.Port)
+
+	server := http.Server{
+		Addr:         addr,
+		Handler:      s.handler(),
+		ReadTimeout:  time.Duration(s.cfg.Dashboard.Timeout) * time.Second,
+		WriteTimeout: time.Duration(s.cfg.Dashboard.Timeout) * time.Second,
+	}
+
+	s.log.Info("dashboard server started", zap.String("addr", addr))
+
+	if err := server.ListenAndServe(); err != nil {
+		s.log.Error("dashboard server had errors, processed shutdown", zap.Error(err))
+		return
+	}
+}
+
+func (s *Server) handler() http.Handler {
 	mux := http.NewServeMux()
 
 	staticDir := filepath.Join("/", "dashboard", "static")
 	mux.Handle("/", http.FileServer(http.Dir(staticDir)))
 
 	mux.HandleFunc("/config", func(w http.ResponseWriter, _ *http.Request) {
+		// The admin token is never exposed.
+		cfg := *s.cfg
+		cfg.Dashboard.Admin.Token = ""
+
 		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(http.StatusOK)
 
 		//nolint:errcheck,gosec // its ok
-		json.NewEncoder(w).Encode(s.cfg)
+		json.NewEncoder(w).Encode(cfg)
 	})
 
-	addr := fmt.Sprintf(":%d", s.cfg.Dashboard.Port)
+	mux.HandleFunc("GET /circuit-breakers", func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
 
-	server := http.Server{
-		Addr:         addr,
-		Handler:      mux,
-		ReadTimeout:  time.Duration(s.cfg.Dashboard.Timeout) * time.Second,
-		WriteTimeout: time.Duration(s.cfg.Dashboard.Timeout) * time.Second,
+		//nolint:errcheck,gosec // its ok
+		json.NewEncoder(w).Encode(s.router.CircuitBreakers())
+	})
+
+	// Admin endpoints change the gateway state, so they are served only if enabled and require the admin token.
+	if s.cfg.Dashboard.Admin.Enable {
+		if s.cfg.Dashboard.Admin.Token == "" {
+			s.log.Fatal("dashboard admin endpoints require a token")
+		}
+
+		// Manual control of circuit breakers, e.g. POST /circuit-breakers/force_open?upstream=users.
+		mux.Handle("POST /circuit-breakers/{action}", s.requireAdminToken(http.HandlerFunc(s.handleCircuitBreakerAction)))
+
+		// Purge of cached upstream responses, e.g. POST /cache/purge?upstream=users&path=/v1/users/42.
+		mux.Handle("POST /cache/purge", s.requireAdminToken(http.HandlerFunc(s.handleCachePurge)))
 	}
 
-	s.log.Info("dashboard server started", zap.String("addr", addr))
+	return mux
+}
 
-	if err := server.ListenAndServe(); err != nil {
-		s.log.Error("dashboard server had errors, processed shutdown", zap.Error(err))
+// requireAdminToken rejects requests without the admin bearer token.
+func (s *Server) requireAdminToken(next http.Handler) http.Handler {
+	expected := []byte("Bearer " + s.cfg.Dashboard.Admin.Token)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), expected) != 1 {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (s *Server) handleCircuitBreakerAction(w http.ResponseWriter, r *http.Request) {
+	err := s.router.ControlCircuitBreaker(r.URL.Query().Get("upstream"), r.PathValue("action"))
+
+	switch {
+	case errors.Is(err, tokka.ErrCircuitBreakerNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case errors.Is(err, tokka.ErrUnknownCircuitBreakerAction):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	default:
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func (s *Server) handleCachePurge(w http.ResponseWriter, r *http.Request) {
+	upstream, path := r.URL.Query().Get("upstream"), r.URL.Query().Get("path")
+	if upstream == "" {
+		http.Error(w, "upstream is required", http.StatusBadRequest)
 		return
 	}
+
+	purged := s.router.PurgeCache(upstream, path)
+
+	s.log.Info("cache purged", zap.String("upstream", upstream), zap.String("path", path), zap.Int("purged", purged))
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+
+	//nolint:errcheck,gosec // its ok
+	json.NewEncoder(w).Encode(map[string]int{"purged": purged})
 }
@@ -0,0 +1,68 @@
+package dashboard
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"go.uber.org/zap"
+
+	"github.com/starwalkn/tokka"
+)
+
+func TestServer_AdminEndpoints(t *testing.T) {
+	cfg := &tokka.GatewayConfig{}
+
+	do := func(s *Server, target, token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, target, nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+
+		rec := httptest.NewRecorder()
+		s.handler().ServeHTTP(rec, req)
+
+		return rec
+	}
+
+	// Disabled admin endpoints are not served.
+	disabled := NewServer(cfg, &tokka.Router{}, zap.NewNop())
+
+	if rec := do(disabled, "/circuit-breakers/reset?upstream=users", ""); strings.Contains(rec.Body.String(), tokka.ErrCircuitBreakerNotFound.Error()) {
+		t.Errorf("expected circuit breaker actions to be disabled, got %d %s", rec.Code, rec.Body)
+	}
+
+	if rec := do(disabled, "/cache/purge?upstream=users", ""); strings.Contains(rec.Body.String(), "purged") {
+		t.Errorf("expected cache purge to be disabled, got %d %s", rec.Code, rec.Body)
+	}
+
+	cfg.Dashboard.Admin = tokka.DashboardAdminConfig{Enable: true, Token: "secret"}
+	enabled := NewServer(cfg, &tokka.Router{}, zap.NewNop())
+
+	for _, token := range []string{"", "wrong"} {
+		if rec := do(enabled, "/circuit-breakers/reset?upstream=users", token); rec.Code != http.StatusUnauthorized {
+			t.Errorf("token %q: expected 401, got %d", token, rec.Code)
+		}
+	}
+
+	if rec := do(enabled, "/circuit-breakers/reset?upstream=users", "secret"); rec.Code != http.StatusNotFound {
+		t.Errorf("expected the action to be applied, got %d %s", rec.Code, rec.Body)
+	}
+
+	if rec := do(enabled, "/cache/purge?upstream=users", "wrong"); rec.Code != http.StatusUnauthorized {
+		t.Errorf("expected 401 for cache purge, got %d", rec.Code)
+	}
+
+	if rec := do(enabled, "/cache/purge?upstream=users", "secret"); rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `"purged":0`) {
+		t.Errorf("expected cache purge, got %d %s", rec.Code, rec.Body)
+	}
+
+	// The token is not exposed by the configuration endpoint.
+	rec := httptest.NewRecorder()
+	enabled.handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/config", nil))
+
+	if strings.Contains(rec.Body.String(), "secret") {
+		t.Errorf("expected the admin token to be redacted, got %s", rec.Body)
+	}
+}
@@ -40,7 +40,18 @@ interface GatewayConfig {
   routes: RouteConfig[];
 }
 
+interface CircuitBreakerStatus {
+  route: string;
+  upstream: string;
+  state: "closed" | "open" | "half_open";
+  forced: boolean;
+  failures: number;
+  requests: number;
+  next_retry_at?: string;
+}
+
 const CONFIG_URL = "config";
+const CIRCUIT_BREAKERS_URL = "circuit-breakers";
 let ALL_ROUTES: RouteConfig[] = [];
 declare const CodeMirror: any;
 let FULL_CONFIG: GatewayConfig | null = null;
@@ -202,6 +213,77 @@ function renderRoutes(routes: RouteConfig[] = []) {
   });
 }
 
+// === Circuit Breakers ===
+
+async function fetchCircuitBreakers(): Promise<CircuitBreakerStatus[]> {
+  const resp = await fetch(CIRCUIT_BREAKERS_URL);
+  if (!resp.ok) throw new Error(`Circuit breakers load failed: ${resp.status}`);
+  return resp.json();
+}
+
+async function controlCircuitBreaker(upstream: string, action: string) {
+  const resp = await fetch(
+    `${CIRCUIT_BREAKERS_URL}/${action}?upstream=${encodeURIComponent(upstream)}`,
+    { method: "POST" },
+  );
+  if (!resp.ok) throw new Error(`Circuit breaker ${action} failed: ${resp.status}`);
+}
+
+function renderCircuitBreakers(breakers: CircuitBreakerStatus[]) {
+  const container = document.getElementById("breakers-list");
+  if (!container) return;
+  if (breakers.length === 0) {
+    container.innerHTML = `<div class="glass-card">No circuit breakers configured.</div>`;
+    return;
+  }
+
+  container.innerHTML = breakers
+    .map((b) => {
+      const stateClass = b.state === "closed" ? "status-ok" : "status-error";
+      const nextRetry = b.next_retry_at
+        ? new Date(b.next_retry_at).toLocaleTimeString()
+        : "N/A";
+
+      return `
+    <div class="plugin-item glass-card">
+      <div class="plugin-name">${escapeHtml(b.upstream)}</div>
+      <p class="meta"><span class="label">Route</span><span class="value">${escapeHtml(b.route)}</span></p>
+      <p class="meta"><span class="label">State</span><span class="status-badge ${stateClass}">● ${escapeHtml(b.state)}${b.forced ? " (forced)" : ""}</span></p>
+      <p class="meta"><span class="label">Failures</span><span class="value">${b.failures}${b.requests ? ` / ${b.requests}` : ""}</span></p>
+      <p class="meta"><span class="label">Next Retry</span><span class="value">${escapeHtml(nextRetry)}</span></p>
+      <div class="editor-actions">
+        <button class="secondary-btn" type="button" data-upstream="${escapeAttr(b.upstream)}" data-action="force_open">Force Open</button>
+        <button class="secondary-btn" type="button" data-upstream="${escapeAttr(b.upstream)}" data-action="force_close">Force Close</button>
+        <button class="secondary-btn" type="button" data-upstream="${escapeAttr(b.upstream)}" data-action="reset">Reset</button>
+      </div>
+    </div>`;
+    })
+    .join("");
+
+  container
+    .querySelectorAll<HTMLButtonElement>("button[data-action]")
+    .forEach((btn) => {
+      btn.addEventListener("click", async () => {
+        const upstream = btn.getAttribute("data-upstream") || "";
+        const action = btn.getAttribute("data-action") || "";
+        try {
+          await controlCircuitBreaker(upstream, action);
+        } catch (err) {
+          console.error(err);
+        }
+        await loadCircuitBreakers();
+      });
+    });
+}
+
+async function loadCircuitBreakers() {
+  try {
+    renderCircuitBreakers(await fetchCircuitBreakers());
+  } catch (err) {
+    console.error("Circuit breakers load failed:", err);
+  }
+}
+
 // === Configuration Editor Logic ===
 
 function setupConfigEditor(cfg: GatewayConfig) {
@@ -352,6 +434,9 @@ document.addEventListener("DOMContentLoaded", async () => {
   setupTabs();
   const refreshBtn = document.getElementById("refresh");
   if (refreshBtn) refreshBtn.addEventListener("click", () => void init());
+  const refreshBreakersBtn = document.getElementById("refresh-breakers");
+  if (refreshBreakersBtn)
+    refreshBreakersBtn.addEventListener("click", () => void loadCircuitBreakers());
   await init();
 });
 
@@ -368,6 +453,8 @@ async function init() {
     ALL_ROUTES = cfg.routes || [];
     renderRoutes(ALL_ROUTES);
     setupFilters();
+
+    await loadCircuitBreakers();
   } catch (err) {
     console.error("Admin init failed:", err);
     const main = document.querySelector("main");
@@ -0,0 +1,223 @@
+package tokka
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	xmlAttributePrefix = "@"
+	xmlTextKey         = "#text"
+)
+
+// decodeBody converts an upstream response body to JSON according to its Content-Type. Besides the documents
+// converted by decodeDocument, plain text becomes a JSON string. Text which is valid JSON is kept as-is,
+// because many services send JSON as text/plain.
+func decodeBody(header http.Header, body []byte) ([]byte, error) {
+	mt := mediaType(header)
+
+	if len(body) > 0 && strings.HasPrefix(mt, "text/") && !isXMLMediaType(mt) && !json.Valid(body) {
+		return json.Marshal(string(body))
+	}
+
+	return decodeDocument(header, body)
+}
+
+// decodeDocument converts XML and form-encoded upstream response bodies to JSON:
+//
+//   - XML documents become an object keyed by the root element, see decodeXML.
+//   - Form-encoded bodies become an object of strings, repeated keys become arrays of strings.
+//
+// Bodies of other media types or without Content-Type are returned as-is.
+func decodeDocument(header http.Header, body []byte) ([]byte, error) {
+	if len(body) == 0 {
+		return body, nil
+	}
+
+	var (
+		v   any
+		err error
+	)
+
+	switch mt := mediaType(header); {
+	case isXMLMediaType(mt):
+		v, err = decodeXML(body)
+	case mt == "application/x-www-form-urlencoded":
+		v, err = decodeForm(body)
+	default:
+		return body, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(v)
+}
+
+// mediaType returns the media type of the Content-Type header, or an empty string if it is missing or invalid.
+func mediaType(header http.Header) string {
+	mt, _, err := mime.ParseMediaType(header.Get("Content-Type"))
+	if err != nil {
+		return ""
+	}
+
+	return mt
+}
+
+func isXMLMediaType(mt string) bool {
+	return mt == "application/xml" || mt == "text/xml" || strings.HasSuffix(mt, "+xml")
+}
+
+// decodeXML converts an XML document to an object with the root element as its only key. Elements with
+// text only become strings, others become objects of their children. Attributes are keyed with the "@"
+// prefix, and the text of elements having attributes or children with "#text". Repeated elements become
+// arrays. Namespaces are dropped and values are not converted to numbers or booleans.
+func decodeXML(body []byte) (any, error) {
+	dec := xml.NewDecoder(bytes.NewReader(body))
+	dec.CharsetReader = xmlCharsetReader
+
+	for {
+		tok, err := dec.Token()
+		if errors.Is(err, io.EOF) {
+			return nil, errors.New("xml document has no root element")
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		if start, ok := tok.(xml.StartElement); ok {
+			v, err := decodeXMLElement(dec, start)
+			if err != nil {
+				return nil, err
+			}
+
+			return map[string]any{start.Name.Local: v}, nil
+		}
+	}
+}
+
+func decodeXMLElement(dec *xml.Decoder, start xml.StartElement) (any, error) {
+	fields := make(map[string]any)
+
+	for _, attr := range start.Attr {
+		if attr.Name.Space == "xmlns" || attr.Name.Local == "xmlns" {
+			continue
+		}
+
+		fields[xmlAttributePrefix+attr.Name.Local] = attr.Value
+	}
+
+	var text strings.Builder
+
+	for {
+		tok, err := dec.Token()
+		if err != nil {
+			return nil, err
+		}
+
+		switch tok := tok.(type) {
+		case xml.StartElement:
+			child, err := decodeXMLElement(dec, tok)
+			if err != nil {
+				return nil, err
+			}
+
+			// Child values are strings or objects, so an array marks a repeated element.
+			switch existing := fields[tok.Name.Local].(type) {
+			case nil:
+				fields[tok.Name.Local] = child
+			case []any:
+				fields[tok.Name.Local] = append(existing, child)
+			default:
+				fields[tok.Name.Local] = []any{existing, child}
+			}
+		case xml.CharData:
+			text.Write(tok)
+		case xml.EndElement:
+			s := strings.TrimSpace(text.String())
+			if len(fields) == 0 {
+				return s, nil
+			}
+
+			if s != "" {
+				fields[xmlTextKey] = s
+			}
+
+			return fields, nil
+		}
+	}
+}
+
+// windows1252 maps the bytes 0x80-0x9F of windows-1252 to runes, other bytes are the same as in ISO-8859-1.
+// Undefined bytes are kept as C1 control characters.
+var windows1252 = [32]rune{
+	'\u20AC', '\u0081', '\u201A', '\u0192', '\u201E', '\u2026', '\u2020', '\u2021',
+	'\u02C6', '\u2030', '\u0160', '\u2039', '\u0152', '\u008D', '\u017D', '\u008F',
+	'\u0090', '\u2018', '\u2019', '\u201C', '\u201D', '\u2022', '\u2013', '\u2014',
+	'\u02DC', '\u2122', '\u0161', '\u203A', '\u0153', '\u009D', '\u017E', '\u0178',
+}
+
+// xmlCharsetReader converts XML documents declared in single-byte Latin charsets to UTF-8. As in browsers,
+// ISO-8859-1 and ASCII labels are decoded as windows-1252, which is their superset.
+func xmlCharsetReader(label string, input io.Reader) (io.Reader, error) {
+	switch strings.ToLower(label) {
+	case "iso-8859-1", "iso8859-1", "latin1", "l1", "us-ascii", "ascii", "windows-1252", "cp1252":
+	default:
+		return nil, fmt.Errorf("unsupported xml charset %q", label)
+	}
+
+	data, err := io.ReadAll(input)
+	if err != nil {
+		return nil, err
+	}
+
+	buf := make([]byte, 0, len(data))
+
+	for _, b := range data {
+		r := rune(b)
+		if b >= 0x80 && b < 0xA0 {
+			r = windows1252[b-0x80]
+		}
+
+		buf = utf8.AppendRune(buf, r)
+	}
+
+	return bytes.NewReader(buf), nil
+}
+
+// decodeForm converts a form-encoded body to an object. Keys with several values become arrays.
+func decodeForm(body []byte) (any, error) {
+	values, err := url.ParseQuery(string(body))
+	if err != nil {
+		return nil, err
+	}
+
+	fields := make(map[string]any, len(values))
+
+	for k, v := range values {
+		if len(v) == 1 {
+			fields[k] = v[0]
+			continue
+		}
+
+		arr := make([]any, 0, len(v))
+		for _, s := range v {
+			arr = append(arr, s)
+		}
+
+		fields[k] = arr
+	}
+
+	return fields, nil
+}
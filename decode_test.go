@@ -0,0 +1,27 @@
+package tokka
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestDecodeBody_XMLCharset(t *testing.T) {
+	header := http.Header{"Content-Type": []string{"application/xml"}}
+
+	// "Café" and the euro sign in ISO-8859-1 and windows-1252.
+	body := []byte("<?xml version=\"1.0\" encoding=\"ISO-8859-1\"?><menu><item>Caf\xe9</item><price>\x80 3</price></menu>")
+
+	got, err := decodeBody(header, body)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if want := `{"menu":{"item":"Café","price":"€ 3"}}`; string(got) != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+
+	_, err = decodeBody(header, []byte(`<?xml version="1.0" encoding="KOI8-R"?><menu/>`))
+	if err == nil {
+		t.Error("expected error for unsupported charset")
+	}
+}
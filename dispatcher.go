@@ -1,6 +1,8 @@
 package tokka
 
 import (
+	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
@@ -14,10 +16,15 @@ import (
 	"github.com/starwalkn/tokka/internal/metric"
 )
 
-const maxBodySize = 5 << 20 // 5MB
+var (
+	errBodyTooLarge = errors.New("request body too large")
+	errBodyRead     = errors.New("cannot read request body")
+
+	errBodyInvalidJSON = errors.New("request body is not a valid JSON")
+)
 
 type dispatcher interface {
-	dispatch(route *Route, original *http.Request) []UpstreamResponse
+	dispatch(route *Route, original *http.Request) ([]UpstreamResponse, error)
 }
 
 type defaultDispatcher struct {
@@ -25,42 +32,184 @@ type defaultDispatcher struct {
 	metrics metric.Metrics
 }
 
-// dispatch sends the incoming HTTP request to all upstreams configured for the given route.
-// It reads and limits the request body, launches concurrent requests to upstreams using
-// a semaphore to control parallelism, applies upstream policies (like allowed statuses,
-// required body, status code mapping, max response size), updates metrics, and collects
-// the responses into a slice. Any policy violations or request errors are wrapped in
-// UpstreamError. The dispatcher waits for all upstream requests to complete before returning.
-func (d *defaultDispatcher) dispatch(route *Route, original *http.Request) []UpstreamResponse {
-	results := make([]UpstreamResponse, len(route.Upstreams))
+// dispatch sends the incoming HTTP request to the upstreams configured for the given route.
+// It reads and limits the request body and delegates to the dispatch mode of the route's
+// aggregation strategy:
+//
+//   - first_success: upstreams are called one by one until the first successful response.
+//   - race: upstreams are called concurrently, the first successful response wins and the rest are canceled.
+//   - otherwise all upstreams are called concurrently respecting their dependencies.
+//
+// Every upstream response goes through the upstream policies (like allowed statuses,
+// required body, status code mapping, max response size) and the response transform.
+// Any policy violations or request errors are wrapped in UpstreamError.
+//
+// An error is returned only if the request body cannot be read (errBodyRead), exceeds the route
+// limit (errBodyTooLarge) or cannot be shaped by request transforms (errBodyInvalidJSON). In this case
+// no upstream is called.
+func (d *defaultDispatcher) dispatch(route *Route, original *http.Request) ([]UpstreamResponse, error) {
+	originalBody, err := d.readBody(original, route.bodyLimit())
+	if err != nil {
+		return nil, err
+	}
+
+	if err = validateTransformBody(route, originalBody); err != nil {
+		return nil, err
+	}
+
+	var responses []UpstreamResponse
+
+	switch route.Aggregation.Strategy {
+	case strategyFirstSuccess:
+		responses = d.dispatchFirstSuccess(route, original, originalBody)
+	case strategyRace:
+		responses = d.dispatchRace(route, original, originalBody)
+	default:
+		responses = d.dispatchAll(route, original, originalBody)
+	}
+
+	for i := range responses {
+		responses[i].Name = route.Upstreams[i].Name()
+		responses[i].Upstream = route.Upstreams[i].Policy().Alias
+	}
+
+	return responses, nil
+}
+
+// readBody reads the request body up to the limit. Requests whose Content-Length exceeds the limit
+// are rejected before reading. A negative limit means no limit.
+func (d *defaultDispatcher) readBody(original *http.Request, limit int64) ([]byte, error) {
+	if original.Body == nil || original.Body == http.NoBody {
+		return nil, nil
+	}
+
+	defer func() {
+		if err := original.Body.Close(); err != nil {
+			d.log.Warn("cannot close original request body", zap.Error(err))
+		}
+	}()
+
+	if limit >= 0 && original.ContentLength > limit {
+		return nil, errBodyTooLarge
+	}
+
+	var reader io.Reader = original.Body
+	if limit >= 0 {
+		reader = io.LimitReader(original.Body, limit+1)
+	}
 
-	originalBody, readErr := io.ReadAll(io.LimitReader(original.Body, maxBodySize+1))
-	if readErr != nil {
-		d.log.Error("cannot read body", zap.Error(readErr))
+	body, err := io.ReadAll(reader)
+	if err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			return nil, errBodyTooLarge
+		}
+
+		return nil, fmt.Errorf("%w: %w", errBodyRead, err)
+	}
+
+	if limit >= 0 && int64(len(body)) > limit {
+		return nil, errBodyTooLarge
+	}
+
+	return body, nil
+}
+
+// validateTransformBody checks once per request that the request transforms of the route upstreams can shape
+// the body. Rename, remove and set need a JSON body, and set needs a JSON object.
+func validateTransformBody(route *Route, body []byte) error {
+	if len(body) == 0 {
 		return nil
 	}
-	if readErr = original.Body.Close(); readErr != nil {
-		d.log.Warn("cannot close original request body", zap.Error(readErr))
+
+	var needsJSON, needsObject bool
+
+	for _, u := range route.Upstreams {
+		transform := u.Policy().RequestTransform
+		if !transform.enabled() || transform.Template != nil {
+			continue
+		}
+
+		needsJSON = true
+		needsObject = needsObject || len(transform.Set) > 0
 	}
 
-	if len(originalBody) > maxBodySize {
-		d.metrics.IncFailedRequestsTotal(metric.FailReasonBodyTooLarge)
+	if !needsJSON {
 		return nil
 	}
 
+	var v any
+	if err := json.Unmarshal(body, &v); err != nil {
+		return fmt.Errorf("%w: %w", errBodyInvalidJSON, err)
+	}
+
+	if _, ok := v.(map[string]any); needsObject && !ok {
+		return fmt.Errorf("%w: not an object", errBodyInvalidJSON)
+	}
+
+	return nil
+}
+
+// dispatchAll launches concurrent requests to all upstreams using a semaphore to control parallelism.
+//
+// Upstreams whose conditions do not hold for the request are skipped and are not treated as failures.
+// Upstreams that depend on other upstreams wait for their dependencies to complete and can
+// reference their responses in templates. If a dependency fails, the dependent upstream is
+// skipped and reported with the UpstreamDependencyFailed error kind. The dispatcher waits
+// for all upstream requests to complete before returning.
+func (d *defaultDispatcher) dispatchAll(route *Route, original *http.Request, originalBody []byte) []UpstreamResponse {
+	results := make([]UpstreamResponse, len(route.Upstreams))
+
 	var (
-		wg  = sync.WaitGroup{}
-		sem = semaphore.NewWeighted(route.MaxParallelUpstreams)
+		wg      = sync.WaitGroup{}
+		sem     = semaphore.NewWeighted(route.MaxParallelUpstreams)
+		done    = make([]chan struct{}, len(route.Upstreams))
+		indices = make(map[string]int, len(route.Upstreams))
 	)
 
+	for i, u := range route.Upstreams {
+		done[i] = make(chan struct{})
+		indices[u.Name()] = i
+	}
+
 	for i, u := range route.Upstreams {
 		wg.Add(1)
 
 		go func(i int, u Upstream, originalBody []byte) {
 			defer wg.Done()
+			defer close(done[i])
 
 			ctx := original.Context()
 
+			if !conditionsMatch(u.Policy().When, original) {
+				d.log.Debug("upstream skipped by conditions", zap.String("name", u.Name()))
+
+				results[i] = UpstreamResponse{Skipped: true}
+
+				return
+			}
+
+			scope, skipped, depErr := d.awaitDependencies(ctx, u.Policy().DependsOn, indices, done, results)
+			if skipped {
+				d.log.Debug("upstream skipped because its dependency was skipped", zap.String("name", u.Name()))
+
+				results[i] = UpstreamResponse{Skipped: true}
+
+				return
+			}
+
+			if depErr != nil {
+				d.log.Warn("upstream skipped", zap.String("name", u.Name()), zap.Error(depErr.Err))
+
+				results[i] = UpstreamResponse{Err: depErr}
+
+				return
+			}
+
+			if scope != nil {
+				ctx = withTemplateScope(ctx, scope)
+			}
+
 			if err := sem.Acquire(ctx, 1); err != nil {
 				d.log.Error("cannot acquire semaphore", zap.Error(err))
 
@@ -75,52 +224,292 @@ func (d *defaultDispatcher) dispatch(route *Route, original *http.Request) []Ups
 			}
 			defer sem.Release(1)
 
-			upstreamPolicy := u.Policy()
+			results[i] = *d.callUpstream(ctx, u, original, originalBody)
+		}(i, u, originalBody)
+	}
+
+	wg.Wait()
+
+	return results
+}
+
+// dispatchFirstSuccess calls upstreams in the configured order and stops at the first successful response.
+// Upstreams which were not called are marked as skipped.
+func (d *defaultDispatcher) dispatchFirstSuccess(route *Route, original *http.Request, originalBody []byte) []UpstreamResponse {
+	results := make([]UpstreamResponse, len(route.Upstreams))
+
+	succeeded := false
 
-			resp := u.Call(ctx, original, originalBody, upstreamPolicy.RetryPolicy)
-			if resp.Err != nil {
-				d.metrics.IncFailedRequestsTotal(metric.FailReasonUpstreamError)
-				d.log.Error("upstream request failed",
-					zap.String("name", u.Name()),
-					zap.Error(resp.Err.Unwrap()),
-				)
+	for i, u := range route.Upstreams {
+		if succeeded || !conditionsMatch(u.Policy().When, original) {
+			results[i] = UpstreamResponse{Skipped: true}
+			continue
+		}
+
+		results[i] = *d.callUpstream(original.Context(), u, original, originalBody)
+		if results[i].Err == nil {
+			succeeded = true
+			continue
+		}
+
+		d.log.Debug("falling back to the next upstream", zap.String("name", u.Name()))
+	}
+
+	return results
+}
+
+// dispatchRace calls all upstreams concurrently and returns as soon as the first successful response arrives.
+// The pending requests are canceled and their upstreams are marked as skipped, upstreams which have already
+// failed keep their failures. If no upstream succeeds, all failures are returned.
+func (d *defaultDispatcher) dispatchRace(route *Route, original *http.Request, originalBody []byte) []UpstreamResponse {
+	type raceResult struct {
+		idx  int
+		resp *UpstreamResponse
+	}
+
+	var (
+		results = make([]UpstreamResponse, len(route.Upstreams))
+		sem     = semaphore.NewWeighted(route.MaxParallelUpstreams)
+		ch      = make(chan raceResult, len(route.Upstreams)) // Buffered, so losers never block after return.
+		pending = 0
+		settled = make([]bool, len(route.Upstreams)) // Upstreams which have responded or are skipped by conditions.
+	)
+
+	ctx, cancel := context.WithCancel(original.Context())
+	defer cancel()
+
+	for i, u := range route.Upstreams {
+		if !conditionsMatch(u.Policy().When, original) {
+			results[i] = UpstreamResponse{Skipped: true}
+			settled[i] = true
+
+			continue
+		}
+
+		pending++
+
+		go func(i int, u Upstream) {
+			if err := sem.Acquire(ctx, 1); err != nil {
+				ch <- raceResult{idx: i, resp: &UpstreamResponse{
+					Err: &UpstreamError{
+						Kind: UpstreamCanceled,
+						Err:  fmt.Errorf("semaphore acquire failed: %w", err),
+					},
+				}}
+
+				return
 			}
+			defer sem.Release(1)
+
+			ch <- raceResult{idx: i, resp: d.callUpstream(ctx, u, original, originalBody)}
+		}(i, u)
+	}
+
+	for ; pending > 0; pending-- {
+		res := <-ch
 
-			if resp.Status != 0 {
-				d.metrics.IncResponsesTotal(resp.Status)
+		settled[res.idx] = true
+
+		if res.resp.Err != nil {
+			results[res.idx] = *res.resp
+			continue
+		}
+
+		// Winner found: cancel the pending upstreams and report them as skipped. Failures are kept.
+		cancel()
+
+		for i := range results {
+			if !settled[i] {
+				results[i] = UpstreamResponse{Skipped: true}
 			}
+		}
+
+		results[res.idx] = *res.resp
+
+		return results
+	}
 
-			var errs []error
+	return results
+}
+
+// awaitDependencies blocks until all dependencies of an upstream are completed and builds a template scope
+// from their responses. It reports whether any dependency was skipped by its conditions and returns an error
+// if any dependency has failed or the request context is done.
+func (d *defaultDispatcher) awaitDependencies(
+	ctx context.Context,
+	dependsOn []string,
+	indices map[string]int,
+	done []chan struct{},
+	results []UpstreamResponse,
+) (*templateScope, bool, *UpstreamError) {
+	if len(dependsOn) == 0 {
+		return nil, false, nil
+	}
 
-			if upstreamPolicy.RequireBody && len(resp.Body) == 0 {
-				errs = append(errs, errors.New("empty body not allowed by upstream policy"))
+	scope := &templateScope{
+		upstreams: make(map[string]any, len(dependsOn)),
+	}
+
+	for _, name := range dependsOn {
+		idx, ok := indices[name]
+		if !ok {
+			return nil, false, &UpstreamError{
+				Kind: UpstreamInternal,
+				Err:  fmt.Errorf("unknown dependency %q", name),
 			}
+		}
 
-			if mapped, ok := upstreamPolicy.MapStatusCodes[resp.Status]; ok {
-				resp.Status = mapped
+		select {
+		case <-done[idx]:
+		case <-ctx.Done():
+			return nil, false, &UpstreamError{
+				Kind: UpstreamCanceled,
+				Err:  ctx.Err(),
 			}
+		}
+
+		if results[idx].Skipped {
+			return nil, true, nil
+		}
 
-			if len(upstreamPolicy.AllowedStatuses) > 0 && !slices.Contains(upstreamPolicy.AllowedStatuses, resp.Status) {
-				errs = append(errs, fmt.Errorf("status %d not allowed by upstream policy", resp.Status))
+		if results[idx].Err != nil {
+			return nil, false, &UpstreamError{
+				Kind: UpstreamDependencyFailed,
+				Err:  fmt.Errorf("dependency %q failed: %w", name, results[idx].Err),
 			}
+		}
 
-			if len(errs) > 0 {
-				d.metrics.IncFailedRequestsTotal(metric.FailReasonPolicyViolation)
+		var body any
+		if len(results[idx].Body) > 0 {
+			if err := json.Unmarshal(results[idx].Body, &body); err != nil {
+				d.log.Debug("dependency response is not a JSON", zap.String("name", name), zap.Error(err))
+			}
+		}
 
-				if resp.Err == nil {
-					resp.Err = &UpstreamError{
-						Err: errors.Join(errs...),
-					}
-				} else {
-					resp.Err.Err = errors.Join(resp.Err.Err, errors.Join(errs...))
-				}
+		scope.upstreams[name] = body
+	}
+
+	return scope, false, nil
+}
+
+// callUpstream shapes the request body by the request transform, calls the upstream, applies the upstream policy
+// to its response and serves the fallback if the upstream fails.
+func (d *defaultDispatcher) callUpstream(ctx context.Context, u Upstream, original *http.Request, originalBody []byte) *UpstreamResponse {
+	upstreamPolicy := u.Policy()
+
+	if upstreamPolicy.RequestTransform.enabled() {
+		shaped, err := upstreamPolicy.RequestTransform.apply(originalBody, templateScopeFrom(ctx).withRequest(original, originalBody))
+		if err != nil {
+			d.log.Error("cannot transform upstream request",
+				zap.String("name", u.Name()),
+				zap.Error(err),
+			)
+
+			return &UpstreamResponse{
+				Err: &UpstreamError{
+					Kind: UpstreamInternal,
+					Err:  fmt.Errorf("request transform failed: %w", err),
+				},
 			}
+		}
 
-			results[i] = *resp
-		}(i, u, originalBody)
+		originalBody = shaped
 	}
 
-	wg.Wait()
+	resp := d.checkResponse(ctx, u, d.cachedCall(ctx, u, original, originalBody))
 
-	return results
+	fallback := upstreamPolicy.Fallback
+	if !fallback.Enabled {
+		return resp
+	}
+
+	if resp.Err == nil {
+		if fallback.stale != nil {
+			fallback.stale.store(resp)
+		}
+
+		return resp
+	}
+
+	if resp.Err.Kind == UpstreamCanceled {
+		return resp
+	}
+
+	return d.fallback(ctx, u, original, originalBody, resp)
+}
+
+// checkResponse applies the upstream policy to the upstream response and shapes its body.
+func (d *defaultDispatcher) checkResponse(ctx context.Context, u Upstream, resp *UpstreamResponse) *UpstreamResponse {
+	upstreamPolicy := u.Policy()
+
+	// Requests canceled by the gateway, like race losers, are not upstream failures.
+	if resp.Err != nil && ctx.Err() == nil {
+		d.metrics.IncFailedRequestsTotal(metric.FailReasonUpstreamError)
+		d.log.Error("upstream request failed",
+			zap.String("name", u.Name()),
+			zap.Error(resp.Err.Unwrap()),
+		)
+	}
+
+	if resp.Status != 0 {
+		d.metrics.IncResponsesTotal(resp.Status)
+	}
+
+	var errs []error
+
+	if upstreamPolicy.RequireBody && len(resp.Body) == 0 {
+		errs = append(errs, errors.New("empty body not allowed by upstream policy"))
+	}
+
+	if mapped, ok := upstreamPolicy.MapStatusCodes[resp.Status]; ok {
+		resp.Status = mapped
+	}
+
+	if len(upstreamPolicy.AllowedStatuses) > 0 && !slices.Contains(upstreamPolicy.AllowedStatuses, resp.Status) {
+		errs = append(errs, fmt.Errorf("status %d not allowed by upstream policy", resp.Status))
+	}
+
+	if len(errs) > 0 {
+		d.metrics.IncFailedRequestsTotal(metric.FailReasonPolicyViolation)
+
+		if resp.Err == nil {
+			resp.Err = &UpstreamError{
+				Kind:       UpstreamPolicyViolation,
+				StatusCode: resp.Status,
+				Err:        errors.Join(errs...),
+			}
+		} else {
+			resp.Err.Err = errors.Join(resp.Err.Err, errors.Join(errs...))
+		}
+	}
+
+	if resp.Err == nil && upstreamPolicy.ResponseTransform.enabled() {
+		// Transforms work on JSON, so XML and form bodies are decoded first.
+		shaped, err := decodeDocument(resp.Headers, resp.Body)
+		if err == nil {
+			shaped, err = upstreamPolicy.ResponseTransform.apply(shaped)
+		}
+
+		if err != nil {
+			d.log.Error("cannot transform upstream response",
+				zap.String("name", u.Name()),
+				zap.Error(err),
+			)
+
+			resp.Err = &UpstreamError{
+				Kind: UpstreamMalformed,
+				Err:  fmt.Errorf("response transform failed: %w", err),
+			}
+		} else {
+			resp.Body = shaped
+
+			if resp.Headers == nil {
+				resp.Headers = make(http.Header)
+			}
+
+			resp.Headers.Set("Content-Type", "application/json")
+		}
+	}
+
+	return resp
 }
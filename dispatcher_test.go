@@ -8,7 +8,9 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"runtime"
+	"sync/atomic"
 	"testing"
+	"testing/iotest"
 	"time"
 
 	"github.com/starwalkn/tokka/internal/metric"
@@ -48,7 +50,7 @@ func TestDispatcher_Dispatch_Success(t *testing.T) {
 
 	originalRequest := httptest.NewRequest(http.MethodGet, "http://example.com/test", nil)
 
-	results := d.dispatch(route, originalRequest)
+	results, _ := d.dispatch(route, originalRequest)
 	if len(results) != 2 {
 		t.Fatalf("expected 2 results, got %d", len(results))
 	}
@@ -90,7 +92,7 @@ func TestDispatcher_Dispatch_ForwardQueryAndHeaders(t *testing.T) {
 	originalRequest := httptest.NewRequest(http.MethodGet, "http://example.com/test?foo=bar", nil)
 	originalRequest.Header.Set("X-Test", "baz")
 
-	results := d.dispatch(route, originalRequest)
+	results, _ := d.dispatch(route, originalRequest)
 
 	if string(results[0].Body) != "bar-baz" {
 		t.Errorf("unexpected result: %q", results[0].Body)
@@ -123,13 +125,66 @@ func TestDispatcher_Dispatch_PostWithBody(t *testing.T) {
 
 	originalRequest := httptest.NewRequest(http.MethodPost, "http://example.com/test", bytes.NewBufferString("hello"))
 
-	results := d.dispatch(route, originalRequest)
+	results, _ := d.dispatch(route, originalRequest)
 
 	if string(results[0].Body) != "hello" {
 		t.Errorf("expected 'hello', got %q", results[0].Body)
 	}
 }
 
+func TestDispatcher_Dispatch_MaxBodySize(t *testing.T) {
+	var calls atomic.Int32
+
+	upstream := httptest.NewServer(http.HandlerFunc(func(_ http.ResponseWriter, _ *http.Request) {
+		calls.Add(1)
+	}))
+	defer upstream.Close()
+
+	d := &defaultDispatcher{
+		log:     zap.NewNop(),
+		metrics: metric.NewNop(),
+	}
+
+	route := &Route{
+		Upstreams: []Upstream{
+			&httpUpstream{
+				url:     upstream.URL,
+				method:  http.MethodPost,
+				timeout: 500 * time.Millisecond,
+				client:  http.DefaultClient,
+			},
+		},
+		MaxParallelUpstreams: maxParallelUpstreams,
+		MaxBodySize:          4,
+	}
+
+	tests := []struct {
+		name          string
+		body          io.Reader
+		contentLength int64
+		expectedErr   error
+	}{
+		{name: "within limit", body: bytes.NewBufferString("abcd"), contentLength: 4},
+		{name: "content length", body: bytes.NewBufferString("abcde"), contentLength: 5, expectedErr: errBodyTooLarge},
+		{name: "chunked", body: bytes.NewBufferString("abcde"), contentLength: -1, expectedErr: errBodyTooLarge},
+		{name: "read error", body: iotest.ErrReader(io.ErrUnexpectedEOF), contentLength: -1, expectedErr: errBodyRead},
+	}
+
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodPost, "http://example.com/test", tt.body)
+		req.ContentLength = tt.contentLength
+
+		_, err := d.dispatch(route, req)
+		if !errors.Is(err, tt.expectedErr) {
+			t.Errorf("%s: expected error %v, got %v", tt.name, tt.expectedErr, err)
+		}
+	}
+
+	if calls.Load() != 1 {
+		t.Errorf("expected 1 upstream call, got %d", calls.Load())
+	}
+}
+
 func TestDispatcher_Dispatch_UpstreamTimeout(t *testing.T) {
 	upstreamA := httptest.NewServer(http.HandlerFunc(func(_ http.ResponseWriter, _ *http.Request) {
 		time.Sleep(600 * time.Millisecond)
@@ -155,7 +210,7 @@ func TestDispatcher_Dispatch_UpstreamTimeout(t *testing.T) {
 
 	originalRequest := httptest.NewRequest(http.MethodGet, "http://example.com/test", nil)
 
-	results := d.dispatch(route, originalRequest)
+	results, _ := d.dispatch(route, originalRequest)
 
 	if len(results) != 1 {
 		t.Errorf("expected 1 result, got %d", len(results))
@@ -196,7 +251,7 @@ func TestDispatcher_Dispatch_MapStatusCodesPolicy(t *testing.T) {
 
 	originalRequest := httptest.NewRequest(http.MethodGet, "http://example.com/test", nil)
 
-	results := d.dispatch(route, originalRequest)
+	results, _ := d.dispatch(route, originalRequest)
 
 	if len(results) != 1 {
 		t.Errorf("expected 1 result, got %d", len(results))
@@ -245,7 +300,7 @@ func TestDispatcher_Dispatch_MaxResponseBodySizePolicy(t *testing.T) {
 
 	originalRequest := httptest.NewRequest(http.MethodGet, "http://example.com/test", nil)
 
-	results := d.dispatch(route, originalRequest)
+	results, _ := d.dispatch(route, originalRequest)
 
 	if len(results) != 1 {
 		t.Errorf("expected 1 result, got %d", len(results))
@@ -305,7 +360,7 @@ func TestDispatcher_Dispatch_RequireBodyPolicy(t *testing.T) {
 
 	originalRequest := httptest.NewRequest(http.MethodGet, "http://example.com/test", nil)
 
-	results := d.dispatch(route, originalRequest)
+	results, _ := d.dispatch(route, originalRequest)
 
 	if len(results) != 2 {
 		t.Errorf("expected 2 results, got %d", len(results))
@@ -361,7 +416,7 @@ func TestDispatcher_Dispatch_RetryPolicy(t *testing.T) {
 
 	originalRequest := httptest.NewRequest(http.MethodGet, "http://example.com/test", nil)
 
-	results := d.dispatch(route, originalRequest)
+	results, _ := d.dispatch(route, originalRequest)
 
 	if len(results) != 1 {
 		t.Errorf("expected 1 result, got %d", len(results))
@@ -373,3 +428,496 @@ func TestDispatcher_Dispatch_RetryPolicy(t *testing.T) {
 		t.Errorf("retries count %d exceeds max retries %d", retriesCount, route.Upstreams[0].Policy().RetryPolicy.MaxRetries)
 	}
 }
+
+func TestDispatcher_Dispatch_ResponseTransform(t *testing.T) {
+	upstreamA := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Write([]byte(`{"data":{"user":{"id":1,"password":"secret"}}}`))
+	}))
+	defer upstreamA.Close()
+
+	upstreamB := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Write([]byte(`not json`))
+	}))
+	defer upstreamB.Close()
+
+	d := &defaultDispatcher{
+		log:     zap.NewNop(),
+		metrics: metric.NewNop(),
+	}
+
+	transform := UpstreamResponseTransform{
+		Extract: "data",
+		Deny:    []string{"user.password"},
+	}
+
+	route := &Route{
+		Upstreams: []Upstream{
+			&httpUpstream{
+				url:     upstreamA.URL,
+				timeout: 500 * time.Millisecond,
+				client:  http.DefaultClient,
+				policy:  UpstreamPolicy{ResponseTransform: transform},
+			},
+			&httpUpstream{
+				url:     upstreamB.URL,
+				timeout: 500 * time.Millisecond,
+				client:  http.DefaultClient,
+				policy:  UpstreamPolicy{ResponseTransform: transform},
+			},
+		},
+		MaxParallelUpstreams: maxParallelUpstreams,
+	}
+
+	originalRequest := httptest.NewRequest(http.MethodGet, "http://example.com/test", nil)
+
+	results, _ := d.dispatch(route, originalRequest)
+
+	if string(results[0].Body) != `{"user":{"id":1}}` {
+		t.Errorf("unexpected transformed body: %s", results[0].Body)
+	}
+
+	if results[1].Err == nil || results[1].Err.Kind != UpstreamMalformed {
+		t.Errorf("expected malformed error, got %v", results[1].Err)
+	}
+}
+
+func TestDispatcher_Dispatch_RequestTransform(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		w.Write(body)
+	}))
+	defer upstream.Close()
+
+	d := &defaultDispatcher{
+		log:     zap.NewNop(),
+		metrics: metric.NewNop(),
+	}
+
+	newUpstream := func(transform UpstreamRequestTransform) Upstream {
+		return &httpUpstream{
+			url:     upstream.URL,
+			method:  http.MethodPost,
+			timeout: 500 * time.Millisecond,
+			client:  http.DefaultClient,
+			policy:  UpstreamPolicy{RequestTransform: transform},
+		}
+	}
+
+	route := &Route{
+		Upstreams: []Upstream{
+			newUpstream(UpstreamRequestTransform{
+				Rename: map[string]string{"name": "full_name"},
+				Set:    map[string]any{"tenant": "${header.X-Tenant}"},
+			}),
+			newUpstream(UpstreamRequestTransform{Template: map[string]any{"user": map[string]any{"name": "${body.name}"}}}),
+			newUpstream(UpstreamRequestTransform{Remove: []string{"name"}}),
+		},
+		MaxParallelUpstreams: maxParallelUpstreams,
+	}
+
+	originalRequest := httptest.NewRequest(http.MethodPost, "http://example.com/test", bytes.NewReader([]byte(`{"name":"Ann"}`)))
+	originalRequest.Header.Set("X-Tenant", "acme")
+
+	results, err := d.dispatch(route, originalRequest)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expected := []string{`{"full_name":"Ann","tenant":"acme"}`, `{"user":{"name":"Ann"}}`, `{}`}
+
+	for i, want := range expected {
+		if results[i].Err != nil || string(results[i].Body) != want {
+			t.Errorf("upstream %d: expected body %s, got %s (%v)", i, want, results[i].Body, results[i].Err)
+		}
+	}
+}
+
+func TestDispatcher_Dispatch_RequestTransformInvalidBody(t *testing.T) {
+	d := &defaultDispatcher{
+		log:     zap.NewNop(),
+		metrics: metric.NewNop(),
+	}
+
+	newRoute := func(transform UpstreamRequestTransform) *Route {
+		return &Route{
+			Upstreams: []Upstream{&httpUpstream{
+				url:    "http://127.0.0.1:1",
+				client: http.DefaultClient,
+				policy: UpstreamPolicy{RequestTransform: transform},
+			}},
+			MaxParallelUpstreams: maxParallelUpstreams,
+		}
+	}
+
+	tests := []struct {
+		name      string
+		transform UpstreamRequestTransform
+		body      string
+	}{
+		{name: "invalid JSON", transform: UpstreamRequestTransform{Remove: []string{"password"}}, body: `{"name":`},
+		{name: "set on array", transform: UpstreamRequestTransform{Set: map[string]any{"id": "1"}}, body: `[1]`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "http://example.com/test", bytes.NewReader([]byte(tt.body)))
+
+			if _, err := d.dispatch(newRoute(tt.transform), req); !errors.Is(err, errBodyInvalidJSON) {
+				t.Errorf("expected errBodyInvalidJSON, got %v", err)
+			}
+		})
+	}
+}
+
+func TestDispatcher_Dispatch_DependentUpstreams(t *testing.T) {
+	orders := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Write([]byte(`{"order":{"id":7,"customer_id":"c 42"}}`))
+	}))
+	defer orders.Close()
+
+	customers := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"customer":"` + r.URL.Path + `"}`))
+	}))
+	defer customers.Close()
+
+	d := &defaultDispatcher{
+		log:     zap.NewNop(),
+		metrics: metric.NewNop(),
+	}
+
+	route := &Route{
+		Upstreams: []Upstream{
+			&httpUpstream{
+				name:    "customer",
+				url:     customers.URL + "/customers/${upstreams.order.order.customer_id}",
+				timeout: 500 * time.Millisecond,
+				client:  http.DefaultClient,
+				policy:  UpstreamPolicy{DependsOn: []string{"order"}},
+			},
+			&httpUpstream{
+				name:    "order",
+				url:     orders.URL,
+				timeout: 500 * time.Millisecond,
+				client:  http.DefaultClient,
+			},
+		},
+		MaxParallelUpstreams: 1,
+	}
+
+	originalRequest := httptest.NewRequest(http.MethodGet, "http://example.com/test", nil)
+
+	results, _ := d.dispatch(route, originalRequest)
+
+	if results[0].Err != nil {
+		t.Fatalf("expected no error, got %v", results[0].Err)
+	}
+
+	if string(results[0].Body) != `{"customer":"/customers/c 42"}` {
+		t.Errorf("unexpected dependent response: %s", results[0].Body)
+	}
+}
+
+func TestDispatcher_Dispatch_FailedDependencySkipsDependents(t *testing.T) {
+	var dependentCalled bool
+
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer failing.Close()
+
+	dependent := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		dependentCalled = true
+		w.Write([]byte(`{}`))
+	}))
+	defer dependent.Close()
+
+	d := &defaultDispatcher{
+		log:     zap.NewNop(),
+		metrics: metric.NewNop(),
+	}
+
+	route := &Route{
+		Upstreams: []Upstream{
+			&httpUpstream{name: "a", url: failing.URL, timeout: 500 * time.Millisecond, client: http.DefaultClient},
+			&httpUpstream{
+				name:    "b",
+				url:     dependent.URL,
+				timeout: 500 * time.Millisecond,
+				client:  http.DefaultClient,
+				policy:  UpstreamPolicy{DependsOn: []string{"a"}},
+			},
+		},
+		MaxParallelUpstreams: maxParallelUpstreams,
+	}
+
+	originalRequest := httptest.NewRequest(http.MethodGet, "http://example.com/test", nil)
+
+	results, _ := d.dispatch(route, originalRequest)
+
+	if dependentCalled {
+		t.Errorf("dependent upstream must not be called")
+	}
+
+	if results[1].Err == nil || results[1].Err.Kind != UpstreamDependencyFailed {
+		t.Errorf("expected dependency failed error, got %v", results[1].Err)
+	}
+}
+
+func TestDispatcher_Dispatch_ConditionalUpstream(t *testing.T) {
+	var recsCalled bool
+
+	products := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Write([]byte(`{"product":1}`))
+	}))
+	defer products.Close()
+
+	recs := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		recsCalled = true
+		w.Write([]byte(`{"recs":[]}`))
+	}))
+	defer recs.Close()
+
+	d := &defaultDispatcher{
+		log:     zap.NewNop(),
+		metrics: metric.NewNop(),
+	}
+
+	route := &Route{
+		Upstreams: []Upstream{
+			&httpUpstream{url: products.URL, timeout: 500 * time.Millisecond, client: http.DefaultClient},
+			&httpUpstream{
+				url:     recs.URL,
+				timeout: 500 * time.Millisecond,
+				client:  http.DefaultClient,
+				policy: UpstreamPolicy{
+					When: []UpstreamCondition{{Source: conditionSourceQuery, Name: "include", Equals: "recs"}},
+				},
+			},
+		},
+		MaxParallelUpstreams: maxParallelUpstreams,
+	}
+
+	results, _ := d.dispatch(route, httptest.NewRequest(http.MethodGet, "http://example.com/test?include=reviews", nil))
+
+	if recsCalled {
+		t.Errorf("conditional upstream must not be called")
+	}
+
+	if !results[1].Skipped || results[1].Err != nil {
+		t.Errorf("expected skipped upstream without error, got %+v", results[1])
+	}
+
+	results, _ = d.dispatch(route, httptest.NewRequest(http.MethodGet, "http://example.com/test?include=reviews,recs", nil))
+
+	if !recsCalled || results[1].Skipped {
+		t.Errorf("conditional upstream must be called")
+	}
+}
+
+func TestDispatcher_Dispatch_FirstSuccess(t *testing.T) {
+	var thirdCalled bool
+
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer failing.Close()
+
+	healthy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Write([]byte(`{"from":"healthy"}`))
+	}))
+	defer healthy.Close()
+
+	third := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		thirdCalled = true
+	}))
+	defer third.Close()
+
+	d := &defaultDispatcher{
+		log:     zap.NewNop(),
+		metrics: metric.NewNop(),
+	}
+
+	route := &Route{
+		Upstreams: []Upstream{
+			&httpUpstream{url: failing.URL, timeout: 500 * time.Millisecond, client: http.DefaultClient},
+			&httpUpstream{url: healthy.URL, timeout: 500 * time.Millisecond, client: http.DefaultClient},
+			&httpUpstream{url: third.URL, timeout: 500 * time.Millisecond, client: http.DefaultClient},
+		},
+		Aggregation:          AggregationConfig{Strategy: strategyFirstSuccess},
+		MaxParallelUpstreams: maxParallelUpstreams,
+	}
+
+	results, _ := d.dispatch(route, httptest.NewRequest(http.MethodGet, "http://example.com/test", nil))
+
+	if results[0].Err == nil {
+		t.Errorf("expected first upstream to fail")
+	}
+
+	if string(results[1].Body) != `{"from":"healthy"}` {
+		t.Errorf("unexpected fallback response: %s", results[1].Body)
+	}
+
+	if thirdCalled || !results[2].Skipped {
+		t.Errorf("upstreams after the first success must not be called")
+	}
+}
+
+func TestDispatcher_Dispatch_Race(t *testing.T) {
+	slowCanceled := make(chan struct{})
+
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+			close(slowCanceled)
+		case <-time.After(2 * time.Second):
+			w.Write([]byte(`{"from":"slow"}`))
+		}
+	}))
+	defer slow.Close()
+
+	fast := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		time.Sleep(100 * time.Millisecond)
+		w.Write([]byte(`{"from":"fast"}`))
+	}))
+	defer fast.Close()
+
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer failing.Close()
+
+	d := &defaultDispatcher{
+		log:     zap.NewNop(),
+		metrics: metric.NewNop(),
+	}
+
+	route := &Route{
+		Upstreams: []Upstream{
+			&httpUpstream{url: slow.URL, timeout: 3 * time.Second, client: http.DefaultClient},
+			&httpUpstream{url: fast.URL, timeout: 3 * time.Second, client: http.DefaultClient},
+			&httpUpstream{url: failing.URL, timeout: 3 * time.Second, client: http.DefaultClient},
+		},
+		Aggregation:          AggregationConfig{Strategy: strategyRace},
+		MaxParallelUpstreams: maxParallelUpstreams,
+	}
+
+	start := time.Now()
+
+	results, _ := d.dispatch(route, httptest.NewRequest(http.MethodGet, "http://example.com/test", nil))
+
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("race must not wait for the slow upstream, took %s", elapsed)
+	}
+
+	if !results[0].Skipped || string(results[1].Body) != `{"from":"fast"}` {
+		t.Errorf("unexpected race results: %+v", results)
+	}
+
+	// Failures which happened before the winner responded are kept.
+	if results[2].Skipped || results[2].Err == nil || results[2].Err.Kind != UpstreamBadStatus {
+		t.Errorf("expected failure of the failing upstream, got %+v", results[2])
+	}
+
+	select {
+	case <-slowCanceled:
+	case <-time.After(time.Second):
+		t.Errorf("slow upstream request was not canceled")
+	}
+}
+
+func TestDispatcher_Dispatch_Fallback(t *testing.T) {
+	var failing, alternateFailing atomic.Bool
+
+	primary := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		if failing.Load() {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		_, _ = w.Write([]byte(`{"source":"primary"}`))
+	}))
+	defer primary.Close()
+
+	alternate := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		if alternateFailing.Load() {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		_, _ = w.Write([]byte(`{"source":"alternate"}`))
+	}))
+	defer alternate.Close()
+
+	d := &defaultDispatcher{
+		log:     zap.NewNop(),
+		metrics: metric.NewNop(),
+	}
+
+	upstream := &httpUpstream{
+		name:    "users",
+		url:     primary.URL,
+		method:  http.MethodGet,
+		timeout: 500 * time.Millisecond,
+		client:  http.DefaultClient,
+	}
+
+	fallback, err := initFallback(UpstreamFallbackConfig{
+		Enabled: true,
+		URL:     alternate.URL,
+		Stale:   true,
+		Body:    map[string]any{"source": "static"},
+		Status:  http.StatusOK,
+	}, upstream)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	upstream.policy.Fallback = fallback
+
+	route := &Route{
+		Upstreams:            []Upstream{upstream},
+		MaxParallelUpstreams: maxParallelUpstreams,
+	}
+
+	tests := []struct {
+		name             string
+		failing          bool
+		alternateFailing bool
+		staleTTL         time.Duration
+		expectedBody     string
+		expectedFallback bool
+	}{
+		{name: "primary", expectedBody: `{"source":"primary"}`},
+		{name: "alternate", failing: true, expectedBody: `{"source":"alternate"}`, expectedFallback: true},
+		{name: "stale", failing: true, alternateFailing: true, expectedBody: `{"source":"primary"}`, expectedFallback: true},
+		{
+			name:             "static",
+			failing:          true,
+			alternateFailing: true,
+			staleTTL:         time.Nanosecond,
+			expectedBody:     `{"source":"static"}`,
+			expectedFallback: true,
+		},
+	}
+
+	for _, tt := range tests {
+		failing.Store(tt.failing)
+		alternateFailing.Store(tt.alternateFailing)
+		upstream.policy.Fallback.StaleTTL = tt.staleTTL
+
+		results, _ := d.dispatch(route, httptest.NewRequest(http.MethodGet, "http://example.com/test", nil))
+
+		if results[0].Err != nil {
+			t.Errorf("%s: unexpected error: %v", tt.name, results[0].Err)
+			continue
+		}
+
+		if string(results[0].Body) != tt.expectedBody {
+			t.Errorf("%s: expected body %s, got %s", tt.name, tt.expectedBody, results[0].Body)
+		}
+
+		if results[0].Fallback != tt.expectedFallback {
+			t.Errorf("%s: expected fallback %v, got %v", tt.name, tt.expectedFallback, results[0].Fallback)
+		}
+	}
+}
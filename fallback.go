@@ -0,0 +1,121 @@
+package tokka
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const (
+	fallbackSourceUpstream = "upstream"
+	fallbackSourceStale    = "stale"
+	fallbackSourceStatic   = "static"
+
+	// FallbackHeader marks gateway responses which contain fallback data.
+	FallbackHeader = "X-Tokka-Fallback"
+)
+
+// UpstreamFallbackPolicy describes the response served instead of an upstream error.
+// Fallbacks are tried in order: the alternate upstream, the stale response, the static body.
+type UpstreamFallbackPolicy struct {
+	Enabled  bool
+	URL      string        // Alternate endpoint which receives the same request.
+	Stale    bool          // Serve the last successful response.
+	StaleTTL time.Duration // Maximum age of the stale response. Zero means unlimited.
+	Body     []byte        // Static JSON body.
+	Status   int           // Status of the static response.
+
+	alternate Upstream
+	stale     *staleResponse
+}
+
+// staleResponse holds the last successful response of an upstream.
+type staleResponse struct {
+	mu       sync.RWMutex
+	resp     *UpstreamResponse
+	storedAt time.Time
+}
+
+func (s *staleResponse) store(resp *UpstreamResponse) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.resp = &UpstreamResponse{
+		Status:  resp.Status,
+		Headers: resp.Headers.Clone(),
+		Body:    resp.Body,
+	}
+	s.storedAt = time.Now()
+}
+
+// load returns a copy of the stored response if it is not older than ttl.
+func (s *staleResponse) load(ttl time.Duration) (*UpstreamResponse, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if s.resp == nil || (ttl > 0 && time.Since(s.storedAt) > ttl) {
+		return nil, false
+	}
+
+	return &UpstreamResponse{
+		Status:  s.resp.Status,
+		Headers: s.resp.Headers.Clone(),
+		Body:    s.resp.Body,
+	}, true
+}
+
+// fallback returns the fallback response for the failed upstream response.
+// If no fallback is available, the failed response is returned.
+func (d *defaultDispatcher) fallback(
+	ctx context.Context,
+	u Upstream,
+	original *http.Request,
+	originalBody []byte,
+	failed *UpstreamResponse,
+) *UpstreamResponse {
+	policy := u.Policy().Fallback
+
+	var (
+		resp   *UpstreamResponse
+		source string
+	)
+
+	if policy.alternate != nil && ctx.Err() == nil {
+		alt := d.checkResponse(ctx, policy.alternate, policy.alternate.Call(ctx, original, originalBody, UpstreamRetryPolicy{}))
+		if alt.Err == nil {
+			resp, source = alt, fallbackSourceUpstream
+		}
+	}
+
+	if resp == nil && policy.stale != nil {
+		if stale, ok := policy.stale.load(policy.StaleTTL); ok {
+			resp, source = stale, fallbackSourceStale
+		}
+	}
+
+	if resp == nil && policy.Body != nil {
+		resp = &UpstreamResponse{
+			Status:  policy.Status,
+			Headers: http.Header{"Content-Type": []string{"application/json"}},
+			Body:    policy.Body,
+		}
+		source = fallbackSourceStatic
+	}
+
+	if resp == nil {
+		return failed
+	}
+
+	d.log.Warn("serving upstream fallback",
+		zap.String("name", u.Name()),
+		zap.String("source", source),
+		zap.Error(failed.Err.Unwrap()),
+	)
+
+	resp.Fallback = true
+
+	return resp
+}
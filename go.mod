@@ -9,6 +9,8 @@ require (
 	github.com/lestrrat-go/jwx v1.2.31
 	github.com/oklog/ulid/v2 v2.1.1
 	go.uber.org/zap v1.27.1
+	golang.org/x/sync v0.19.0
+	google.golang.org/protobuf v1.36.10
 	gopkg.in/yaml.v3 v3.0.1
 )
 
@@ -51,7 +53,5 @@ require (
 	go.uber.org/multierr v1.11.0 // indirect
 	go.yaml.in/yaml/v2 v2.4.3 // indirect
 	golang.org/x/crypto v0.46.0 // indirect
-	golang.org/x/sync v0.19.0 // indirect
-	google.golang.org/protobuf v1.36.10 // indirect
 	gopkg.in/check.v1 v1.0.0-20201130134442-10cb98267c6c // indirect
 )
@@ -0,0 +1,183 @@
+package tokka
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+)
+
+// graphqlVariablePattern matches variable definitions of GraphQL operations like "$id: ID!" or "$ids: [Int!]"
+// and captures the variable name and its named type.
+var graphqlVariablePattern = regexp.MustCompile(`\$(\w+)\s*:\s*\[*\s*(\w+)`)
+
+// graphqlUpstream sends the configured GraphQL query to an HTTP upstream. Variables are templated from
+// the original request and completed upstreams, the "data" of the response is aggregated and GraphQL
+// "errors" are reported as JSON errors.
+type graphqlUpstream struct {
+	*httpUpstream
+
+	query         string
+	operationName string
+	variables     map[string]any
+	variableTypes map[string]string // Named types of the declared variables, e.g. Int for "$limit: Int!".
+}
+
+type graphqlRequest struct {
+	Query         string         `json:"query"`
+	OperationName string         `json:"operationName,omitempty"`
+	Variables     map[string]any `json:"variables,omitempty"`
+}
+
+type graphqlResponse struct {
+	Data   json.RawMessage `json:"data"`
+	Errors []struct {
+		Message string `json:"message"`
+	} `json:"errors"`
+}
+
+func newGraphQLUpstream(upstream *httpUpstream, cfg UpstreamGraphQLConfig) *graphqlUpstream {
+	variableTypes := make(map[string]string)
+
+	for _, m := range graphqlVariablePattern.FindAllStringSubmatch(cfg.Query, -1) {
+		variableTypes[m[1]] = m[2]
+	}
+
+	return &graphqlUpstream{
+		httpUpstream:  upstream,
+		query:         cfg.Query,
+		operationName: cfg.OperationName,
+		variables:     cfg.Variables,
+		variableTypes: variableTypes,
+	}
+}
+
+// Call sends the query and unwraps the GraphQL response. Queries are POST requests, so they are retried
+// only if the retry policy allows non-idempotent retries.
+func (u *graphqlUpstream) Call(ctx context.Context, original *http.Request, originalBody []byte, retryPolicy UpstreamRetryPolicy) *UpstreamResponse {
+	variables, err := u.resolveVariables(templateScopeFrom(ctx).withRequest(original, originalBody))
+	if err != nil {
+		return &UpstreamResponse{
+			Err: &UpstreamError{
+				Kind: UpstreamInternal,
+				Err:  err,
+			},
+		}
+	}
+
+	body, err := json.Marshal(graphqlRequest{
+		Query:         u.query,
+		OperationName: u.operationName,
+		Variables:     variables,
+	})
+	if err != nil {
+		return &UpstreamResponse{
+			Err: &UpstreamError{
+				Kind: UpstreamInternal,
+				Err:  fmt.Errorf("cannot marshal graphql request: %w", err),
+			},
+		}
+	}
+
+	resp := u.httpUpstream.Call(ctx, original, body, retryPolicy)
+	if resp.Err == nil {
+		unwrapGraphQLResponse(resp)
+	}
+
+	return resp
+}
+
+// resolveVariables resolves the variable templates and coerces textual values, like path and query
+// parameters, to the declared Int, Float and Boolean types.
+func (u *graphqlUpstream) resolveVariables(scope *templateScope) (map[string]any, error) {
+	if len(u.variables) == 0 {
+		return nil, nil //nolint:nilnil // no variables
+	}
+
+	variables := make(map[string]any, len(u.variables))
+
+	for name, v := range u.variables {
+		resolved, err := resolveTemplateValue(v, scope)
+		if err != nil {
+			return nil, fmt.Errorf("cannot resolve graphql variable %q: %w", name, err)
+		}
+
+		variables[name] = coerceGraphQLVariable(resolved, u.variableTypes[name])
+	}
+
+	return variables, nil
+}
+
+// coerceGraphQLVariable converts a string value to the given built-in scalar type. Values which cannot be
+// converted are returned as is, so the GraphQL server reports them.
+func coerceGraphQLVariable(v any, typ string) any {
+	s, ok := v.(string)
+	if !ok {
+		return v
+	}
+
+	switch typ {
+	case "Int":
+		if i, err := strconv.ParseInt(s, 10, 64); err == nil {
+			return i
+		}
+	case "Float":
+		if f, err := strconv.ParseFloat(s, 64); err == nil {
+			return f
+		}
+	case "Boolean":
+		if b, err := strconv.ParseBool(s); err == nil {
+			return b
+		}
+	}
+
+	return v
+}
+
+// unwrapGraphQLResponse replaces the response body with its "data" and maps GraphQL errors to JSON errors.
+// A response with errors and data is partial, a response without data is an upstream error.
+func unwrapGraphQLResponse(resp *UpstreamResponse) {
+	var gresp graphqlResponse
+
+	if err := json.Unmarshal(resp.Body, &gresp); err != nil {
+		resp.Body = nil
+		resp.Err = &UpstreamError{
+			Kind: UpstreamMalformed,
+			Err:  fmt.Errorf("invalid graphql response: %w", err),
+		}
+
+		return
+	}
+
+	for _, e := range gresp.Errors {
+		resp.Errors = append(resp.Errors, JSONError{
+			Code:    ErrorCodeUpstreamGraphQL,
+			Message: e.Message,
+		})
+	}
+
+	if len(gresp.Data) == 0 || string(gresp.Data) == "null" {
+		resp.Body = nil
+
+		if len(resp.Errors) == 0 {
+			resp.Err = &UpstreamError{
+				Kind: UpstreamMalformed,
+				Err:  errors.New("graphql response has neither data nor errors"),
+			}
+
+			return
+		}
+
+		resp.Err = &UpstreamError{
+			Kind: UpstreamGraphQLError,
+			Err:  fmt.Errorf("graphql response has no data: %s", gresp.Errors[0].Message),
+		}
+
+		return
+	}
+
+	resp.Body = gresp.Data
+}
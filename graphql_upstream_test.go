@@ -0,0 +1,133 @@
+package tokka
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+func TestGraphQLUpstream_Call(t *testing.T) {
+	var received graphqlRequest
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.Header.Get("Content-Type") != "application/json" {
+			t.Errorf("unexpected request %s with content type %q", r.Method, r.Header.Get("Content-Type"))
+		}
+
+		received = graphqlRequest{}
+		if err := json.NewDecoder(r.Body).Decode(&received); err != nil {
+			t.Errorf("cannot decode graphql request: %v", err)
+		}
+
+		switch received.Variables["id"] {
+		case float64(42):
+			_, _ = w.Write([]byte(`{"data":{"user":{"id":42,"name":"alice"}}}`))
+		case float64(7):
+			_, _ = w.Write([]byte(`{"data":{"user":{"id":7,"name":null}},"errors":[{"message":"name is private"}]}`))
+		default:
+			_, _ = w.Write([]byte(`{"data":null,"errors":[{"message":"user not found"}]}`))
+		}
+	}))
+	defer server.Close()
+
+	u := newGraphQLUpstream(&httpUpstream{
+		name:        "users",
+		url:         server.URL,
+		method:      http.MethodPost,
+		contentType: "application/json",
+		timeout:     time.Second,
+		client:      server.Client(),
+	}, UpstreamGraphQLConfig{
+		Query:         `query GetUser($id: Int!, $verbose: Boolean) { user(id: $id) { id name } }`,
+		OperationName: "GetUser",
+		Variables: map[string]any{
+			"id":      "${path.id}",
+			"verbose": "${query.verbose}",
+			"tenant":  "tenant-${query.tenant}",
+		},
+	})
+
+	call := func(id string) *UpstreamResponse {
+		req := httptest.NewRequest(http.MethodGet, "http://example.com/users/"+id+"?verbose=true&tenant=acme", nil)
+		req = req.WithContext(withPathParams(req.Context(), map[string]string{"id": id}))
+
+		return u.Call(req.Context(), req, nil, UpstreamRetryPolicy{})
+	}
+
+	resp := call("42")
+	if resp.Err != nil {
+		t.Fatalf("unexpected error: %v", resp.Err.Unwrap())
+	}
+
+	if string(resp.Body) != `{"user":{"id":42,"name":"alice"}}` {
+		t.Errorf("expected unwrapped data, got %s", resp.Body)
+	}
+
+	if received.OperationName != "GetUser" || received.Variables["verbose"] != true || received.Variables["tenant"] != "tenant-acme" {
+		t.Errorf("unexpected graphql request %+v", received)
+	}
+
+	resp = call("7")
+	if resp.Err != nil {
+		t.Fatalf("unexpected error: %v", resp.Err.Unwrap())
+	}
+
+	if len(resp.Errors) != 1 || resp.Errors[0].Code != ErrorCodeUpstreamGraphQL || resp.Errors[0].Message != "name is private" {
+		t.Errorf("expected graphql error, got %+v", resp.Errors)
+	}
+
+	resp = call("0")
+	if resp.Err == nil || resp.Err.Kind != UpstreamGraphQLError || resp.Body != nil {
+		t.Fatalf("expected graphql upstream error, got %+v", resp.Err)
+	}
+
+	if len(resp.Errors) != 1 || resp.Errors[0].Message != "user not found" {
+		t.Errorf("expected graphql error, got %+v", resp.Errors)
+	}
+}
+
+func TestUnwrapGraphQLResponse_Malformed(t *testing.T) {
+	for _, body := range []string{`not json`, `{}`, `{"data":null}`} {
+		resp := &UpstreamResponse{Body: []byte(body)}
+
+		unwrapGraphQLResponse(resp)
+
+		if resp.Err == nil || resp.Err.Kind != UpstreamMalformed {
+			t.Errorf("body %s: expected malformed error, got %+v", body, resp.Err)
+		}
+	}
+}
+
+func TestAggregator_GraphQLErrors(t *testing.T) {
+	a := &defaultAggregator{log: zap.NewNop()}
+
+	responses := []UpstreamResponse{
+		{
+			Body:   []byte(`{"user":{"id":7}}`),
+			Errors: []JSONError{{Code: ErrorCodeUpstreamGraphQL, Message: "name is private"}},
+		},
+		{
+			Err:    &UpstreamError{Kind: UpstreamGraphQLError, Err: errors.New("graphql response has no data")},
+			Errors: []JSONError{{Code: ErrorCodeUpstreamGraphQL, Message: "orders not found"}},
+		},
+	}
+
+	aggregated := a.aggregate(responses, AggregationConfig{Strategy: strategyMerge, AllowPartialResults: true})
+	if !aggregated.Partial || len(aggregated.Errors) != 2 {
+		t.Fatalf("expected partial response with 2 errors, got %+v", aggregated)
+	}
+
+	if aggregated.Errors[0].Message != "name is private" || aggregated.Errors[1].Message != "orders not found" {
+		t.Errorf("unexpected errors %+v", aggregated.Errors)
+	}
+
+	aggregated = a.aggregate(responses[1:], AggregationConfig{Strategy: strategyMerge})
+	if aggregated.Partial || len(aggregated.Errors) != 1 || aggregated.Errors[0].Message != "orders not found" {
+		t.Errorf("expected graphql error, got %+v", aggregated)
+	}
+}
@@ -0,0 +1,600 @@
+package tokka
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+
+	"github.com/starwalkn/tokka/internal/circuitbreaker"
+)
+
+const (
+	grpcScheme       = "grpc"
+	grpcSecureScheme = "grpcs"
+
+	grpcContentType     = "application/grpc"
+	grpcFrameHeaderSize = 5 // Compressed flag and message length.
+)
+
+// gRPC status codes (https://grpc.github.io/grpc/core/md_doc_statuscodes.html).
+const (
+	grpcStatusOK                 = 0
+	grpcStatusCanceled           = 1
+	grpcStatusUnknown            = 2
+	grpcStatusInvalidArgument    = 3
+	grpcStatusDeadlineExceeded   = 4
+	grpcStatusNotFound           = 5
+	grpcStatusAlreadyExists      = 6
+	grpcStatusPermissionDenied   = 7
+	grpcStatusResourceExhausted  = 8
+	grpcStatusFailedPrecondition = 9
+	grpcStatusAborted            = 10
+	grpcStatusOutOfRange         = 11
+	grpcStatusUnimplemented      = 12
+	grpcStatusInternal           = 13
+	grpcStatusUnavailable        = 14
+	grpcStatusDataLoss           = 15
+	grpcStatusUnauthenticated    = 16
+)
+
+// grpcHTTPStatuses maps gRPC status codes to HTTP statuses like grpc-gateway does.
+var grpcHTTPStatuses = map[int]int{
+	grpcStatusCanceled:           499, //nolint:mnd // client closed request
+	grpcStatusUnknown:            http.StatusInternalServerError,
+	grpcStatusInvalidArgument:    http.StatusBadRequest,
+	grpcStatusDeadlineExceeded:   http.StatusGatewayTimeout,
+	grpcStatusNotFound:           http.StatusNotFound,
+	grpcStatusAlreadyExists:      http.StatusConflict,
+	grpcStatusPermissionDenied:   http.StatusForbidden,
+	grpcStatusResourceExhausted:  http.StatusTooManyRequests,
+	grpcStatusFailedPrecondition: http.StatusBadRequest,
+	grpcStatusAborted:            http.StatusConflict,
+	grpcStatusOutOfRange:         http.StatusBadRequest,
+	grpcStatusUnimplemented:      http.StatusNotImplemented,
+	grpcStatusInternal:           http.StatusInternalServerError,
+	grpcStatusUnavailable:        http.StatusServiceUnavailable,
+	grpcStatusDataLoss:           http.StatusInternalServerError,
+	grpcStatusUnauthenticated:    http.StatusUnauthorized,
+}
+
+// grpcUpstream calls a unary gRPC method. The JSON request is transcoded into the method input message
+// and the output message is transcoded back to JSON, so the response is aggregated like any other.
+type grpcUpstream struct {
+	name                string
+	url                 string // HTTP/2 URL of the method, e.g. http://users.local:9090/users.v1.UserService/GetUser.
+	timeout             time.Duration
+	headers             map[string]string
+	forwardHeaders      []string
+	forwardQueryStrings []string
+	policy              UpstreamPolicy
+
+	method protoreflect.MethodDescriptor
+	types  *dynamicpb.Types
+
+	client         *http.Client
+	circuitBreaker *circuitbreaker.CircuitBreaker
+}
+
+func (u *grpcUpstream) Name() string {
+	return u.name
+}
+
+func (u *grpcUpstream) Policy() UpstreamPolicy {
+	return u.policy
+}
+
+// Call calls the method and retries it according to the retry policy. Since gRPC calls are always POST
+// requests, the method of the original request decides whether the call is idempotent.
+func (u *grpcUpstream) Call(ctx context.Context, original *http.Request, originalBody []byte, retryPolicy UpstreamRetryPolicy) *UpstreamResponse {
+	var (
+		resp      *UpstreamResponse
+		delay     time.Duration
+		retryable = retryPolicy.MaxRetries > 0 && retryPolicy.allowsMethod(original.Method)
+	)
+
+	for attempt := 0; ; attempt++ {
+		if u.circuitBreaker != nil && !u.circuitBreaker.Allow() {
+			return &UpstreamResponse{
+				Err: &UpstreamError{
+					Kind: UpstreamCircuitOpen,
+					Err:  errors.New("upstream circuit breaker is open"),
+				},
+			}
+		}
+
+		start := time.Now()
+
+		resp = u.call(ctx, original, originalBody)
+
+		if u.circuitBreaker != nil {
+			recordBreakerOutcome(ctx, u.circuitBreaker, resp.Err, isGRPCBreakerFailure(resp.Err), time.Since(start))
+		}
+
+		if !retryable || attempt >= retryPolicy.MaxRetries || !retryPolicy.shouldRetry(resp) {
+			return resp
+		}
+
+		delay = retryPolicy.backoff(attempt, delay)
+
+		timer := time.NewTimer(delay)
+
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+
+			resp.Err = &UpstreamError{
+				Kind: UpstreamCanceled,
+				Err:  ctx.Err(),
+			}
+
+			return resp
+		}
+	}
+}
+
+func (u *grpcUpstream) call(ctx context.Context, original *http.Request, originalBody []byte) *UpstreamResponse {
+	uresp := &UpstreamResponse{
+		Headers: make(http.Header, 0),
+	}
+
+	payload, err := u.encodeInput(original, originalBody)
+	if err != nil {
+		uresp.Err = &UpstreamError{
+			Kind: UpstreamInternal,
+			Err:  fmt.Errorf("cannot build grpc request message: %w", err),
+		}
+
+		return uresp
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, u.timeout)
+	defer cancel()
+
+	req, err := u.newRequest(ctx, original, payload)
+	if err != nil {
+		uresp.Err = &UpstreamError{
+			Kind: UpstreamInternal,
+			Err:  err,
+		}
+
+		return uresp
+	}
+
+	hresp, err := u.client.Do(req)
+	if err != nil {
+		kind := UpstreamConnection
+
+		if errors.Is(err, context.DeadlineExceeded) {
+			kind = UpstreamTimeout
+		}
+
+		if errors.Is(err, context.Canceled) {
+			kind = UpstreamCanceled
+		}
+
+		uresp.Err = &UpstreamError{
+			Kind: kind,
+			Err:  err,
+		}
+
+		return uresp
+	}
+	defer hresp.Body.Close()
+
+	uresp.Headers = hresp.Header.Clone()
+
+	if hresp.StatusCode != http.StatusOK {
+		uresp.Status = hresp.StatusCode
+		uresp.Err = &UpstreamError{
+			Kind:       UpstreamBadStatus,
+			StatusCode: hresp.StatusCode,
+			Err:        fmt.Errorf("unexpected grpc http status %d", hresp.StatusCode),
+		}
+
+		return uresp
+	}
+
+	var reader io.Reader = hresp.Body
+	if u.policy.MaxResponseBodySize > 0 {
+		reader = io.LimitReader(hresp.Body, u.policy.MaxResponseBodySize+grpcFrameHeaderSize+1)
+	}
+
+	body, err := io.ReadAll(reader)
+	if err != nil {
+		uresp.Err = &UpstreamError{
+			Kind: UpstreamReadError,
+			Err:  err,
+		}
+
+		return uresp
+	}
+
+	if u.policy.MaxResponseBodySize > 0 && int64(len(body)) > u.policy.MaxResponseBodySize+grpcFrameHeaderSize {
+		uresp.Err = &UpstreamError{
+			Kind: UpstreamBodyTooLarge,
+		}
+
+		return uresp
+	}
+
+	// The status is sent in trailers, which are available once the body is read, or in headers of
+	// trailers-only responses.
+	code, message := hresp.Trailer.Get("Grpc-Status"), hresp.Trailer.Get("Grpc-Message")
+	if code == "" {
+		code, message = hresp.Header.Get("Grpc-Status"), hresp.Header.Get("Grpc-Message")
+	}
+
+	if uerr := grpcStatusError(code, message); uerr != nil {
+		uresp.Status = uerr.StatusCode
+		uresp.Err = uerr
+
+		return uresp
+	}
+
+	out, err := u.decodeOutput(body)
+	if err != nil {
+		uresp.Err = &UpstreamError{
+			Kind: UpstreamMalformed,
+			Err:  err,
+		}
+
+		return uresp
+	}
+
+	uresp.Status = http.StatusOK
+	uresp.Body = out
+	uresp.Headers.Set("Content-Type", "application/json")
+
+	return uresp
+}
+
+func (u *grpcUpstream) newRequest(ctx context.Context, original *http.Request, payload []byte) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, u.url, bytes.NewReader(payload))
+	if err != nil {
+		return nil, err
+	}
+
+	forwardHeaders(req, original, u.forwardHeaders, u.headers)
+
+	req.Header.Set("Content-Type", grpcContentType)
+	req.Header.Set("Te", "trailers")
+
+	if u.timeout > 0 {
+		req.Header.Set("Grpc-Timeout", strconv.FormatInt(u.timeout.Milliseconds(), 10)+"m")
+	}
+
+	return req, nil
+}
+
+// requestKey builds the request sent to the upstream. Like retries, caching depends on the method of the
+// original request, because gRPC calls are always POST requests.
+func (u *grpcUpstream) requestKey(
+	ctx context.Context,
+	original *http.Request,
+	originalBody []byte,
+	ignoreHeaders []string,
+) (string, http.Header, bool) {
+	if !isCacheableMethod(original.Method) {
+		return "", nil, false
+	}
+
+	payload, err := u.encodeInput(original, originalBody)
+	if err != nil {
+		return "", nil, false
+	}
+
+	req, err := u.newRequest(ctx, original, payload)
+	if err != nil {
+		return "", nil, false
+	}
+
+	header := identityHeaders(req.Header, ignoreHeaders)
+
+	return requestIdentity(req, payload, header), header, true
+}
+
+// encodeInput builds the method input message from the JSON body, the forwarded query parameters and the
+// path parameters (in order of increasing precedence) and returns it as a length-prefixed gRPC frame.
+// Parameters are matched to fields by their proto or JSON names, nested fields are addressed with dots.
+func (u *grpcUpstream) encodeInput(original *http.Request, originalBody []byte) ([]byte, error) {
+	in := dynamicpb.NewMessage(u.method.Input())
+
+	if len(bytes.TrimSpace(originalBody)) > 0 {
+		opts := protojson.UnmarshalOptions{DiscardUnknown: true, Resolver: u.types}
+		if err := opts.Unmarshal(originalBody, in); err != nil {
+			return nil, fmt.Errorf("cannot decode request body: %w", err)
+		}
+	}
+
+	for name, values := range original.URL.Query() {
+		if !slices.Contains(u.forwardQueryStrings, "*") && !slices.Contains(u.forwardQueryStrings, name) {
+			continue
+		}
+
+		if err := setMessageField(in, name, values); err != nil {
+			return nil, fmt.Errorf("query parameter %q: %w", name, err)
+		}
+	}
+
+	for name, value := range PathParams(original.Context()) {
+		if err := setMessageField(in, name, []string{value}); err != nil {
+			return nil, fmt.Errorf("path parameter %q: %w", name, err)
+		}
+	}
+
+	msg, err := proto.Marshal(in)
+	if err != nil {
+		return nil, err
+	}
+
+	frame := make([]byte, grpcFrameHeaderSize, grpcFrameHeaderSize+len(msg))
+	binary.BigEndian.PutUint32(frame[1:], uint32(len(msg))) //nolint:gosec // messages are limited by the body size
+
+	return append(frame, msg...), nil
+}
+
+// decodeOutput decodes the gRPC response frame into the method output message and returns it as JSON.
+func (u *grpcUpstream) decodeOutput(body []byte) ([]byte, error) {
+	if len(body) < grpcFrameHeaderSize {
+		return nil, errors.New("grpc response has no message")
+	}
+
+	if body[0] != 0 {
+		return nil, errors.New("compressed grpc messages are not supported")
+	}
+
+	size := binary.BigEndian.Uint32(body[1:grpcFrameHeaderSize])
+	if uint64(len(body)-grpcFrameHeaderSize) < uint64(size) {
+		return nil, errors.New("grpc response message is truncated")
+	}
+
+	out := dynamicpb.NewMessage(u.method.Output())
+	if err := proto.Unmarshal(body[grpcFrameHeaderSize:grpcFrameHeaderSize+int(size)], out); err != nil {
+		return nil, fmt.Errorf("cannot decode grpc response message: %w", err)
+	}
+
+	return protojson.MarshalOptions{UseProtoNames: true, EmitUnpopulated: true, Resolver: u.types}.Marshal(out)
+}
+
+// grpcStatusError maps a non-OK gRPC status to an upstream error. Cancellation, deadline and unavailability
+// map to the corresponding kinds, other statuses are bad statuses with the equivalent HTTP status code.
+func grpcStatusError(code, message string) *UpstreamError {
+	status, err := strconv.Atoi(code)
+	if err != nil {
+		return &UpstreamError{
+			Kind: UpstreamMalformed,
+			Err:  fmt.Errorf("invalid grpc status %q", code),
+		}
+	}
+
+	if status == grpcStatusOK {
+		return nil
+	}
+
+	if decoded, err := url.PathUnescape(message); err == nil {
+		message = decoded
+	}
+
+	kind := UpstreamBadStatus
+
+	switch status {
+	case grpcStatusCanceled:
+		kind = UpstreamCanceled
+	case grpcStatusDeadlineExceeded:
+		kind = UpstreamTimeout
+	case grpcStatusUnavailable:
+		kind = UpstreamConnection
+	}
+
+	httpStatus, ok := grpcHTTPStatuses[status]
+	if !ok {
+		httpStatus = http.StatusInternalServerError
+	}
+
+	return &UpstreamError{
+		Kind:       kind,
+		StatusCode: httpStatus,
+		Err:        fmt.Errorf("grpc status %d: %s", status, message),
+	}
+}
+
+// isGRPCBreakerFailure reports whether the error counts as a failure for the circuit breaker. Statuses
+// caused by the request, like NOT_FOUND or INVALID_ARGUMENT, do not. Canceled calls are not recorded at
+// all, see recordBreakerOutcome.
+func isGRPCBreakerFailure(uerr *UpstreamError) bool {
+	if uerr == nil {
+		return false
+	}
+
+	switch uerr.Kind { //nolint:exhaustive // other kinds are not failures
+	case UpstreamTimeout, UpstreamConnection:
+		return true
+	case UpstreamBadStatus:
+		return uerr.StatusCode >= http.StatusInternalServerError
+	default:
+		return false
+	}
+}
+
+// setMessageField sets the field addressed by the dotted path to the parsed values. Unknown fields are ignored.
+func setMessageField(msg protoreflect.Message, path string, values []string) error {
+	names := strings.Split(path, ".")
+
+	for i, name := range names {
+		fields := msg.Descriptor().Fields()
+
+		fd := fields.ByName(protoreflect.Name(name))
+		if fd == nil {
+			fd = fields.ByJSONName(name)
+		}
+
+		if fd == nil {
+			return nil
+		}
+
+		if i < len(names)-1 {
+			if fd.Kind() != protoreflect.MessageKind || fd.IsList() || fd.IsMap() {
+				return nil
+			}
+
+			msg = msg.Mutable(fd).Message()
+
+			continue
+		}
+
+		if fd.IsMap() {
+			return errors.New("map fields are not supported")
+		}
+
+		if fd.IsList() {
+			list := msg.Mutable(fd).List()
+
+			for _, v := range values {
+				value, err := parseFieldValue(fd, v)
+				if err != nil {
+					return err
+				}
+
+				list.Append(value)
+			}
+
+			return nil
+		}
+
+		value, err := parseFieldValue(fd, values[0])
+		if err != nil {
+			return err
+		}
+
+		msg.Set(fd, value)
+	}
+
+	return nil
+}
+
+// parseFieldValue parses a scalar or enum field value from its string representation.
+func parseFieldValue(fd protoreflect.FieldDescriptor, s string) (protoreflect.Value, error) {
+	switch fd.Kind() { //nolint:exhaustive // messages and groups are not scalars
+	case protoreflect.BoolKind:
+		v, err := strconv.ParseBool(s)
+		return protoreflect.ValueOfBool(v), err
+	case protoreflect.Int32Kind, protoreflect.Sint32Kind, protoreflect.Sfixed32Kind:
+		v, err := strconv.ParseInt(s, 10, 32)
+		return protoreflect.ValueOfInt32(int32(v)), err
+	case protoreflect.Int64Kind, protoreflect.Sint64Kind, protoreflect.Sfixed64Kind:
+		v, err := strconv.ParseInt(s, 10, 64)
+		return protoreflect.ValueOfInt64(v), err
+	case protoreflect.Uint32Kind, protoreflect.Fixed32Kind:
+		v, err := strconv.ParseUint(s, 10, 32)
+		return protoreflect.ValueOfUint32(uint32(v)), err
+	case protoreflect.Uint64Kind, protoreflect.Fixed64Kind:
+		v, err := strconv.ParseUint(s, 10, 64)
+		return protoreflect.ValueOfUint64(v), err
+	case protoreflect.FloatKind:
+		v, err := strconv.ParseFloat(s, 32)
+		return protoreflect.ValueOfFloat32(float32(v)), err
+	case protoreflect.DoubleKind:
+		v, err := strconv.ParseFloat(s, 64)
+		return protoreflect.ValueOfFloat64(v), err
+	case protoreflect.StringKind:
+		return protoreflect.ValueOfString(s), nil
+	case protoreflect.BytesKind:
+		v, err := base64.StdEncoding.DecodeString(s)
+		if err != nil {
+			v, err = base64.URLEncoding.DecodeString(s)
+		}
+
+		return protoreflect.ValueOfBytes(v), err
+	case protoreflect.EnumKind:
+		if ev := fd.Enum().Values().ByName(protoreflect.Name(s)); ev != nil {
+			return protoreflect.ValueOfEnum(ev.Number()), nil
+		}
+
+		v, err := strconv.ParseInt(s, 10, 32)
+
+		return protoreflect.ValueOfEnum(protoreflect.EnumNumber(v)), err
+	default:
+		return protoreflect.Value{}, fmt.Errorf("unsupported field type %s", fd.Kind())
+	}
+}
+
+// loadDescriptorSet loads a FileDescriptorSet, e.g. produced by `protoc --include_imports --descriptor_set_out`.
+func loadDescriptorSet(path string) (*protoregistry.Files, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var set descriptorpb.FileDescriptorSet
+	if err = proto.Unmarshal(data, &set); err != nil {
+		return nil, fmt.Errorf("cannot decode descriptor set: %w", err)
+	}
+
+	return protodesc.NewFiles(&set)
+}
+
+// isGRPCURL reports whether the upstream URL points to a gRPC method.
+func isGRPCURL(rawURL string) bool {
+	return strings.HasPrefix(rawURL, grpcScheme+"://") || strings.HasPrefix(rawURL, grpcSecureScheme+"://")
+}
+
+// resolveGRPCMethod parses the grpc://host:port/package.Service/Method URL (grpcs:// for TLS) and returns
+// the HTTP/2 URL of the method and its descriptor.
+func resolveGRPCMethod(rawURL string, files *protoregistry.Files) (string, protoreflect.MethodDescriptor, error) {
+	target, err := url.Parse(rawURL)
+	if err != nil {
+		return "", nil, err
+	}
+
+	service, method, ok := strings.Cut(strings.TrimPrefix(target.Path, "/"), "/")
+	if !ok || service == "" || method == "" {
+		return "", nil, fmt.Errorf("url path must be /package.Service/Method, got %q", target.Path)
+	}
+
+	desc, err := files.FindDescriptorByName(protoreflect.FullName(service))
+	if err != nil {
+		return "", nil, fmt.Errorf("service %q: %w", service, err)
+	}
+
+	sd, ok := desc.(protoreflect.ServiceDescriptor)
+	if !ok {
+		return "", nil, fmt.Errorf("%q is not a service", service)
+	}
+
+	md := sd.Methods().ByName(protoreflect.Name(method))
+	if md == nil {
+		return "", nil, fmt.Errorf("service %q has no method %q", service, method)
+	}
+
+	if md.IsStreamingClient() || md.IsStreamingServer() {
+		return "", nil, fmt.Errorf("streaming method %q is not supported", method)
+	}
+
+	if target.Scheme == grpcSecureScheme {
+		target.Scheme = "https"
+	} else {
+		target.Scheme = "http"
+	}
+
+	return target.String(), md, nil
+}
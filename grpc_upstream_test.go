@@ -0,0 +1,227 @@
+package tokka
+
+import (
+	"encoding/binary"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+// writeUserServiceDescriptorSet writes the descriptor set of the following file and returns its path:
+//
+//	package users.v1;
+//	message GetUserRequest { int64 id = 1; repeated string fields = 2; }
+//	message User { int64 id = 1; string name = 2; repeated string fields = 3; }
+//	service UserService { rpc GetUser(GetUserRequest) returns (User); }
+func writeUserServiceDescriptorSet(t *testing.T) string {
+	t.Helper()
+
+	field := func(name string, number int32, typ descriptorpb.FieldDescriptorProto_Type, repeated bool) *descriptorpb.FieldDescriptorProto {
+		label := descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL
+		if repeated {
+			label = descriptorpb.FieldDescriptorProto_LABEL_REPEATED
+		}
+
+		return &descriptorpb.FieldDescriptorProto{
+			Name:     proto.String(name),
+			JsonName: proto.String(name),
+			Number:   proto.Int32(number),
+			Type:     typ.Enum(),
+			Label:    label.Enum(),
+		}
+	}
+
+	set := &descriptorpb.FileDescriptorSet{
+		File: []*descriptorpb.FileDescriptorProto{{
+			Name:    proto.String("users/v1/users.proto"),
+			Package: proto.String("users.v1"),
+			Syntax:  proto.String("proto3"),
+			MessageType: []*descriptorpb.DescriptorProto{
+				{
+					Name: proto.String("GetUserRequest"),
+					Field: []*descriptorpb.FieldDescriptorProto{
+						field("id", 1, descriptorpb.FieldDescriptorProto_TYPE_INT64, false),
+						field("fields", 2, descriptorpb.FieldDescriptorProto_TYPE_STRING, true),
+					},
+				},
+				{
+					Name: proto.String("User"),
+					Field: []*descriptorpb.FieldDescriptorProto{
+						field("id", 1, descriptorpb.FieldDescriptorProto_TYPE_INT64, false),
+						field("name", 2, descriptorpb.FieldDescriptorProto_TYPE_STRING, false),
+						field("fields", 3, descriptorpb.FieldDescriptorProto_TYPE_STRING, true),
+					},
+				},
+			},
+			Service: []*descriptorpb.ServiceDescriptorProto{{
+				Name: proto.String("UserService"),
+				Method: []*descriptorpb.MethodDescriptorProto{{
+					Name:       proto.String("GetUser"),
+					InputType:  proto.String(".users.v1.GetUserRequest"),
+					OutputType: proto.String(".users.v1.User"),
+				}},
+			}},
+		}},
+	}
+
+	data, err := proto.Marshal(set)
+	if err != nil {
+		t.Fatalf("cannot marshal descriptor set: %v", err)
+	}
+
+	path := filepath.Join(t.TempDir(), "users.pb")
+	if err = os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatalf("cannot write descriptor set: %v", err)
+	}
+
+	return path
+}
+
+// newUserServiceServer returns an h2c gRPC server which echoes GetUser requests as users,
+// or fails with NOT_FOUND for the user 404.
+func newUserServiceServer(t *testing.T, method protoreflect.MethodDescriptor) *httptest.Server {
+	t.Helper()
+
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/users.v1.UserService/GetUser" || r.Header.Get("Content-Type") != grpcContentType {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		body, _ := io.ReadAll(r.Body)
+
+		in := dynamicpb.NewMessage(method.Input())
+		_ = proto.Unmarshal(body[grpcFrameHeaderSize:], in)
+
+		inFields := method.Input().Fields()
+		id := in.Get(inFields.ByName("id")).Int()
+
+		w.Header().Set("Content-Type", grpcContentType)
+		w.Header().Set("Trailer", "Grpc-Status, Grpc-Message")
+
+		if id == 404 {
+			w.Header().Set("Grpc-Status", "5")
+			w.Header().Set("Grpc-Message", "user%20not%20found")
+
+			return
+		}
+
+		out := dynamicpb.NewMessage(method.Output())
+		outFields := method.Output().Fields()
+
+		out.Set(outFields.ByName("id"), protoreflect.ValueOfInt64(id))
+		out.Set(outFields.ByName("name"), protoreflect.ValueOfString(r.Header.Get("X-User-Name")))
+
+		fields := out.Mutable(outFields.ByName("fields")).List()
+		for i, list := 0, in.Get(inFields.ByName("fields")).List(); i < list.Len(); i++ {
+			fields.Append(list.Get(i))
+		}
+
+		msg, _ := proto.Marshal(out)
+
+		frame := make([]byte, grpcFrameHeaderSize)
+		binary.BigEndian.PutUint32(frame[1:], uint32(len(msg)))
+
+		_, _ = w.Write(append(frame, msg...))
+
+		w.Header().Set("Grpc-Status", "0")
+	}))
+
+	server.Config.Protocols = new(http.Protocols)
+	server.Config.Protocols.SetUnencryptedHTTP2(true)
+	server.Start()
+
+	return server
+}
+
+func TestGrpcUpstream_Call(t *testing.T) {
+	descriptorSet := writeUserServiceDescriptorSet(t)
+
+	files, err := loadDescriptorSet(descriptorSet)
+	if err != nil {
+		t.Fatalf("cannot load descriptor set: %v", err)
+	}
+
+	target, method, err := resolveGRPCMethod("grpc://users.local:9090/users.v1.UserService/GetUser", files)
+	if err != nil {
+		t.Fatalf("cannot resolve method: %v", err)
+	}
+
+	if target != "http://users.local:9090/users.v1.UserService/GetUser" {
+		t.Errorf("unexpected target url %q", target)
+	}
+
+	server := newUserServiceServer(t, method)
+	defer server.Close()
+
+	protocols := new(http.Protocols)
+	protocols.SetUnencryptedHTTP2(true)
+
+	u := &grpcUpstream{
+		name:                "users",
+		url:                 server.URL + "/users.v1.UserService/GetUser",
+		timeout:             time.Second,
+		forwardHeaders:      []string{"X-User-Name"},
+		forwardQueryStrings: []string{"fields"},
+		method:              method,
+		types:               dynamicpb.NewTypes(files),
+		client:              &http.Client{Transport: &http.Transport{Protocols: protocols}},
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "http://example.com/users/42?fields=name&fields=email&ignored=1", nil)
+	req.Header.Set("X-User-Name", "alice")
+	req = req.WithContext(withPathParams(req.Context(), map[string]string{"id": "42"}))
+
+	resp := u.Call(req.Context(), req, nil, UpstreamRetryPolicy{})
+	if resp.Err != nil {
+		t.Fatalf("unexpected error: %v", resp.Err.Unwrap())
+	}
+
+	expected := `{"id":"42","name":"alice","fields":["name","email"]}`
+	if strings.ReplaceAll(string(resp.Body), " ", "") != expected {
+		t.Errorf("expected body %s, got %s", expected, resp.Body)
+	}
+
+	req = httptest.NewRequest(http.MethodPost, "http://example.com/users", strings.NewReader(`{"id": 404}`))
+
+	resp = u.Call(req.Context(), req, []byte(`{"id": 404}`), UpstreamRetryPolicy{})
+	if resp.Err == nil || resp.Err.Kind != UpstreamBadStatus || resp.Err.StatusCode != http.StatusNotFound {
+		t.Errorf("expected not found error, got %+v", resp.Err)
+	}
+}
+
+func TestGrpcStatusError(t *testing.T) {
+	tests := []struct {
+		code           string
+		expectedKind   UpstreamErrorKind
+		expectedStatus int
+	}{
+		{code: "1", expectedKind: UpstreamCanceled, expectedStatus: 499},
+		{code: "3", expectedKind: UpstreamBadStatus, expectedStatus: http.StatusBadRequest},
+		{code: "4", expectedKind: UpstreamTimeout, expectedStatus: http.StatusGatewayTimeout},
+		{code: "14", expectedKind: UpstreamConnection, expectedStatus: http.StatusServiceUnavailable},
+		{code: "16", expectedKind: UpstreamBadStatus, expectedStatus: http.StatusUnauthorized},
+		{code: "", expectedKind: UpstreamMalformed},
+	}
+
+	for _, tt := range tests {
+		uerr := grpcStatusError(tt.code, "")
+		if uerr == nil || uerr.Kind != tt.expectedKind || uerr.StatusCode != tt.expectedStatus {
+			t.Errorf("code %q: expected %s/%d, got %+v", tt.code, tt.expectedKind, tt.expectedStatus, uerr)
+		}
+	}
+
+	if uerr := grpcStatusError("0", ""); uerr != nil {
+		t.Errorf("expected no error for OK status, got %+v", uerr)
+	}
+}
@@ -0,0 +1,154 @@
+package tokka
+
+import (
+	"context"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+)
+
+const (
+	hedgingLatencySamples    = 256 // Size of the latency window used to compute percentiles.
+	hedgingMinLatencySamples = 20  // Minimum samples before a percentile is trusted.
+)
+
+type UpstreamHedgingPolicy struct {
+	Enabled      bool
+	URLs         []string      // Alternative endpoints which receive hedged requests.
+	Delay        time.Duration // Delay before a hedged request is sent.
+	Percentile   float64       // If set, the delay is the given percentile of observed latencies.
+	MaxExtraLoad float64       // Maximum extra load caused by hedged requests in percent.
+}
+
+// hedger holds the state of upstream hedging: observed latencies and the hedging budget.
+type hedger struct {
+	policy UpstreamHedgingPolicy
+	budget *budget
+
+	mu        sync.Mutex
+	latencies []time.Duration
+	next      int
+}
+
+func newHedger(policy UpstreamHedgingPolicy) *hedger {
+	return &hedger{
+		policy:    policy,
+		budget:    newBudget(policy.MaxExtraLoad/100, 0), //nolint:mnd // percent
+		latencies: make([]time.Duration, 0, hedgingLatencySamples),
+	}
+}
+
+// delay returns how long to wait for a response before sending a hedged request.
+func (h *hedger) delay() time.Duration {
+	if h.policy.Percentile <= 0 {
+		return h.policy.Delay
+	}
+
+	h.mu.Lock()
+	samples := slices.Clone(h.latencies)
+	h.mu.Unlock()
+
+	if len(samples) < hedgingMinLatencySamples {
+		return h.policy.Delay
+	}
+
+	slices.Sort(samples)
+
+	idx := int(float64(len(samples)-1) * h.policy.Percentile / 100) //nolint:mnd // percent
+	idx = min(max(idx, 0), len(samples)-1)
+
+	return samples[idx]
+}
+
+// observe records the latency of a successful primary request.
+func (h *hedger) observe(latency time.Duration) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if len(h.latencies) < hedgingLatencySamples {
+		h.latencies = append(h.latencies, latency)
+		return
+	}
+
+	h.latencies[h.next] = latency
+	h.next = (h.next + 1) % hedgingLatencySamples
+}
+
+// isHedgeable reports whether requests with the given method can be hedged.
+func isHedgeable(method string) bool {
+	return method == http.MethodGet || method == http.MethodHead
+}
+
+// hedgedCall sends the request to the upstream URL and, if there is no response within the hedging delay,
+// sends hedged requests to the alternative URLs. The first successful response is returned and the other
+// requests are canceled. If all requests fail, the last failure is returned.
+func (u *httpUpstream) hedgedCall(ctx context.Context, original *http.Request, originalBody []byte) *UpstreamResponse {
+	type hedgeResult struct {
+		resp   *UpstreamResponse
+		hedged bool
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var (
+		ch       = make(chan hedgeResult, 1+len(u.hedger.policy.URLs)) // Buffered, so canceled requests never block.
+		start    = time.Now()
+		inflight = 0
+		next     = 0
+		last     *UpstreamResponse
+	)
+
+	launch := func(rawURL string, hedged bool) {
+		inflight++
+
+		go func() {
+			ch <- hedgeResult{resp: u.call(ctx, rawURL, original, originalBody), hedged: hedged}
+		}()
+	}
+
+	u.hedger.budget.deposit()
+	launch(u.url, false)
+
+	delay := u.hedger.delay()
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	for inflight > 0 {
+		select {
+		case <-timer.C:
+			if next >= len(u.hedger.policy.URLs) || !u.hedger.budget.withdraw() {
+				continue
+			}
+
+			if u.metrics != nil {
+				u.metrics.IncHedgedRequestsTotal(u.name)
+			}
+			launch(u.hedger.policy.URLs[next], true)
+
+			next++
+			timer.Reset(delay)
+		case res := <-ch:
+			inflight--
+
+			if res.resp.Err != nil {
+				last = res.resp
+				continue
+			}
+
+			if res.hedged {
+				if u.metrics != nil {
+					u.metrics.IncHedgedWinsTotal(u.name)
+				}
+			} else {
+				u.hedger.observe(time.Since(start))
+			}
+
+			return res.resp
+		}
+	}
+
+	return last
+}
@@ -0,0 +1,127 @@
+package tokka
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/starwalkn/tokka/internal/metric"
+)
+
+func TestHttpUpstream_HedgedCall(t *testing.T) {
+	primaryCanceled := make(chan struct{})
+
+	primary := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+			close(primaryCanceled)
+		case <-time.After(2 * time.Second):
+			w.Write([]byte(`{"from":"primary"}`))
+		}
+	}))
+	defer primary.Close()
+
+	replica := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Write([]byte(`{"from":"replica"}`))
+	}))
+	defer replica.Close()
+
+	u := &httpUpstream{
+		url:     primary.URL,
+		timeout: 3 * time.Second,
+		client:  http.DefaultClient,
+		metrics: metric.NewNop(),
+		hedger: newHedger(UpstreamHedgingPolicy{
+			Enabled:      true,
+			URLs:         []string{replica.URL},
+			Delay:        20 * time.Millisecond,
+			MaxExtraLoad: 100,
+		}),
+	}
+
+	start := time.Now()
+
+	resp := u.Call(t.Context(), httptest.NewRequest(http.MethodGet, "/", nil), nil, UpstreamRetryPolicy{})
+
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("hedged call waited for the slow primary, took %s", elapsed)
+	}
+
+	if resp.Err != nil || string(resp.Body) != `{"from":"replica"}` {
+		t.Fatalf("unexpected response: %s %v", resp.Body, resp.Err)
+	}
+
+	select {
+	case <-primaryCanceled:
+	case <-time.After(time.Second):
+		t.Errorf("primary request was not canceled")
+	}
+}
+
+func TestHttpUpstream_HedgedCall_WithoutMetrics(t *testing.T) {
+	primary := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-time.After(2 * time.Second):
+			w.Write([]byte(`{"from":"primary"}`))
+		}
+	}))
+	defer primary.Close()
+
+	replica := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Write([]byte(`{"from":"replica"}`))
+	}))
+	defer replica.Close()
+
+	u := &httpUpstream{
+		url:     primary.URL,
+		timeout: 3 * time.Second,
+		client:  http.DefaultClient,
+		hedger: newHedger(UpstreamHedgingPolicy{
+			Enabled:      true,
+			URLs:         []string{replica.URL},
+			Delay:        20 * time.Millisecond,
+			MaxExtraLoad: 100,
+		}),
+	}
+
+	resp := u.Call(t.Context(), httptest.NewRequest(http.MethodGet, "/", nil), nil, UpstreamRetryPolicy{})
+	if resp.Err != nil || string(resp.Body) != `{"from":"replica"}` {
+		t.Errorf("unexpected response: %s %v", resp.Body, resp.Err)
+	}
+}
+
+func TestBudget(t *testing.T) {
+	b := newBudget(0.5, 0)
+
+	b.deposit()
+	if b.withdraw() {
+		t.Fatalf("extra request allowed before the budget is accumulated")
+	}
+
+	b.deposit()
+	if !b.withdraw() {
+		t.Fatalf("extra request not allowed after the budget is accumulated")
+	}
+
+	if b.withdraw() {
+		t.Errorf("budget must be spent")
+	}
+}
+
+func TestHedger_PercentileDelay(t *testing.T) {
+	h := newHedger(UpstreamHedgingPolicy{Delay: time.Second, Percentile: 90})
+
+	if got := h.delay(); got != time.Second {
+		t.Errorf("expected fallback delay without samples, got %s", got)
+	}
+
+	for i := 1; i <= 100; i++ {
+		h.observe(time.Duration(i) * time.Millisecond)
+	}
+
+	if got := h.delay(); got != 90*time.Millisecond {
+		t.Errorf("expected p90 delay of 90ms, got %s", got)
+	}
+}
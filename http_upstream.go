@@ -6,11 +6,15 @@ import (
 	"errors"
 	"io"
 	"net/http"
+	"net/url"
 	"slices"
 	"strings"
 	"time"
 
+	"golang.org/x/sync/singleflight"
+
 	"github.com/starwalkn/tokka/internal/circuitbreaker"
+	"github.com/starwalkn/tokka/internal/metric"
 )
 
 type httpUpstream struct {
@@ -21,10 +25,15 @@ type httpUpstream struct {
 	headers             map[string]string
 	forwardHeaders      []string
 	forwardQueryStrings []string
+	contentType         string // Content-Type of requests. The original one if empty.
 	policy              UpstreamPolicy
 
 	client         *http.Client
 	circuitBreaker *circuitbreaker.CircuitBreaker
+	hedger         *hedger
+	retryBudget    *budget
+	coalescing     *singleflight.Group
+	metrics        metric.Metrics
 }
 
 func (u *httpUpstream) Name() string {
@@ -35,7 +44,7 @@ func (u *httpUpstream) Policy() UpstreamPolicy {
 	return u.policy
 }
 
-func (u *httpUpstream) call(ctx context.Context, original *http.Request, originalBody []byte) *UpstreamResponse {
+func (u *httpUpstream) call(ctx context.Context, rawURL string, original *http.Request, originalBody []byte) *UpstreamResponse {
 	uresp := &UpstreamResponse{
 		Headers: make(http.Header, 0),
 	}
@@ -43,7 +52,7 @@ func (u *httpUpstream) call(ctx context.Context, original *http.Request, origina
 	ctx, cancel := context.WithTimeout(ctx, u.timeout)
 	defer cancel()
 
-	req, err := u.newRequest(ctx, original, originalBody)
+	req, err := u.newRequest(ctx, rawURL, original, originalBody)
 	if err != nil {
 		uresp.Err = &UpstreamError{
 			Kind: UpstreamInternal,
@@ -75,6 +84,7 @@ func (u *httpUpstream) call(ctx context.Context, original *http.Request, origina
 	defer hresp.Body.Close()
 
 	uresp.Status = hresp.StatusCode
+	uresp.Headers = hresp.Header.Clone()
 
 	if hresp.StatusCode >= http.StatusInternalServerError {
 		uresp.Err = &UpstreamError{
@@ -86,8 +96,6 @@ func (u *httpUpstream) call(ctx context.Context, original *http.Request, origina
 		return uresp
 	}
 
-	uresp.Headers = hresp.Header.Clone()
-
 	var reader io.Reader = hresp.Body
 	if u.policy.MaxResponseBodySize > 0 {
 		reader = io.LimitReader(hresp.Body, u.policy.MaxResponseBodySize+1)
@@ -116,74 +124,136 @@ func (u *httpUpstream) call(ctx context.Context, original *http.Request, origina
 	return uresp
 }
 
+// Call sends the request to the upstream and retries it according to the retry policy.
+//
+// Retries are performed only for idempotent methods unless the policy allows otherwise, wait for the
+// policy backoff (or the upstream Retry-After delay when respected) and are limited by the retry budget.
 func (u *httpUpstream) Call(ctx context.Context, original *http.Request, originalBody []byte, retryPolicy UpstreamRetryPolicy) *UpstreamResponse {
-	resp := &UpstreamResponse{}
-
-	for attempt := 0; attempt <= retryPolicy.MaxRetries; attempt++ {
-		select {
-		case <-ctx.Done():
-			resp.Err = &UpstreamError{
-				Kind: UpstreamCanceled,
-				Err:  ctx.Err(),
+	if u.coalescing != nil && isCoalescible(u.resolveMethod(original)) {
+		return u.coalescedCall(ctx, original, originalBody, retryPolicy)
+	}
+
+	return u.callWithRetries(ctx, original, originalBody, retryPolicy)
+}
+
+func (u *httpUpstream) callWithRetries(
+	ctx context.Context,
+	original *http.Request,
+	originalBody []byte,
+	retryPolicy UpstreamRetryPolicy,
+) *UpstreamResponse {
+	var (
+		resp      *UpstreamResponse
+		delay     time.Duration
+		retryable = retryPolicy.MaxRetries > 0 && retryPolicy.allowsMethod(u.resolveMethod(original))
+	)
+
+	if u.retryBudget != nil {
+		u.retryBudget.deposit()
+	}
+
+	for attempt := 0; ; attempt++ {
+		if ctx.Err() != nil {
+			return &UpstreamResponse{
+				Err: &UpstreamError{
+					Kind: UpstreamCanceled,
+					Err:  ctx.Err(),
+				},
 			}
+		}
 
-			return resp
-		default:
-			if u.circuitBreaker != nil {
-				if allow := u.circuitBreaker.Allow(); !allow {
-					return &UpstreamResponse{
-						Err: &UpstreamError{
-							Kind: UpstreamCircuitOpen,
-							Err:  errors.New("upstream circuit breaker is open"),
-						},
-					}
+		if u.circuitBreaker != nil {
+			if allow := u.circuitBreaker.Allow(); !allow {
+				return &UpstreamResponse{
+					Err: &UpstreamError{
+						Kind: UpstreamCircuitOpen,
+						Err:  errors.New("upstream circuit breaker is open"),
+					},
 				}
 			}
+		}
 
-			resp = u.call(ctx, original, originalBody)
-			if resp.Err == nil && !slices.Contains(retryPolicy.RetryOnStatuses, resp.Status) {
-				break
-			}
+		start := time.Now()
 
-			if u.circuitBreaker != nil {
-				if resp.Err != nil && u.isBreakerFailure(resp.Err) {
-					u.circuitBreaker.OnFailure()
-				} else {
-					u.circuitBreaker.OnSuccess()
+		if u.hedger != nil && isHedgeable(u.resolveMethod(original)) {
+			resp = u.hedgedCall(ctx, original, originalBody)
+		} else {
+			resp = u.call(ctx, u.url, original, originalBody)
+		}
+
+		if u.circuitBreaker != nil {
+			recordBreakerOutcome(ctx, u.circuitBreaker, resp.Err, u.isBreakerFailure(resp.Err), time.Since(start))
+		}
+
+		if !retryable || attempt >= retryPolicy.MaxRetries || !retryPolicy.shouldRetry(resp) {
+			return resp
+		}
+
+		if u.retryBudget != nil && !u.retryBudget.withdraw() {
+			return resp
+		}
+
+		delay = retryPolicy.backoff(attempt, delay)
+
+		if retryPolicy.RespectRetryAfter {
+			if after, ok := retryAfter(resp); ok {
+				// Do not retry if the upstream asks to wait longer than the policy allows or the request lives.
+				if after > retryPolicy.maxRetryAfter() {
+					return resp
+				}
+
+				if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < after {
+					return resp
 				}
+
+				delay = after
 			}
+		}
 
-			if retryPolicy.BackoffDelay > 0 {
-				select {
-				case <-time.After(retryPolicy.BackoffDelay):
-				case <-ctx.Done():
-					resp.Err = &UpstreamError{
-						Kind: UpstreamCanceled,
-						Err:  ctx.Err(),
-					}
+		if delay > 0 {
+			timer := time.NewTimer(delay)
 
-					return resp
+			select {
+			case <-timer.C:
+			case <-ctx.Done():
+				timer.Stop()
+
+				resp.Err = &UpstreamError{
+					Kind: UpstreamCanceled,
+					Err:  ctx.Err(),
 				}
+
+				return resp
 			}
 		}
 	}
-
-	return resp
 }
 
-func (u *httpUpstream) newRequest(ctx context.Context, original *http.Request, originalBody []byte) (*http.Request, error) {
-	method := u.method
-	if method == "" {
+// resolveMethod returns the method of requests sent to the upstream.
+func (u *httpUpstream) resolveMethod(original *http.Request) string {
+	if u.method == "" {
 		// Fallback method.
-		method = original.Method
+		return original.Method
 	}
 
+	return u.method
+}
+
+func (u *httpUpstream) newRequest(ctx context.Context, rawURL string, original *http.Request, originalBody []byte) (*http.Request, error) {
+	method := u.resolveMethod(original)
+
 	// Send request body only for body-acceptable methods requests.
 	if method != http.MethodPost && method != http.MethodPut && method != http.MethodPatch {
 		originalBody = nil
 	}
 
-	target, err := http.NewRequestWithContext(ctx, method, u.url, bytes.NewReader(originalBody))
+	// Resolve references to responses of upstream dependencies.
+	targetURL, err := resolveTemplate(rawURL, templateScopeFrom(ctx), escapeURLValue)
+	if err != nil {
+		return nil, err
+	}
+
+	target, err := http.NewRequestWithContext(ctx, method, targetURL, bytes.NewReader(originalBody))
 	if err != nil {
 		return nil, err
 	}
@@ -194,6 +264,11 @@ func (u *httpUpstream) newRequest(ctx context.Context, original *http.Request, o
 	return target, nil
 }
 
+// escapeURLValue escapes a value substituted into the upstream URL. The result is safe for both path and query.
+func escapeURLValue(v string) string {
+	return strings.ReplaceAll(url.QueryEscape(v), "+", "%20")
+}
+
 func (u *httpUpstream) resolveQueryStrings(target, original *http.Request) {
 	q := target.URL.Query()
 
@@ -214,8 +289,22 @@ func (u *httpUpstream) resolveQueryStrings(target, original *http.Request) {
 }
 
 func (u *httpUpstream) resolveHeaders(target, original *http.Request) {
+	forwardHeaders(target, original, u.forwardHeaders, u.headers)
+
+	if u.contentType != "" {
+		target.Header.Set("Content-Type", u.contentType)
+		return
+	}
+
+	// Always forward the Content-Type header.
+	target.Header.Set("Content-Type", original.Header.Get("Content-Type"))
+}
+
+// forwardHeaders copies the forwarded headers of the original request to the target one and rewrites them
+// with the configured values.
+func forwardHeaders(target, original *http.Request, forward []string, headers map[string]string) {
 	// Set forwarding headers.
-	for _, fw := range u.forwardHeaders {
+	for _, fw := range forward {
 		if fw == "*" {
 			target.Header = original.Header.Clone()
 			break
@@ -241,22 +330,17 @@ func (u *httpUpstream) resolveHeaders(target, original *http.Request) {
 	}
 
 	// Rewrite headers which exists in upstream headers configuration (rewriting only forwarded headers).
-	for header, value := range u.headers {
-		if slices.Contains(u.forwardHeaders, "*") || target.Header.Get(header) != "" {
+	for header, value := range headers {
+		if slices.Contains(forward, "*") || target.Header.Get(header) != "" {
 			target.Header.Set(header, value)
 		}
 	}
-
-	// Always forward the Content-Type header.
-	target.Header.Set("Content-Type", original.Header.Get("Content-Type"))
 }
 
+// isBreakerFailure reports whether the error counts as a failure for the circuit breaker. Timeouts of the
+// upstream call are failures, canceled calls are not recorded at all, see recordBreakerOutcome.
 func (u *httpUpstream) isBreakerFailure(uerr *UpstreamError) bool {
-	if uerr == nil || uerr.Err == nil {
-		return false
-	}
-
-	if errors.Is(uerr.Err, context.Canceled) || errors.Is(uerr.Err, context.DeadlineExceeded) {
+	if uerr == nil {
 		return false
 	}
 
@@ -0,0 +1,116 @@
+package cache
+
+import (
+	"container/list"
+	"sync"
+)
+
+// LRU is a concurrency-safe least recently used cache limited by the number of entries and their total size.
+type LRU[V any] struct {
+	mu         sync.Mutex
+	maxEntries int
+	maxSize    int64
+	size       int64
+	order      *list.List
+	items      map[string]*list.Element
+}
+
+type item[V any] struct {
+	key   string
+	value V
+	size  int64
+}
+
+// New creates an LRU cache. Zero maxEntries or maxSize means no limit.
+func New[V any](maxEntries int, maxSize int64) *LRU[V] {
+	return &LRU[V]{
+		maxEntries: maxEntries,
+		maxSize:    maxSize,
+		order:      list.New(),
+		items:      make(map[string]*list.Element),
+	}
+}
+
+// Get returns the value stored under the key and marks it as recently used.
+func (c *LRU[V]) Get(key string) (V, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	el, ok := c.items[key]
+	if !ok {
+		var zero V
+		return zero, false
+	}
+
+	c.order.MoveToFront(el)
+
+	return el.Value.(*item[V]).value, true //nolint:errcheck,forcetypeassert // only items are stored
+}
+
+// Set stores the value under the key and evicts least recently used entries exceeding the limits.
+// Values larger than the size limit are not stored.
+func (c *LRU[V]) Set(key string, value V, size int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if el, ok := c.items[key]; ok {
+		c.remove(el)
+	}
+
+	if c.maxSize > 0 && size > c.maxSize {
+		return
+	}
+
+	c.items[key] = c.order.PushFront(&item[V]{key: key, value: value, size: size})
+	c.size += size
+
+	for (c.maxEntries > 0 && c.order.Len() > c.maxEntries) || (c.maxSize > 0 && c.size > c.maxSize) {
+		c.remove(c.order.Back())
+	}
+}
+
+// Delete removes the key from the cache and reports whether it was present.
+func (c *LRU[V]) Delete(key string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	el, ok := c.items[key]
+	if ok {
+		c.remove(el)
+	}
+
+	return ok
+}
+
+// DeleteFunc removes all entries for which fn returns true and returns the number of removed entries.
+func (c *LRU[V]) DeleteFunc(fn func(key string, value V) bool) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	removed := 0
+
+	for key, el := range c.items {
+		if fn(key, el.Value.(*item[V]).value) { //nolint:errcheck,forcetypeassert // only items are stored
+			c.remove(el)
+			removed++
+		}
+	}
+
+	return removed
+}
+
+// Len returns the number of cached entries.
+func (c *LRU[V]) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.order.Len()
+}
+
+func (c *LRU[V]) remove(el *list.Element) {
+	it := el.Value.(*item[V]) //nolint:errcheck,forcetypeassert // only items are stored
+
+	c.order.Remove(el)
+	delete(c.items, it.key)
+	c.size -= it.size
+}
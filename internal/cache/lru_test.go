@@ -0,0 +1,57 @@
+package cache
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestLRU_EvictsLeastRecentlyUsed(t *testing.T) {
+	c := New[string](2, 0)
+
+	c.Set("a", "1", 1)
+	c.Set("b", "2", 1)
+	c.Get("a")
+	c.Set("c", "3", 1)
+
+	if _, ok := c.Get("b"); ok {
+		t.Errorf("expected b to be evicted")
+	}
+
+	if _, ok := c.Get("a"); !ok {
+		t.Errorf("expected a to be cached")
+	}
+}
+
+func TestLRU_MaxSize(t *testing.T) {
+	c := New[string](0, 10)
+
+	c.Set("a", "1", 6)
+	c.Set("b", "2", 6)
+
+	if c.Len() != 1 {
+		t.Errorf("expected 1 entry, got %d", c.Len())
+	}
+
+	c.Set("c", "3", 11)
+
+	if _, ok := c.Get("c"); ok {
+		t.Errorf("expected entry larger than max size not to be cached")
+	}
+}
+
+func TestLRU_DeleteFunc(t *testing.T) {
+	c := New[string](0, 0)
+
+	c.Set("users/1", "1", 1)
+	c.Set("users/2", "2", 1)
+	c.Set("orders/1", "3", 1)
+
+	removed := c.DeleteFunc(func(key, _ string) bool { return strings.HasPrefix(key, "users/") })
+	if removed != 2 {
+		t.Errorf("expected 2 removed entries, got %d", removed)
+	}
+
+	if c.Len() != 1 {
+		t.Errorf("expected 1 entry, got %d", c.Len())
+	}
+}
@@ -13,87 +13,355 @@ const (
 	HalfOpen
 )
 
+func (s State) String() string {
+	switch s {
+	case Closed:
+		return "closed"
+	case Open:
+		return "open"
+	case HalfOpen:
+		return "half_open"
+	default:
+		return "unknown"
+	}
+}
+
+const (
+	WindowCount = "count"
+	WindowTime  = "time"
+
+	defaultWindowSize     = 100
+	defaultWindowDuration = 60 * time.Second
+	defaultHalfOpenProbes = 1
+)
+
+// Config configures a circuit breaker.
+//
+// If neither FailureRateThreshold nor SlowCallRateThreshold is set, the breaker opens after MaxFailures
+// consecutive failures. Otherwise, it opens when the failure or slow call rate over the rolling window
+// reaches the threshold, provided that the window holds at least MinimumRequests calls.
+type Config struct {
+	MaxFailures  int
+	ResetTimeout time.Duration
+
+	FailureRateThreshold  float64       // Percent of failed calls.
+	SlowCallRateThreshold float64       // Percent of slow calls.
+	SlowCallDuration      time.Duration // Calls longer than this are slow.
+	WindowType            string        // WindowCount (default) or WindowTime.
+	WindowSize            int           // Number of calls in a count-based window.
+	WindowDuration        time.Duration // Duration of a time-based window.
+	MinimumRequests       int
+
+	HalfOpenProbes int // Number of trial calls allowed in the half-open state.
+
+	// OnStateChange is called on every state transition outside the breaker lock.
+	OnStateChange func(from, to State)
+}
+
+// Snapshot is a point-in-time view of a circuit breaker.
+type Snapshot struct {
+	State       State
+	Forced      bool      // The state was forced manually and is kept until Reset.
+	Failures    int       // Consecutive failures, or failures in the rolling window.
+	Requests    int       // Calls in the rolling window. Zero for consecutive failure counting.
+	NextRetryAt time.Time // When an open breaker lets trial calls through. Zero if not applicable.
+}
+
 type CircuitBreaker struct {
 	mu            sync.Mutex
 	state         State
+	forced        bool
 	failures      int
 	lastFailureAt time.Time
+	openedAt      time.Time
 
-	maxFailures   int
-	resetTimeout  time.Duration
-	halfOpenTrial bool
+	window           window
+	halfOpenInFlight int
+	halfOpenPassed   int
+
+	cfg Config
 }
 
+// New creates a circuit breaker which opens after maxFailures consecutive failures.
 func New(maxFailures int, resetTimeout time.Duration) *CircuitBreaker {
-	return &CircuitBreaker{
-		state:        Closed,
-		maxFailures:  maxFailures,
-		resetTimeout: resetTimeout,
+	return NewWithConfig(Config{
+		MaxFailures:  maxFailures,
+		ResetTimeout: resetTimeout,
+	})
+}
+
+// NewWithConfig creates a circuit breaker with the given configuration.
+func NewWithConfig(cfg Config) *CircuitBreaker {
+	if cfg.HalfOpenProbes < 1 {
+		cfg.HalfOpenProbes = defaultHalfOpenProbes
+	}
+
+	if cfg.WindowType == WindowTime && cfg.WindowDuration <= 0 {
+		cfg.WindowDuration = defaultWindowDuration
+	}
+
+	if cfg.WindowType != WindowTime && cfg.WindowSize < 1 {
+		cfg.WindowSize = defaultWindowSize
+	}
+
+	b := &CircuitBreaker{
+		state: Closed,
+		cfg:   cfg,
 	}
+
+	if b.rateBased() {
+		switch cfg.WindowType {
+		case WindowTime:
+			b.window = newTimeWindow(cfg.WindowDuration)
+		default:
+			b.window = newCountWindow(cfg.WindowSize)
+		}
+	}
+
+	return b
 }
 
 func (b *CircuitBreaker) Allow() bool {
 	b.mu.Lock()
+
+	from := b.state
+	allowed := b.allow(time.Now())
+	to := b.state
+
+	b.mu.Unlock()
+
+	b.notify(from, to)
+
+	return allowed
+}
+
+func (b *CircuitBreaker) OnFailure() {
+	b.Record(true, 0)
+}
+
+func (b *CircuitBreaker) OnSuccess() {
+	b.Record(false, 0)
+}
+
+// Record records the outcome of a call and its duration.
+func (b *CircuitBreaker) Record(failed bool, duration time.Duration) {
+	b.mu.Lock()
+
+	from := b.state
+	b.record(failed, b.isSlow(duration), time.Now())
+	to := b.state
+
+	b.mu.Unlock()
+
+	b.notify(from, to)
+}
+
+// Release gives back the half-open trial slot of a call whose outcome is not recorded, like a call
+// canceled by its caller.
+func (b *CircuitBreaker) Release() {
+	b.mu.Lock()
 	defer b.mu.Unlock()
 
+	if b.state == HalfOpen && !b.forced {
+		b.halfOpenInFlight = max(b.halfOpenInFlight-1, 0)
+	}
+}
+
+func (b *CircuitBreaker) State() State {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.state
+}
+
+// Snapshot returns the current state and counters of the breaker.
+func (b *CircuitBreaker) Snapshot() Snapshot {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := time.Now()
+
+	s := Snapshot{
+		State:    b.state,
+		Forced:   b.forced,
+		Failures: b.failures,
+	}
+
+	if b.window != nil {
+		c := b.window.stats(now)
+
+		s.Failures = c.failures
+		s.Requests = c.total
+	}
+
+	if b.state == Open && !b.forced {
+		s.NextRetryAt = b.openedAt.Add(b.cfg.ResetTimeout)
+	}
+
+	return s
+}
+
+// ForceOpen opens the breaker and keeps it open until Reset.
+func (b *CircuitBreaker) ForceOpen() {
+	b.force(Open)
+}
+
+// ForceClose closes the breaker and keeps it closed regardless of failures until Reset.
+func (b *CircuitBreaker) ForceClose() {
+	b.force(Closed)
+}
+
+// Reset clears a forced state and all counters, closing the breaker.
+func (b *CircuitBreaker) Reset() {
+	b.mu.Lock()
+
+	from := b.state
+	b.forced = false
+	b.close()
+	to := b.state
+
+	b.mu.Unlock()
+
+	b.notify(from, to)
+}
+
+func (b *CircuitBreaker) force(state State) {
+	b.mu.Lock()
+
+	from := b.state
+	now := time.Now()
+
+	if state == Open {
+		b.open(now)
+	} else {
+		b.close()
+	}
+
+	b.forced = true
+	to := b.state
+
+	b.mu.Unlock()
+
+	b.notify(from, to)
+}
+
+func (b *CircuitBreaker) allow(now time.Time) bool {
+	if b.forced {
+		return b.state != Open
+	}
+
 	switch b.state {
 	case Open:
-		if time.Since(b.lastFailureAt) >= b.resetTimeout {
-			b.state = HalfOpen
-			b.halfOpenTrial = false
-
-			return true
+		if now.Sub(b.openedAt) < b.cfg.ResetTimeout {
+			return false
 		}
 
-		return false
-	case HalfOpen:
-		if !b.halfOpenTrial {
-			b.halfOpenTrial = true
+		b.state = HalfOpen
+		b.halfOpenInFlight = 0
+		b.halfOpenPassed = 0
 
-			return true
+		fallthrough
+	case HalfOpen:
+		if b.halfOpenInFlight+b.halfOpenPassed >= b.cfg.HalfOpenProbes {
+			return false
 		}
 
-		return false
+		b.halfOpenInFlight++
+
+		return true
 	default:
 		return true
 	}
 }
 
-func (b *CircuitBreaker) OnFailure() {
-	b.mu.Lock()
-	defer b.mu.Unlock()
+func (b *CircuitBreaker) record(failed, slow bool, now time.Time) {
+	if failed {
+		b.lastFailureAt = now
+	}
 
-	b.lastFailureAt = time.Now()
+	if b.forced {
+		return
+	}
 
 	switch b.state {
 	case HalfOpen:
-		b.state = Open
-		b.failures = b.maxFailures
+		b.halfOpenInFlight = max(b.halfOpenInFlight-1, 0)
+
+		if failed || slow {
+			b.open(now)
+			return
+		}
+
+		b.halfOpenPassed++
+
+		if b.halfOpenPassed >= b.cfg.HalfOpenProbes {
+			b.close()
+		}
 	case Closed:
+		if b.rateBased() {
+			b.window.add(failed, slow, now)
+
+			if b.rateExceeded(b.window.stats(now)) {
+				b.open(now)
+			}
+
+			return
+		}
+
+		if !failed {
+			b.failures = 0
+			return
+		}
+
 		b.failures++
 
-		if b.failures >= b.maxFailures {
-			b.state = Open
+		if b.failures >= b.cfg.MaxFailures {
+			b.open(now)
 		}
 	}
 }
 
-func (b *CircuitBreaker) OnSuccess() {
-	b.mu.Lock()
-	defer b.mu.Unlock()
+func (b *CircuitBreaker) open(now time.Time) {
+	b.state = Open
+	b.openedAt = now
+	b.failures = b.cfg.MaxFailures
+}
 
-	switch b.state {
-	case HalfOpen:
-		b.state = Closed
-		b.failures = 0
-	case Closed:
-		b.failures = 0
+func (b *CircuitBreaker) close() {
+	b.state = Closed
+	b.failures = 0
+	b.halfOpenInFlight = 0
+	b.halfOpenPassed = 0
+
+	if b.window != nil {
+		b.window.reset()
 	}
 }
 
-func (b *CircuitBreaker) State() State {
-	b.mu.Lock()
-	defer b.mu.Unlock()
+func (b *CircuitBreaker) rateBased() bool {
+	return b.cfg.FailureRateThreshold > 0 || b.cfg.SlowCallRateThreshold > 0
+}
 
-	return b.state
+func (b *CircuitBreaker) isSlow(duration time.Duration) bool {
+	return b.cfg.SlowCallDuration > 0 && duration >= b.cfg.SlowCallDuration
+}
+
+func (b *CircuitBreaker) rateExceeded(c counts) bool {
+	if c.total == 0 || c.total < b.cfg.MinimumRequests {
+		return false
+	}
+
+	total := float64(c.total)
+
+	if b.cfg.FailureRateThreshold > 0 && float64(c.failures)/total*100 >= b.cfg.FailureRateThreshold {
+		return true
+	}
+
+	return b.cfg.SlowCallRateThreshold > 0 && float64(c.slow)/total*100 >= b.cfg.SlowCallRateThreshold
+}
+
+func (b *CircuitBreaker) notify(from, to State) {
+	if from != to && b.cfg.OnStateChange != nil {
+		b.cfg.OnStateChange(from, to)
+	}
 }
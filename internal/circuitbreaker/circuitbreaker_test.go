@@ -0,0 +1,208 @@
+package circuitbreaker
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCircuitBreaker_ConsecutiveFailures(t *testing.T) {
+	b := New(2, time.Hour)
+
+	b.OnFailure()
+	b.OnSuccess()
+	b.OnFailure()
+
+	if b.State() != Closed {
+		t.Errorf("expected closed after non-consecutive failures, got %s", b.State())
+	}
+
+	b.OnFailure()
+
+	if b.State() != Open {
+		t.Errorf("expected open, got %s", b.State())
+	}
+
+	if b.Allow() {
+		t.Errorf("expected open breaker to reject calls")
+	}
+}
+
+func TestCircuitBreaker_FailureRate(t *testing.T) {
+	b := NewWithConfig(Config{
+		ResetTimeout:         time.Hour,
+		FailureRateThreshold: 50,
+		WindowSize:           4,
+		MinimumRequests:      4,
+	})
+
+	b.Record(true, 0)
+	b.Record(true, 0)
+	b.Record(false, 0)
+
+	if b.State() != Closed {
+		t.Errorf("expected closed below minimum requests, got %s", b.State())
+	}
+
+	b.Record(false, 0)
+
+	if b.State() != Open {
+		t.Errorf("expected open at 50%% failures, got %s", b.State())
+	}
+}
+
+func TestCircuitBreaker_SlowCallRate(t *testing.T) {
+	b := NewWithConfig(Config{
+		ResetTimeout:          time.Hour,
+		SlowCallRateThreshold: 50,
+		SlowCallDuration:      time.Second,
+		WindowType:            WindowTime,
+		WindowDuration:        time.Minute,
+		MinimumRequests:       4,
+	})
+
+	b.Record(false, 2*time.Second)
+
+	if b.State() != Closed {
+		t.Errorf("expected closed below minimum requests, got %s", b.State())
+	}
+
+	b.Record(false, 10*time.Millisecond)
+	b.Record(false, 10*time.Millisecond)
+	b.Record(false, 10*time.Millisecond)
+	b.Record(false, 2*time.Second)
+
+	if b.State() != Closed {
+		t.Errorf("expected closed at 40%% slow calls, got %s", b.State())
+	}
+
+	b.Record(false, 2*time.Second)
+
+	if b.State() != Open {
+		t.Errorf("expected open at 50%% slow calls, got %s", b.State())
+	}
+}
+
+func TestNewWithConfig_WindowDefaults(t *testing.T) {
+	if b := NewWithConfig(Config{FailureRateThreshold: 50, WindowType: WindowTime}); b.cfg.WindowDuration != defaultWindowDuration {
+		t.Errorf("expected default window duration, got %s", b.cfg.WindowDuration)
+	}
+
+	if b := NewWithConfig(Config{FailureRateThreshold: 50}); b.cfg.WindowSize != defaultWindowSize {
+		t.Errorf("expected default window size, got %d", b.cfg.WindowSize)
+	}
+}
+
+func TestCircuitBreaker_HalfOpenProbes(t *testing.T) {
+	var transitions []State
+
+	b := NewWithConfig(Config{
+		MaxFailures:    1,
+		ResetTimeout:   time.Millisecond,
+		HalfOpenProbes: 2,
+		OnStateChange: func(_, to State) {
+			transitions = append(transitions, to)
+		},
+	})
+
+	b.OnFailure()
+	time.Sleep(5 * time.Millisecond)
+
+	if !b.Allow() || !b.Allow() {
+		t.Fatalf("expected two half-open probes to be allowed")
+	}
+
+	if b.Allow() {
+		t.Errorf("expected third half-open probe to be rejected")
+	}
+
+	b.OnSuccess()
+
+	if b.State() != HalfOpen {
+		t.Errorf("expected half-open after one successful probe, got %s", b.State())
+	}
+
+	b.OnSuccess()
+
+	if b.State() != Closed {
+		t.Errorf("expected closed after all probes succeeded, got %s", b.State())
+	}
+
+	expected := []State{Open, HalfOpen, Closed}
+	if len(transitions) != len(expected) {
+		t.Fatalf("expected transitions %v, got %v", expected, transitions)
+	}
+
+	for i := range expected {
+		if transitions[i] != expected[i] {
+			t.Errorf("expected transitions %v, got %v", expected, transitions)
+		}
+	}
+}
+
+func TestCircuitBreaker_HalfOpenFailureReopens(t *testing.T) {
+	b := New(1, time.Millisecond)
+
+	b.OnFailure()
+	time.Sleep(5 * time.Millisecond)
+
+	if !b.Allow() {
+		t.Fatalf("expected half-open probe to be allowed")
+	}
+
+	b.OnFailure()
+
+	if b.State() != Open {
+		t.Errorf("expected open after failed probe, got %s", b.State())
+	}
+}
+
+func TestCircuitBreaker_Release(t *testing.T) {
+	b := New(1, time.Millisecond)
+
+	b.OnFailure()
+	time.Sleep(5 * time.Millisecond)
+
+	if !b.Allow() {
+		t.Fatalf("expected half-open probe to be allowed")
+	}
+
+	b.Release()
+
+	if b.State() != HalfOpen {
+		t.Errorf("expected half-open after released probe, got %s", b.State())
+	}
+
+	if !b.Allow() {
+		t.Errorf("expected released probe slot to be reusable")
+	}
+}
+
+func TestCircuitBreaker_Force(t *testing.T) {
+	b := New(1, time.Millisecond)
+
+	b.ForceOpen()
+	time.Sleep(5 * time.Millisecond)
+
+	if b.Allow() {
+		t.Errorf("expected forced open breaker to reject calls after reset timeout")
+	}
+
+	if s := b.Snapshot(); !s.Forced || s.State != Open || !s.NextRetryAt.IsZero() {
+		t.Errorf("unexpected snapshot of forced open breaker: %+v", s)
+	}
+
+	b.ForceClose()
+	b.OnFailure()
+	b.OnFailure()
+
+	if !b.Allow() || b.State() != Closed {
+		t.Errorf("expected forced closed breaker to ignore failures, got %s", b.State())
+	}
+
+	b.Reset()
+	b.OnFailure()
+
+	if s := b.Snapshot(); s.Forced || s.State != Open || s.NextRetryAt.IsZero() {
+		t.Errorf("expected reset breaker to open on failure, got %+v", s)
+	}
+}
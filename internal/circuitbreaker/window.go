@@ -0,0 +1,132 @@
+package circuitbreaker
+
+import "time"
+
+// window aggregates call outcomes over a rolling window.
+type window interface {
+	add(failed, slow bool, now time.Time)
+	stats(now time.Time) counts
+	reset()
+}
+
+type counts struct {
+	total    int
+	failures int
+	slow     int
+}
+
+type outcome struct {
+	failed bool
+	slow   bool
+}
+
+// countWindow keeps outcomes of the last size calls.
+type countWindow struct {
+	outcomes []outcome
+	next     int
+	filled   bool
+}
+
+func newCountWindow(size int) *countWindow {
+	return &countWindow{
+		outcomes: make([]outcome, size),
+	}
+}
+
+func (w *countWindow) add(failed, slow bool, _ time.Time) {
+	w.outcomes[w.next] = outcome{failed: failed, slow: slow}
+	w.next = (w.next + 1) % len(w.outcomes)
+
+	if w.next == 0 {
+		w.filled = true
+	}
+}
+
+func (w *countWindow) stats(_ time.Time) counts {
+	n := w.next
+	if w.filled {
+		n = len(w.outcomes)
+	}
+
+	var c counts
+
+	for _, o := range w.outcomes[:n] {
+		c.total++
+
+		if o.failed {
+			c.failures++
+		}
+
+		if o.slow {
+			c.slow++
+		}
+	}
+
+	return c
+}
+
+func (w *countWindow) reset() {
+	w.next = 0
+	w.filled = false
+}
+
+// timeWindow keeps outcomes of calls made during the last duration, bucketed by second.
+type timeWindow struct {
+	buckets []timeBucket
+}
+
+type timeBucket struct {
+	second int64
+	counts counts
+}
+
+func newTimeWindow(duration time.Duration) *timeWindow {
+	return &timeWindow{
+		buckets: make([]timeBucket, max(int((duration+time.Second-1)/time.Second), 1)),
+	}
+}
+
+func (w *timeWindow) add(failed, slow bool, now time.Time) {
+	second := now.Unix()
+	bucket := &w.buckets[second%int64(len(w.buckets))]
+
+	if bucket.second != second {
+		bucket.second = second
+		bucket.counts = counts{}
+	}
+
+	bucket.counts.total++
+
+	if failed {
+		bucket.counts.failures++
+	}
+
+	if slow {
+		bucket.counts.slow++
+	}
+}
+
+func (w *timeWindow) stats(now time.Time) counts {
+	var (
+		c      counts
+		oldest = now.Unix() - int64(len(w.buckets)) + 1
+	)
+
+	for _, bucket := range w.buckets {
+		if bucket.second < oldest || bucket.second > now.Unix() {
+			continue
+		}
+
+		c.total += bucket.counts.total
+		c.failures += bucket.counts.failures
+		c.slow += bucket.counts.slow
+	}
+
+	return c
+}
+
+func (w *timeWindow) reset() {
+	for i := range w.buckets {
+		w.buckets[i] = timeBucket{}
+	}
+}
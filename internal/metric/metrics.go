@@ -10,9 +10,17 @@ const (
 	FailReasonNoMatchedRoute  FailReason = "no_matched_route"
 	FailReasonPolicyViolation FailReason = "policy_violation"
 	FailReasonBodyTooLarge    FailReason = "body_too_large"
+	FailReasonBodyReadError   FailReason = "body_read_error"
 	FailReasonUnknown         FailReason = "unknown"
 )
 
+// CircuitBreakerStats is a snapshot of an upstream circuit breaker.
+type CircuitBreakerStats struct {
+	State       int // 0 - closed, 1 - open, 2 - half-open.
+	Failures    int
+	NextRetryAt time.Time
+}
+
 type Metrics interface {
 	IncRequestsTotal()
 	UpdateRequestsDuration(time.Time)
@@ -20,4 +28,12 @@ type Metrics interface {
 	IncRequestsInFlight()
 	DecRequestsInFlight()
 	IncFailedRequestsTotal(FailReason)
+	IncHedgedRequestsTotal(upstream string)
+	IncHedgedWinsTotal(upstream string)
+	IncCircuitBreakerTransitionsTotal(upstream, state string)
+	RegisterCircuitBreaker(upstream string, stats func() CircuitBreakerStats)
+	IncCacheHitsTotal(upstream string)
+	IncCacheMissesTotal(upstream string)
+	IncWebSocketConnections(route string)
+	DecWebSocketConnections(route string)
 }
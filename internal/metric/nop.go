@@ -12,10 +12,18 @@ func NewNop() Metrics {
 	return &nopMetrics{}
 }
 
-func (m *nopMetrics) IncRequestsTotal()                   {}
-func (m *nopMetrics) UpdateRequestsDuration(_ time.Time)  {}
-func (m *nopMetrics) IncResponsesTotal(_ int)             {}
-func (m *nopMetrics) IncRequestsInFlight()                {}
-func (m *nopMetrics) DecRequestsInFlight()                {}
-func (m *nopMetrics) IncFailedRequestsTotal(_ FailReason) {}
-func (m *nopMetrics) IncCounter(_ string, _ ...zap.Field) {}
+func (m *nopMetrics) IncRequestsTotal()                                             {}
+func (m *nopMetrics) UpdateRequestsDuration(_ time.Time)                            {}
+func (m *nopMetrics) IncResponsesTotal(_ int)                                       {}
+func (m *nopMetrics) IncRequestsInFlight()                                          {}
+func (m *nopMetrics) DecRequestsInFlight()                                          {}
+func (m *nopMetrics) IncFailedRequestsTotal(_ FailReason)                           {}
+func (m *nopMetrics) IncHedgedRequestsTotal(_ string)                               {}
+func (m *nopMetrics) IncHedgedWinsTotal(_ string)                                   {}
+func (m *nopMetrics) IncCircuitBreakerTransitionsTotal(_, _ string)                 {}
+func (m *nopMetrics) RegisterCircuitBreaker(_ string, _ func() CircuitBreakerStats) {}
+func (m *nopMetrics) IncCacheHitsTotal(_ string)                                    {}
+func (m *nopMetrics) IncCacheMissesTotal(_ string)                                  {}
+func (m *nopMetrics) IncWebSocketConnections(_ string)                              {}
+func (m *nopMetrics) DecWebSocketConnections(_ string)                              {}
+func (m *nopMetrics) IncCounter(_ string, _ ...zap.Field)                           {}
@@ -1,6 +1,7 @@
 package metric
 
 import (
+	"fmt"
 	"strconv"
 	"time"
 
@@ -35,6 +36,7 @@ func NewVictoria() Metrics {
 			FailReasonUpstreamError:   metrics.NewCounter(`tokka_failed_requests_total{reason="upstream_error"}`),
 			FailReasonNoMatchedRoute:  metrics.NewCounter(`tokka_failed_requests_total{reason="no_matched_route"}`),
 			FailReasonBodyTooLarge:    metrics.NewCounter(`tokka_failed_requests_total{reason="body_too_large"}`),
+			FailReasonBodyReadError:   metrics.NewCounter(`tokka_failed_requests_total{reason="body_read_error"}`),
 			FailReasonPolicyViolation: metrics.NewCounter(`tokka_failed_requests_total{reason="policy_violation"}`),
 			FailReasonUnknown:         metrics.NewCounter(`tokka_failed_requests_total{reason="unknown"}`),
 		},
@@ -74,3 +76,56 @@ func (m *victoriaMetrics) IncFailedRequestsTotal(reason FailReason) {
 
 	m.FailedRequestsTotal[reason].Inc()
 }
+
+func (m *victoriaMetrics) IncHedgedRequestsTotal(upstream string) {
+	metrics.GetOrCreateCounter(fmt.Sprintf(`tokka_hedged_requests_total{upstream=%q}`, upstream)).Inc()
+}
+
+func (m *victoriaMetrics) IncHedgedWinsTotal(upstream string) {
+	metrics.GetOrCreateCounter(fmt.Sprintf(`tokka_hedged_wins_total{upstream=%q}`, upstream)).Inc()
+}
+
+func (m *victoriaMetrics) IncCacheHitsTotal(upstream string) {
+	metrics.GetOrCreateCounter(fmt.Sprintf(`tokka_cache_hits_total{upstream=%q}`, upstream)).Inc()
+}
+
+func (m *victoriaMetrics) IncCacheMissesTotal(upstream string) {
+	metrics.GetOrCreateCounter(fmt.Sprintf(`tokka_cache_misses_total{upstream=%q}`, upstream)).Inc()
+}
+
+// IncWebSocketConnections counts an accepted WebSocket connection and increments the number of open ones.
+func (m *victoriaMetrics) IncWebSocketConnections(route string) {
+	metrics.GetOrCreateCounter(fmt.Sprintf(`tokka_websocket_connections_total{route=%q}`, route)).Inc()
+	metrics.GetOrCreateGauge(fmt.Sprintf(`tokka_websocket_connections{route=%q}`, route), nil).Inc()
+}
+
+func (m *victoriaMetrics) DecWebSocketConnections(route string) {
+	metrics.GetOrCreateGauge(fmt.Sprintf(`tokka_websocket_connections{route=%q}`, route), nil).Dec()
+}
+
+func (m *victoriaMetrics) IncCircuitBreakerTransitionsTotal(upstream, state string) {
+	metrics.GetOrCreateCounter(
+		fmt.Sprintf(`tokka_circuit_breaker_transitions_total{upstream=%q,state=%q}`, upstream, state),
+	).Inc()
+}
+
+// RegisterCircuitBreaker exports the circuit breaker state, failures and next retry time as gauges.
+// If several breakers share the upstream name, the first registered one is exported.
+func (m *victoriaMetrics) RegisterCircuitBreaker(upstream string, stats func() CircuitBreakerStats) {
+	metrics.GetOrCreateGauge(fmt.Sprintf(`tokka_circuit_breaker_state{upstream=%q}`, upstream), func() float64 {
+		return float64(stats().State)
+	})
+
+	metrics.GetOrCreateGauge(fmt.Sprintf(`tokka_circuit_breaker_failures{upstream=%q}`, upstream), func() float64 {
+		return float64(stats().Failures)
+	})
+
+	metrics.GetOrCreateGauge(fmt.Sprintf(`tokka_circuit_breaker_next_retry_timestamp_seconds{upstream=%q}`, upstream), func() float64 {
+		next := stats().NextRetryAt
+		if next.IsZero() {
+			return 0
+		}
+
+		return float64(next.Unix())
+	})
+}
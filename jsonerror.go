@@ -2,40 +2,148 @@ package tokka
 
 import (
 	"encoding/json"
+	"fmt"
 	"net/http"
+	"sync/atomic"
 )
 
 type JSONResponse struct {
 	Data   json.RawMessage `json:"data,omitempty"`
 	Errors []JSONError     `json:"errors,omitempty"`
+	Meta   *JSONMeta       `json:"meta,omitempty"`
+}
+
+type JSONMeta struct {
+	Fallback bool `json:"fallback,omitempty"` // Some data comes from upstream fallbacks and may be degraded.
 }
 
 type JSONError struct {
 	Code      string `json:"code"`
 	Message   string `json:"message"`
 	RequestID string `json:"request_id,omitempty"`
+	Upstream  string `json:"upstream,omitempty"` // Alias of the failed upstream.
 }
 
 const (
 	ErrorCodeRateLimitExceeded   = "RATE_LIMIT_EXCEEDED"
+	ErrorCodeRouteNotFound       = "ROUTE_NOT_FOUND"
+	ErrorCodeUnauthorized        = "UNAUTHORIZED"
 	ErrorCodePayloadTooLarge     = "PAYLOAD_TOO_LARGE"
+	ErrorCodeBodyReadFailed      = "BODY_READ_FAILED"
+	ErrorCodeUpgradeRequired     = "UPGRADE_REQUIRED"
 	ErrorCodeUpstreamUnavailable = "UPSTREAM_UNAVAILABLE"
 	ErrorCodeUpstreamError       = "UPSTREAM_ERROR"
 	ErrorCodeUpstreamMalformed   = "UPSTREAM_MALFORMED"
+	ErrorCodeUpstreamGraphQL     = "UPSTREAM_GRAPHQL_ERROR"
+	ErrorCodeUpstreamCircuitOpen = "UPSTREAM_CIRCUIT_OPEN"
+	ErrorCodeUpstreamTooLarge    = "UPSTREAM_RESPONSE_TOO_LARGE"
+	ErrorCodeUpstreamReadFailed  = "UPSTREAM_READ_FAILED"
+	ErrorCodeUpstreamCanceled    = "UPSTREAM_CANCELED"
+	ErrorCodePolicyViolation     = "UPSTREAM_POLICY_VIOLATION"
+	ErrorCodeDependencyFailed    = "UPSTREAM_DEPENDENCY_FAILED"
 	ErrorCodeInternal            = "INTERNAL"
 )
 
+// Error formats of gateway errors.
+const (
+	ErrorFormatJSON    = "json"    // JSONError objects (default).
+	ErrorFormatProblem = "problem" // RFC 9457 Problem Details.
+)
+
+const problemContentType = "application/problem+json"
+
+// ProblemDetails is an RFC 9457 problem document. Code, RequestID and Errors are extension members.
+type ProblemDetails struct {
+	Type      string      `json:"type"`
+	Title     string      `json:"title"`
+	Status    int         `json:"status"`
+	Detail    string      `json:"detail,omitempty"`
+	Instance  string      `json:"instance,omitempty"`
+	Code      string      `json:"code,omitempty"`
+	RequestID string      `json:"request_id,omitempty"`
+	Errors    []JSONError `json:"errors,omitempty"` // Upstream errors of failed routes.
+}
+
+// problemDetails reports whether errors are written as Problem Details. It is global, so middlewares
+// loaded from plugins use the same format as the gateway.
+var problemDetails atomic.Bool
+
+// SetErrorFormat sets the format of errors written by the gateway and WriteError.
+func SetErrorFormat(format string) error {
+	switch format {
+	case "", ErrorFormatJSON:
+		problemDetails.Store(false)
+	case ErrorFormatProblem:
+		problemDetails.Store(true)
+	default:
+		return fmt.Errorf("unknown error format %q", format)
+	}
+
+	return nil
+}
+
+// ProblemDetailsEnabled reports whether errors are written as Problem Details. Middlewares keeping their own
+// error bodies in the default format use it to switch to WriteRequestError.
+func ProblemDetailsEnabled() bool {
+	return problemDetails.Load()
+}
+
+// newProblem returns the problem document of an error. Problems have no specific types, so the title is
+// the status text.
+func newProblem(code, message, requestID, instance string, status int) ProblemDetails {
+	return ProblemDetails{
+		Type:      "about:blank",
+		Title:     http.StatusText(status),
+		Status:    status,
+		Detail:    message,
+		Instance:  instance,
+		Code:      code,
+		RequestID: requestID,
+	}
+}
+
+// WriteError writes the error in the configured error format.
 func WriteError(w http.ResponseWriter, code, message, requestID string, status int) {
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(status)
+	writeError(w, code, message, requestID, "", status)
+}
+
+// WriteRequestError writes the error of the request in the configured error format. The request ID is taken
+// from the X-Request-ID header and Problem Details refer to the request path as the instance.
+func WriteRequestError(w http.ResponseWriter, req *http.Request, code, message string, status int) {
+	writeError(w, code, message, req.Header.Get("X-Request-ID"), req.URL.Path, status)
+}
+
+// failureBody returns the body and the content type of a failed route response with the given errors.
+// Problem Details take the code and the detail from the first error and list all of them.
+func failureBody(errs []JSONError, requestID, instance string, status int) ([]byte, string) {
+	if !problemDetails.Load() || len(errs) == 0 {
+		return mustMarshal(JSONResponse{Errors: errs}), "application/json; charset=utf-8"
+	}
 
-	jsonError := JSONError{
+	problem := newProblem(errs[0].Code, errs[0].Message, requestID, instance, status)
+	problem.Errors = errs
+
+	return mustMarshal(problem), problemContentType
+}
+
+func writeError(w http.ResponseWriter, code, message, requestID, instance string, status int) {
+	var body any = JSONError{
 		Code:      code,
 		Message:   message,
 		RequestID: requestID,
 	}
 
-	if err := json.NewEncoder(w).Encode(jsonError); err != nil {
+	contentType := "application/json"
+
+	if problemDetails.Load() {
+		body = newProblem(code, message, requestID, instance, status)
+		contentType = problemContentType
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	w.WriteHeader(status)
+
+	if err := json.NewEncoder(w).Encode(body); err != nil {
 		// Fallback on error.
 		http.Error(w, http.StatusText(status), status)
 	}
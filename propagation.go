@@ -0,0 +1,127 @@
+package tokka
+
+import (
+	"net/http"
+	"slices"
+	"strings"
+)
+
+// nonPropagatedHeaders are upstream response headers which are never passed to the client, because the gateway
+// sets them for its own response.
+var nonPropagatedHeaders = []string{
+	"Connection",
+	"Content-Encoding",
+	"Content-Length",
+	"Content-Type",
+	"Keep-Alive",
+	"Trailer",
+	"Transfer-Encoding",
+	"Upgrade",
+	"X-Request-Id",
+	FallbackHeader,
+	PartialHeader,
+}
+
+// propagatedStatus returns the status of the status upstream response. It returns 200 if the status is not
+// propagated, the status upstream failed or was not called, or its status does not allow the JSON body of
+// the route response.
+func (r *Route) propagatedStatus(responses []UpstreamResponse) int {
+	i := r.statusUpstreamIndex()
+	if !r.Propagate.Status || i < 0 || responses[i].Err != nil || !statusAllowsBody(responses[i].Status) {
+		return http.StatusOK
+	}
+
+	return responses[i].Status
+}
+
+// statusAllowsBody reports whether a response with the status may have a body (RFC 9110, section 6.4.1).
+func statusAllowsBody(status int) bool {
+	return status >= http.StatusOK && status != http.StatusNoContent && status != http.StatusNotModified
+}
+
+// statusUpstreamIndex returns the index of the status upstream: the configured one or the single upstream
+// of the route. It returns -1 if there is none.
+func (r *Route) statusUpstreamIndex() int {
+	if r.Propagate.StatusUpstream == "" {
+		if len(r.Upstreams) == 1 {
+			return 0
+		}
+
+		return -1
+	}
+
+	return slices.IndexFunc(r.Upstreams, func(u Upstream) bool {
+		return u.Name() == r.Propagate.StatusUpstream
+	})
+}
+
+// propagateHeaders copies the allowed headers of successful upstream responses to the client response header.
+// Responses are visited starting with the status upstream, then in the route order. Set-Cookie values of all
+// of them are kept, other headers are taken from the first response which has them.
+func (r *Route) propagateHeaders(responses []UpstreamResponse, header http.Header) {
+	if len(r.Propagate.Headers) == 0 {
+		return
+	}
+
+	order := make([]int, 0, len(responses))
+	if i := r.statusUpstreamIndex(); i >= 0 {
+		order = append(order, i)
+	}
+
+	for i := range responses {
+		if !slices.Contains(order, i) {
+			order = append(order, i)
+		}
+	}
+
+	propagated := make(map[string]bool)
+
+	for _, i := range order {
+		resp := responses[i]
+		if resp.Err != nil || resp.Skipped {
+			continue
+		}
+
+		for name, values := range resp.Headers {
+			if slices.Contains(nonPropagatedHeaders, name) || !matchHeader(r.Propagate.Headers, name) {
+				continue
+			}
+
+			if name == "Set-Cookie" {
+				header[name] = append(header[name], values...)
+				continue
+			}
+
+			if propagated[name] {
+				continue
+			}
+
+			header[name] = slices.Clone(values)
+			propagated[name] = true
+		}
+	}
+}
+
+// matchHeader reports whether the canonical header name matches any of the patterns: "*", a prefix like
+// "X-*" or an exact name.
+func matchHeader(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		if pattern == "*" {
+			return true
+		}
+
+		if prefix, ok := strings.CutSuffix(pattern, "*"); ok {
+			if strings.HasPrefix(name, prefix) {
+				return true
+			}
+
+			continue
+		}
+
+		if http.CanonicalHeaderKey(pattern) == name {
+			return true
+		}
+	}
+
+	return false
+}
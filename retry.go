@@ -0,0 +1,129 @@
+package tokka
+
+import (
+	"math/rand/v2"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+)
+
+const (
+	backoffFixed       = "fixed"
+	backoffExponential = "exponential"
+
+	jitterNone         = "none"
+	jitterFull         = "full"
+	jitterDecorrelated = "decorrelated"
+
+	defaultBackoffMultiplier = 2
+	decorrelatedJitterFactor = 3
+
+	// defaultMaxRetryAfter caps Retry-After delays of policies without max_backoff_delay.
+	defaultMaxRetryAfter = 10 * time.Second
+)
+
+// isIdempotent reports whether requests with the given method are idempotent according to RFC 9110.
+func isIdempotent(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace, http.MethodPut, http.MethodDelete:
+		return true
+	default:
+		return false
+	}
+}
+
+// allowsMethod reports whether requests with the given method can be retried.
+func (p UpstreamRetryPolicy) allowsMethod(method string) bool {
+	return p.RetryNonIdempotent || isIdempotent(method)
+}
+
+// shouldRetry reports whether the upstream response is worth retrying.
+func (p UpstreamRetryPolicy) shouldRetry(resp *UpstreamResponse) bool {
+	if resp.Err != nil {
+		switch resp.Err.Kind { //nolint:exhaustive // other kinds are retryable
+		case UpstreamCanceled, UpstreamCircuitOpen, UpstreamBodyTooLarge, UpstreamGraphQLError:
+			return false
+		default:
+			return true
+		}
+	}
+
+	return slices.Contains(p.RetryOnStatuses, resp.Status)
+}
+
+// backoff returns the delay before the retry following the given attempt (starting from 0).
+// The previous delay is used by the decorrelated jitter.
+func (p UpstreamRetryPolicy) backoff(attempt int, previous time.Duration) time.Duration {
+	base := p.BackoffDelay
+	if base <= 0 {
+		return 0
+	}
+
+	delay := base
+
+	if p.Backoff == backoffExponential {
+		multiplier := p.BackoffMultiplier
+		if multiplier <= 1 {
+			multiplier = defaultBackoffMultiplier
+		}
+
+		for range attempt {
+			delay = time.Duration(float64(delay) * multiplier)
+
+			if p.MaxBackoffDelay > 0 && delay >= p.MaxBackoffDelay {
+				break
+			}
+		}
+	}
+
+	switch p.Jitter {
+	case jitterFull:
+		delay = rand.N(delay + 1) //nolint:gosec // jitter does not need a secure random
+	case jitterDecorrelated:
+		upper := max(previous*decorrelatedJitterFactor, base)
+		delay = base + rand.N(upper-base+1) //nolint:gosec // jitter does not need a secure random
+	}
+
+	if p.MaxBackoffDelay > 0 {
+		delay = min(delay, p.MaxBackoffDelay)
+	}
+
+	return delay
+}
+
+// maxRetryAfter returns the longest Retry-After delay which is waited for before a retry.
+func (p UpstreamRetryPolicy) maxRetryAfter() time.Duration {
+	if p.MaxBackoffDelay > 0 {
+		return p.MaxBackoffDelay
+	}
+
+	return defaultMaxRetryAfter
+}
+
+// retryAfter returns the delay requested by the Retry-After header of 429 and 503 responses.
+func retryAfter(resp *UpstreamResponse) (time.Duration, bool) {
+	if resp.Status != http.StatusTooManyRequests && resp.Status != http.StatusServiceUnavailable {
+		return 0, false
+	}
+
+	value := resp.Headers.Get("Retry-After")
+	if value == "" {
+		return 0, false
+	}
+
+	if seconds, err := strconv.Atoi(value); err == nil {
+		if seconds < 0 {
+			return 0, false
+		}
+
+		return time.Duration(seconds) * time.Second, true
+	}
+
+	date, err := http.ParseTime(value)
+	if err != nil {
+		return 0, false
+	}
+
+	return max(time.Until(date), 0), true
+}
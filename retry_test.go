@@ -0,0 +1,162 @@
+package tokka
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestUpstreamRetryPolicy_Backoff(t *testing.T) {
+	policy := UpstreamRetryPolicy{
+		BackoffDelay:    100 * time.Millisecond,
+		Backoff:         backoffExponential,
+		MaxBackoffDelay: time.Second,
+	}
+
+	want := []time.Duration{
+		100 * time.Millisecond,
+		200 * time.Millisecond,
+		400 * time.Millisecond,
+		800 * time.Millisecond,
+		time.Second,
+		time.Second,
+	}
+
+	for attempt, w := range want {
+		if got := policy.backoff(attempt, 0); got != w {
+			t.Errorf("attempt %d: got %s, want %s", attempt, got, w)
+		}
+	}
+
+	policy.Jitter = jitterFull
+	for attempt := range 10 {
+		if got := policy.backoff(attempt, 0); got < 0 || got > policy.MaxBackoffDelay {
+			t.Errorf("full jitter delay %s out of bounds", got)
+		}
+	}
+
+	policy.Jitter = jitterDecorrelated
+	previous := time.Duration(0)
+	for attempt := range 10 {
+		got := policy.backoff(attempt, previous)
+		if got < policy.BackoffDelay || got > policy.MaxBackoffDelay {
+			t.Errorf("decorrelated jitter delay %s out of bounds", got)
+		}
+
+		previous = got
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	resp := &UpstreamResponse{
+		Status:  http.StatusTooManyRequests,
+		Headers: http.Header{"Retry-After": []string{"2"}},
+	}
+
+	if got, ok := retryAfter(resp); !ok || got != 2*time.Second {
+		t.Errorf("got %s %v, want 2s", got, ok)
+	}
+
+	resp.Headers.Set("Retry-After", time.Now().Add(time.Hour).UTC().Format(http.TimeFormat))
+	if got, ok := retryAfter(resp); !ok || got < 59*time.Minute {
+		t.Errorf("got %s %v, want about an hour", got, ok)
+	}
+
+	resp.Status = http.StatusInternalServerError
+	if _, ok := retryAfter(resp); ok {
+		t.Errorf("Retry-After must be ignored for status 500")
+	}
+}
+
+func TestHttpUpstream_Call_Retries(t *testing.T) {
+	var attempts atomic.Int32
+
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		if attempts.Add(1) == 1 {
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(http.StatusServiceUnavailable)
+
+			return
+		}
+
+		w.Write([]byte(`{}`))
+	}))
+	defer upstream.Close()
+
+	u := &httpUpstream{url: upstream.URL, timeout: time.Second, client: http.DefaultClient}
+
+	policy := UpstreamRetryPolicy{
+		MaxRetries:        3,
+		BackoffDelay:      time.Minute,
+		RespectRetryAfter: true,
+	}
+
+	t.Run("retry after and stop on success", func(t *testing.T) {
+		attempts.Store(0)
+
+		resp := u.Call(t.Context(), httptest.NewRequest(http.MethodGet, "/", nil), nil, policy)
+		if resp.Err != nil {
+			t.Fatalf("unexpected error: %v", resp.Err)
+		}
+
+		if got := attempts.Load(); got != 2 {
+			t.Errorf("expected 2 attempts, got %d", got)
+		}
+	})
+
+	t.Run("non-idempotent method", func(t *testing.T) {
+		attempts.Store(0)
+
+		u.Call(t.Context(), httptest.NewRequest(http.MethodPost, "/", nil), nil, policy)
+
+		if got := attempts.Load(); got != 1 {
+			t.Errorf("expected 1 attempt, got %d", got)
+		}
+	})
+
+	t.Run("exhausted budget", func(t *testing.T) {
+		attempts.Store(0)
+
+		budgeted := *u
+		budgeted.retryBudget = newBudget(0.1, 0)
+
+		budgeted.Call(t.Context(), httptest.NewRequest(http.MethodGet, "/", nil), nil, policy)
+
+		if got := attempts.Load(); got != 1 {
+			t.Errorf("expected 1 attempt, got %d", got)
+		}
+	})
+}
+
+func TestHttpUpstream_Call_RetryAfterCap(t *testing.T) {
+	var attempts atomic.Int32
+
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		attempts.Add(1)
+		w.Header().Set("Retry-After", "3600")
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer upstream.Close()
+
+	u := &httpUpstream{url: upstream.URL, timeout: time.Second, client: http.DefaultClient}
+
+	// No max_backoff_delay: the default cap applies.
+	policy := UpstreamRetryPolicy{MaxRetries: 3, RespectRetryAfter: true}
+
+	start := time.Now()
+
+	resp := u.Call(t.Context(), httptest.NewRequest(http.MethodGet, "/", nil), nil, policy)
+	if resp.Err == nil || resp.Err.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("expected the 503 response, got %+v", resp.Err)
+	}
+
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("expected no wait, took %s", elapsed)
+	}
+
+	if got := attempts.Load(); got != 1 {
+		t.Errorf("expected 1 attempt, got %d", got)
+	}
+}
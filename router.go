@@ -3,9 +3,12 @@ package tokka
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
+	"slices"
+	"strings"
 	"time"
 
 	"go.uber.org/zap"
@@ -14,6 +17,17 @@ import (
 	"github.com/starwalkn/tokka/internal/ratelimit"
 )
 
+const (
+	responseModeEnvelope = "envelope"
+	responseModeRaw      = "raw"
+
+	// PartialHeader marks raw responses whose upstream errors were dropped, since raw bodies cannot carry them.
+	PartialHeader = "X-Tokka-Partial"
+)
+
+// rawResponseHeaders are headers describing the upstream body, which are passed to the client with raw responses.
+var rawResponseHeaders = []string{"Content-Type", "Content-Encoding", "Content-Disposition", "Content-Language"}
+
 type Router struct {
 	dispatcher dispatcher
 	aggregator aggregator
@@ -28,9 +42,15 @@ type Router struct {
 type Route struct {
 	Path                 string
 	Method               string
+	Mode                 string // Buffered (default), stream or websocket.
+	ResponseMode         string // Envelope (default) or raw.
 	Upstreams            []Upstream
 	Aggregation          AggregationConfig
 	MaxParallelUpstreams int64
+	MaxBodySize          int64 // Maximum request body size in bytes. Zero means the default, negative means unlimited.
+	WebSocket            WebSocketConfig
+	SSE                  SSEConfig
+	Propagate            PropagationConfig // Upstream status and headers passed to the client.
 	Plugins              []Plugin
 	Middlewares          []Middleware
 }
@@ -41,6 +61,7 @@ type RouterConfigSet struct {
 	Middlewares []MiddlewareConfig
 	Features    []FeatureConfig
 	Metrics     MetricsConfig
+	ErrorFormat string
 }
 
 func NewRouter(routerConfigSet RouterConfigSet, log *zap.Logger) *Router {
@@ -51,17 +72,22 @@ func NewRouter(routerConfigSet RouterConfigSet, log *zap.Logger) *Router {
 		metricsConfig           = routerConfigSet.Metrics
 	)
 
-	router := initMinimalRouter(len(routeConfigs), log)
+	if err := SetErrorFormat(routerConfigSet.ErrorFormat); err != nil {
+		log.Fatal("invalid error format", zap.Error(err))
+	}
+
+	metrics := metric.NewNop()
 
 	if metricsConfig.Enabled {
+		//nolint:gocritic // for the future
 		switch metricsConfig.Provider {
 		case "victoriametrics":
-			router.metrics = metric.NewVictoria()
-		default:
-			router.metrics = metric.NewNop()
+			metrics = metric.NewVictoria()
 		}
 	}
 
+	router := initMinimalRouter(len(routeConfigs), metrics, log)
+
 	for _, fcfg := range featureConfigs {
 		//nolint:gocritic // for the future
 		switch fcfg.Name {
@@ -79,7 +105,7 @@ func NewRouter(routerConfigSet RouterConfigSet, log *zap.Logger) *Router {
 	globalMiddlewareIndices, globalMiddlewares := initGlobalMiddlewares(globalMiddlewareConfigs, log)
 
 	for _, rcfg := range routeConfigs {
-		router.Routes = append(router.Routes, initRoute(rcfg, globalMiddlewares, globalMiddlewareIndices, log))
+		router.Routes = append(router.Routes, initRoute(rcfg, globalMiddlewares, globalMiddlewareIndices, router.metrics, log))
 	}
 
 	return router
@@ -105,9 +131,10 @@ func NewRouter(routerConfigSet RouterConfigSet, log *zap.Logger) *Router {
 //
 // Status code determination:
 //
-// - 200 OK: all upstreams succeeded, no errors.
+// - 200 OK: all upstreams succeeded, no errors. The status of the status upstream if it is propagated.
 // - 206 Partial Content: allowPartialResults=true, at least one upstream failed.
-// - 500 Internal Server Error: allowPartialResults=false, at least one upstream failed.
+// - 500 Internal Server Error: allowPartialResults=false, at least one upstream failed. The status of
+// the matching error mapping rule if it sets one.
 //
 // The final response always includes a JSON body with `data` and `errors` fields, and a `X-Request-ID` header.
 func (r *Router) ServeHTTP(w http.ResponseWriter, req *http.Request) {
@@ -126,21 +153,29 @@ func (r *Router) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 		}
 
 		if !r.rateLimiter.Allow(ip) {
-			WriteError(w, ErrorCodeRateLimitExceeded, "rate limit exceeded", req.Header.Get("X-Request-ID"), http.StatusTooManyRequests)
+			WriteRequestError(w, req, ErrorCodeRateLimitExceeded, "rate limit exceeded", http.StatusTooManyRequests)
 			return
 		}
 	}
 
-	matchedRoute := r.match(req)
+	matchedRoute, pathParams := r.match(req)
 	if matchedRoute == nil {
 		r.log.Error("no route matched", zap.String("request_uri", req.URL.RequestURI()))
 		r.metrics.IncFailedRequestsTotal(metric.FailReasonNoMatchedRoute)
 
-		http.NotFound(w, req)
+		if problemDetails.Load() {
+			WriteRequestError(w, req, ErrorCodeRouteNotFound, "route not found", http.StatusNotFound)
+		} else {
+			http.NotFound(w, req)
+		}
 
 		return
 	}
 
+	if len(pathParams) > 0 {
+		req = req.WithContext(withPathParams(req.Context(), pathParams))
+	}
+
 	var routeHandler http.Handler = http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
 		tctx := newContext(req) // Tokka context.
 
@@ -157,13 +192,21 @@ func (r *Router) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 			p.Execute(tctx)
 		}
 
-		// Upstream dispatch.
-		responses := r.dispatcher.dispatch(matchedRoute, req)
-		if responses == nil {
-			// Currently, responses can only be nil if the body size limit is exceeded or body read fails.
-			r.log.Error("request body too large", zap.Int("max_body_size", maxBodySize))
-			WriteError(w, ErrorCodePayloadTooLarge, "request body too large", requestID, http.StatusRequestEntityTooLarge)
+		// Stream and WebSocket routes proxy the single upstream without buffering, aggregation and
+		// response-phase plugins.
+		switch matchedRoute.Mode {
+		case routeModeStream:
+			r.stream(w, req, matchedRoute, requestID)
+			return
+		case routeModeWebSocket:
+			r.websocket(w, req, matchedRoute, requestID)
+			return
+		}
 
+		// Upstream dispatch.
+		responses, err := r.dispatcher.dispatch(matchedRoute, req)
+		if err != nil {
+			r.writeBodyError(w, req, err, matchedRoute)
 			return
 		}
 
@@ -180,32 +223,56 @@ func (r *Router) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 
 		var responseBody []byte
 
+		headers := http.Header{
+			"X-Request-ID": []string{requestID},
+			"Content-Type": []string{"application/json; charset=utf-8"},
+		}
+
 		status := http.StatusOK
 		switch {
 		case len(aggregated.Errors) > 0 && !aggregated.Partial:
 			status = http.StatusInternalServerError
+			if aggregated.Status != 0 {
+				status = aggregated.Status
+			}
 
-			responseBody = mustMarshal(JSONResponse{
-				Data:   nil,
-				Errors: aggregated.Errors,
-			})
+			var contentType string
+
+			responseBody, contentType = failureBody(aggregated.Errors, requestID, req.URL.Path, status)
+			headers.Set("Content-Type", contentType)
+		case matchedRoute.ResponseMode == responseModeRaw:
+			status, responseBody = writeRawResponse(responses, headers)
+
+			matchedRoute.propagateHeaders(responses, headers)
+
+			if aggregated.Partial {
+				r.log.Warn("raw response drops upstream errors", zap.Any("errors", aggregated.Errors))
+				headers.Set(PartialHeader, "true")
+			}
 		case aggregated.Partial:
 			status = http.StatusPartialContent
 
+			matchedRoute.propagateHeaders(responses, headers)
+
 			responseBody = mustMarshal(JSONResponse{
 				Data:   aggregated.Data,
 				Errors: aggregated.Errors,
+				Meta:   aggregatedMeta(aggregated),
 			})
 		default:
+			status = matchedRoute.propagatedStatus(responses)
+
+			matchedRoute.propagateHeaders(responses, headers)
+
 			responseBody = mustMarshal(JSONResponse{
 				Data:   aggregated.Data,
 				Errors: nil,
+				Meta:   aggregatedMeta(aggregated),
 			})
 		}
 
-		headers := http.Header{
-			"X-Request-ID": []string{requestID},
-			"Content-Type": []string{"application/json; charset=utf-8"},
+		if aggregated.Fallback {
+			headers.Set(FallbackHeader, "true")
 		}
 
 		// Response-phase plugins.
@@ -240,26 +307,140 @@ func (r *Router) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 	routeHandler.ServeHTTP(w, req)
 }
 
-// match matches the given request to a route.
-func (r *Router) match(req *http.Request) *Route {
+// match matches the given request to a route and returns the path parameters captured by the route pattern.
+func (r *Router) match(req *http.Request) (*Route, map[string]string) {
 	for _, route := range r.Routes {
 		if route.Method != "" && route.Method != req.Method {
 			continue
 		}
 
-		if route.Path != "" && req.URL.Path == route.Path {
-			return &route
+		if route.Path == "" {
+			continue
+		}
+
+		if params, ok := matchPath(route.Path, req.URL.Path); ok {
+			return &route, params
 		}
 	}
 
-	return nil
+	return nil, nil
+}
+
+// matchPath matches the request path against the route path pattern.
+// Pattern segments in curly braces (e.g. /users/{id}) match any non-empty segment and are captured as parameters.
+func matchPath(pattern, path string) (map[string]string, bool) {
+	if pattern == path {
+		return nil, true
+	}
+
+	if !strings.Contains(pattern, "{") {
+		return nil, false
+	}
+
+	patternSegments := strings.Split(strings.Trim(pattern, "/"), "/")
+	pathSegments := strings.Split(strings.Trim(path, "/"), "/")
+
+	if len(patternSegments) != len(pathSegments) {
+		return nil, false
+	}
+
+	params := make(map[string]string)
+
+	for i, seg := range patternSegments {
+		if strings.HasPrefix(seg, "{") && strings.HasSuffix(seg, "}") {
+			if pathSegments[i] == "" {
+				return nil, false
+			}
+
+			params[seg[1:len(seg)-1]] = pathSegments[i]
+
+			continue
+		}
+
+		if seg != pathSegments[i] {
+			return nil, false
+		}
+	}
+
+	return params, true
+}
+
+// writeRawResponse returns the status and the body of the successful upstream response as is and copies
+// its content headers. If no upstream was called, the response has no content.
+func writeRawResponse(responses []UpstreamResponse, header http.Header) (int, []byte) {
+	header.Del("Content-Type")
+
+	i := slices.IndexFunc(responses, func(resp UpstreamResponse) bool {
+		return resp.Err == nil && !resp.Skipped
+	})
+	if i < 0 {
+		return http.StatusNoContent, nil
+	}
+
+	resp := responses[i]
+
+	for _, name := range rawResponseHeaders {
+		if values := resp.Headers.Values(name); len(values) > 0 {
+			header[name] = slices.Clone(values)
+		}
+	}
+
+	if resp.Status == 0 {
+		return http.StatusOK, resp.Body
+	}
+
+	return resp.Status, resp.Body
+}
+
+// aggregatedMeta returns the response metadata of the aggregated response, or nil if there is none.
+func aggregatedMeta(aggregated AggregatedResponse) *JSONMeta {
+	if !aggregated.Fallback {
+		return nil
+	}
+
+	return &JSONMeta{Fallback: true}
+}
+
+// bodyLimit returns the maximum request body size of the route. Negative means unlimited.
+func (r *Route) bodyLimit() int64 {
+	if r.MaxBodySize == 0 {
+		return defaultMaxBodySize
+	}
+
+	return r.MaxBodySize
+}
+
+// writeBodyError writes the error response for a request body which cannot be read, is too large or is not
+// a valid JSON for request transforms.
+func (r *Router) writeBodyError(w http.ResponseWriter, req *http.Request, err error, route *Route) {
+	if errors.Is(err, errBodyTooLarge) {
+		r.log.Warn("request body too large", zap.Int64("max_body_size", route.bodyLimit()))
+		r.metrics.IncFailedRequestsTotal(metric.FailReasonBodyTooLarge)
+		WriteRequestError(w, req, ErrorCodePayloadTooLarge, "request body too large", http.StatusRequestEntityTooLarge)
+
+		return
+	}
+
+	if errors.Is(err, errBodyInvalidJSON) {
+		r.log.Warn("invalid request body", zap.Error(err))
+		r.metrics.IncFailedRequestsTotal(metric.FailReasonBodyReadError)
+		WriteRequestError(w, req, ErrorCodeBodyReadFailed, "request body is not a valid JSON", http.StatusBadRequest)
+
+		return
+	}
+
+	r.log.Error("cannot read request body", zap.Error(err))
+	r.metrics.IncFailedRequestsTotal(metric.FailReasonBodyReadError)
+	WriteRequestError(w, req, ErrorCodeBodyReadFailed, "cannot read request body", http.StatusBadRequest)
 }
 
 // copyResponse copies the *http.Response to the http.ResponseWriter.
 func copyResponse(w http.ResponseWriter, resp *http.Response) {
 	for k, vv := range resp.Header {
+		w.Header().Del(k)
+
 		for _, v := range vv {
-			w.Header().Set(k, v)
+			w.Header().Add(k, v)
 		}
 	}
 
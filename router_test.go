@@ -31,8 +31,8 @@ type mockDispatcher struct {
 	results []UpstreamResponse
 }
 
-func (m *mockDispatcher) dispatch(_ *Route, _ *http.Request) []UpstreamResponse {
-	return m.results
+func (m *mockDispatcher) dispatch(_ *Route, _ *http.Request) ([]UpstreamResponse, error) {
+	return m.results, nil
 }
 
 type mockPlugin struct {
@@ -239,6 +239,65 @@ func TestRouter_ServeHTTP_UpstreamError(t *testing.T) {
 	}
 }
 
+func TestRouter_ServeHTTP_ProblemDetails(t *testing.T) {
+	if err := SetErrorFormat(ErrorFormatProblem); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	t.Cleanup(func() { _ = SetErrorFormat(ErrorFormatJSON) })
+
+	r := &Router{
+		dispatcher: &mockDispatcher{
+			results: []UpstreamResponse{
+				{Upstream: "order", Err: &UpstreamError{Kind: UpstreamTimeout, Err: errors.New("upstream timeout")}},
+			},
+		},
+		aggregator: &defaultAggregator{log: zap.NewNop()},
+		Routes: []Route{
+			{
+				Path:   "/test/problem",
+				Method: http.MethodGet,
+			},
+		},
+		log:     zap.NewNop(),
+		metrics: metric.NewNop(),
+	}
+
+	for _, path := range []string{"/test/problem", "/test/missing"} {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.Header.Set("X-Request-ID", "req-1")
+
+		rec := httptest.NewRecorder()
+
+		r.ServeHTTP(rec, req)
+
+		if ct := rec.Header().Get("Content-Type"); ct != "application/problem+json" {
+			t.Errorf("%s: unexpected Content-Type: %s", path, ct)
+		}
+
+		var problem ProblemDetails
+		if err := json.Unmarshal(rec.Body.Bytes(), &problem); err != nil {
+			t.Fatalf("%s: invalid problem: %v", path, err)
+		}
+
+		if problem.Status != rec.Code || problem.Title != http.StatusText(rec.Code) || problem.Instance != path || problem.RequestID != "req-1" {
+			t.Errorf("%s: unexpected problem %+v", path, problem)
+		}
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/test/problem", nil)
+	rec := httptest.NewRecorder()
+
+	r.ServeHTTP(rec, req)
+
+	var problem ProblemDetails
+	_ = json.Unmarshal(rec.Body.Bytes(), &problem)
+
+	expected := []JSONError{{Code: ErrorCodeUpstreamUnavailable, Message: "service temporarily unavailable", Upstream: "order"}}
+	if rec.Code != http.StatusInternalServerError || problem.Code != ErrorCodeUpstreamUnavailable || !reflect.DeepEqual(problem.Errors, expected) {
+		t.Errorf("unexpected problem %d %+v", rec.Code, problem)
+	}
+}
+
 func TestRouter_ServeHTTP_NoRoute(t *testing.T) {
 	r := &Router{
 		Routes:  nil,
@@ -376,3 +435,240 @@ func TestRouter_ServeHTTP_WithMiddleware(t *testing.T) {
 		t.Errorf("middleware not executed, header=%q", got)
 	}
 }
+
+func TestRouter_ServeHTTP_Propagation(t *testing.T) {
+	r := &Router{
+		dispatcher: &mockDispatcher{
+			results: []UpstreamResponse{
+				{Status: http.StatusOK, Body: []byte(`{"a":1}`), Headers: http.Header{
+					"Cache-Control": []string{"max-age=60"},
+					"Set-Cookie":    []string{"a=1"},
+					"X-Internal":    []string{"secret"},
+				}},
+				{Status: http.StatusCreated, Body: []byte(`{"b":2}`), Headers: http.Header{
+					"Cache-Control": []string{"no-store"},
+					"Content-Type":  []string{"text/plain"},
+					"Location":      []string{"/orders/1"},
+					"Set-Cookie":    []string{"b=2"},
+				}},
+			},
+		},
+		aggregator: &defaultAggregator{log: zap.NewNop()},
+		Routes: []Route{
+			{
+				Path:        "/test/propagation",
+				Method:      http.MethodPost,
+				Upstreams:   []Upstream{&httpUpstream{name: "profile"}, &httpUpstream{name: "order"}},
+				Aggregation: AggregationConfig{Strategy: strategyMerge},
+				Propagate: PropagationConfig{
+					Status:         true,
+					StatusUpstream: "order",
+					Headers:        []string{"Cache-Control", "Location", "Set-Cookie", "Content-Type"},
+				},
+			},
+		},
+		log:     zap.NewNop(),
+		metrics: metric.NewNop(),
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/test/propagation", nil)
+	rec := httptest.NewRecorder()
+
+	r.ServeHTTP(rec, req)
+
+	res := rec.Result()
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusCreated {
+		t.Fatalf("expected 201, got %d", res.StatusCode)
+	}
+
+	// The status upstream wins header conflicts, Set-Cookie values are merged.
+	if got := res.Header.Get("Cache-Control"); got != "no-store" {
+		t.Errorf("expected Cache-Control of the status upstream, got %q", got)
+	}
+
+	if got := res.Header.Get("Location"); got != "/orders/1" {
+		t.Errorf("expected Location, got %q", got)
+	}
+
+	if got := res.Header.Values("Set-Cookie"); !slices.Equal(got, []string{"b=2", "a=1"}) {
+		t.Errorf("expected merged cookies, got %v", got)
+	}
+
+	if got := res.Header.Get("X-Internal"); got != "" {
+		t.Errorf("expected X-Internal not to be propagated, got %q", got)
+	}
+
+	if got := res.Header.Get("Content-Type"); !strings.Contains(got, "application/json") {
+		t.Errorf("expected gateway Content-Type, got %q", got)
+	}
+}
+
+func TestRouter_ServeHTTP_PropagationWithoutBody(t *testing.T) {
+	for _, status := range []int{http.StatusNoContent, http.StatusNotModified} {
+		r := &Router{
+			dispatcher: &mockDispatcher{
+				results: []UpstreamResponse{{Status: status, Headers: http.Header{}}},
+			},
+			aggregator: &defaultAggregator{log: zap.NewNop()},
+			Routes: []Route{
+				{
+					Path:        "/test/propagation",
+					Method:      http.MethodGet,
+					Upstreams:   []Upstream{&httpUpstream{name: "profile"}},
+					Aggregation: AggregationConfig{Strategy: strategyMerge},
+					Propagate:   PropagationConfig{Status: true},
+				},
+			},
+			log:     zap.NewNop(),
+			metrics: metric.NewNop(),
+		}
+
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/test/propagation", nil))
+
+		if rec.Code != http.StatusOK {
+			t.Errorf("expected upstream status %d not to be propagated, got %d", status, rec.Code)
+		}
+
+		if rec.Body.Len() == 0 {
+			t.Errorf("expected JSON body for upstream status %d", status)
+		}
+	}
+}
+
+func TestRouter_ServeHTTP_RawResponsePartial(t *testing.T) {
+	r := &Router{
+		dispatcher: &mockDispatcher{
+			results: []UpstreamResponse{{
+				Status:  http.StatusOK,
+				Body:    []byte(`{"me":null}`),
+				Headers: http.Header{"Content-Type": []string{"application/json"}},
+				Errors:  []JSONError{{Code: ErrorCodeUpstreamGraphQL, Message: "not authorized"}},
+			}},
+		},
+		aggregator: &defaultAggregator{log: zap.NewNop()},
+		Routes: []Route{
+			{
+				Path:         "/test/raw",
+				Method:       http.MethodGet,
+				Upstreams:    []Upstream{&httpUpstream{name: "graph"}},
+				ResponseMode: responseModeRaw,
+			},
+		},
+		log:     zap.NewNop(),
+		metrics: metric.NewNop(),
+	}
+
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/test/raw", nil))
+
+	if rec.Code != http.StatusOK || rec.Body.String() != `{"me":null}` {
+		t.Fatalf("expected raw upstream response, got %d %s", rec.Code, rec.Body)
+	}
+
+	if got := rec.Header().Get(PartialHeader); got != "true" {
+		t.Errorf("expected %s header, got %q", PartialHeader, got)
+	}
+}
+
+func TestRouter_ServeHTTP_RawResponse(t *testing.T) {
+	csv := []byte("id,name\n1,alice\n")
+
+	r := &Router{
+		dispatcher: &mockDispatcher{
+			results: []UpstreamResponse{
+				{Status: http.StatusCreated, Body: csv, Headers: http.Header{
+					"Content-Type":        []string{"text/csv"},
+					"Content-Disposition": []string{"attachment; filename=users.csv"},
+					"X-Internal":          []string{"secret"},
+				}},
+			},
+		},
+		aggregator: &defaultAggregator{log: zap.NewNop()},
+		Routes: []Route{
+			{
+				Path:         "/test/raw",
+				Method:       http.MethodGet,
+				Upstreams:    []Upstream{&httpUpstream{name: "export"}},
+				ResponseMode: responseModeRaw,
+			},
+		},
+		log:     zap.NewNop(),
+		metrics: metric.NewNop(),
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/test/raw", nil)
+	rec := httptest.NewRecorder()
+
+	r.ServeHTTP(rec, req)
+
+	res := rec.Result()
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusCreated {
+		t.Fatalf("expected 201, got %d", res.StatusCode)
+	}
+
+	if got := rec.Body.String(); got != string(csv) {
+		t.Errorf("expected upstream body, got %q", got)
+	}
+
+	if got := res.Header.Get("Content-Type"); got != "text/csv" {
+		t.Errorf("expected upstream Content-Type, got %q", got)
+	}
+
+	if got := res.Header.Get("Content-Disposition"); got != "attachment; filename=users.csv" {
+		t.Errorf("expected upstream Content-Disposition, got %q", got)
+	}
+
+	if got := res.Header.Get("X-Internal"); got != "" {
+		t.Errorf("expected X-Internal not to be propagated, got %q", got)
+	}
+
+	// Failures keep the error format.
+	r.dispatcher = &mockDispatcher{
+		results: []UpstreamResponse{
+			{Err: &UpstreamError{Kind: UpstreamBadStatus, StatusCode: http.StatusBadGateway, Err: errors.New("bad status")}},
+		},
+	}
+
+	rec = httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/test/raw", nil))
+
+	if rec.Code != http.StatusInternalServerError {
+		t.Errorf("expected 500, got %d", rec.Code)
+	}
+
+	if got := rec.Header().Get("Content-Type"); !strings.Contains(got, "application/json") {
+		t.Errorf("expected JSON error, got %q", got)
+	}
+}
+
+func TestMatchPath(t *testing.T) {
+	tests := []struct {
+		pattern, path string
+		wantOK        bool
+		wantParams    map[string]string
+	}{
+		{pattern: "/users", path: "/users", wantOK: true},
+		{pattern: "/users", path: "/users/1", wantOK: false},
+		{pattern: "/users/{id}", path: "/users/42", wantOK: true, wantParams: map[string]string{"id": "42"}},
+		{pattern: "/users/{id}/orders/{order}", path: "/users/1/orders/2", wantOK: true, wantParams: map[string]string{"id": "1", "order": "2"}},
+		{pattern: "/users/{id}", path: "/users/", wantOK: false},
+		{pattern: "/users/{id}", path: "/accounts/42", wantOK: false},
+	}
+
+	for _, tt := range tests {
+		params, ok := matchPath(tt.pattern, tt.path)
+		if ok != tt.wantOK {
+			t.Errorf("matchPath(%q, %q) ok = %v, want %v", tt.pattern, tt.path, ok, tt.wantOK)
+			continue
+		}
+
+		if len(tt.wantParams) > 0 && !reflect.DeepEqual(params, tt.wantParams) {
+			t.Errorf("matchPath(%q, %q) params = %v, want %v", tt.pattern, tt.path, params, tt.wantParams)
+		}
+	}
+}
@@ -0,0 +1,115 @@
+package tokka
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+	"time"
+)
+
+const eventStreamContentType = "text/event-stream"
+
+// sseHeartbeat is an SSE comment line. Clients ignore it, but it keeps idle connections open through
+// proxies and reveals disconnected clients while the upstream is silent.
+var sseHeartbeat = []byte(": heartbeat\n\n")
+
+// isEventStream reports whether the response is a Server-Sent Events stream.
+func isEventStream(h http.Header) bool {
+	mediaType, _, err := mime.ParseMediaType(h.Get("Content-Type"))
+
+	return err == nil && mediaType == eventStreamContentType
+}
+
+// copyEventStream copies the event stream from src to w and flushes every chunk, so each event is delivered
+// as soon as the upstream sends it. If nothing is received for the heartbeat interval, a heartbeat is sent
+// between events. Zero heartbeat disables heartbeats.
+func copyEventStream(w http.ResponseWriter, src io.Reader, heartbeat time.Duration) error {
+	if heartbeat <= 0 {
+		return copyFlushing(w, src)
+	}
+
+	var (
+		chunks = make(chan []byte)
+		errs   = make(chan error, 1)
+		done   = make(chan struct{})
+	)
+	defer close(done)
+
+	go func() {
+		for {
+			buf := make([]byte, streamBufferSize)
+
+			n, err := src.Read(buf)
+			if n > 0 {
+				select {
+				case chunks <- buf[:n]:
+				case <-done:
+					return
+				}
+			}
+
+			if err != nil {
+				errs <- err
+				return
+			}
+		}
+	}()
+
+	rc := http.NewResponseController(w)
+
+	ticker := time.NewTicker(heartbeat)
+	defer ticker.Stop()
+
+	// The last bytes written tell whether the stream is between events. It is, before the first event.
+	tail := []byte("\n\n")
+
+	write := func(p []byte) error {
+		if _, err := w.Write(p); err != nil {
+			return err
+		}
+
+		if err := rc.Flush(); err != nil && !errors.Is(err, http.ErrNotSupported) {
+			return err
+		}
+
+		return nil
+	}
+
+	for {
+		select {
+		case chunk := <-chunks:
+			if err := write(chunk); err != nil {
+				return err
+			}
+
+			tail = append(tail, chunk...)
+			tail = tail[max(0, len(tail)-4):] //nolint:mnd // the longest event delimiter
+
+			ticker.Reset(heartbeat)
+		case <-ticker.C:
+			if !isEventBoundary(tail) {
+				continue
+			}
+
+			if err := write(sseHeartbeat); err != nil {
+				return err
+			}
+		case err := <-errs:
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+
+			return err
+		}
+	}
+}
+
+// isEventBoundary reports whether the stream ending with tail is between events, i.e. the last event
+// is terminated by a blank line.
+func isEventBoundary(tail []byte) bool {
+	return bytes.HasSuffix(tail, []byte("\n\n")) ||
+		bytes.HasSuffix(tail, []byte("\r\r")) ||
+		bytes.HasSuffix(tail, []byte("\r\n\r\n"))
+}
@@ -0,0 +1,113 @@
+package tokka
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/starwalkn/tokka/internal/metric"
+)
+
+func newSSERouter(url string, heartbeat time.Duration) *Router {
+	router := initMinimalRouter(1, metric.NewNop(), zap.NewNop())
+	router.Routes = append(router.Routes, Route{
+		Path:   "/events",
+		Method: http.MethodGet,
+		Mode:   routeModeStream,
+		Upstreams: []Upstream{
+			&httpUpstream{
+				name:    "events",
+				url:     url,
+				timeout: time.Second,
+				client:  http.DefaultClient,
+			},
+		},
+		SSE: SSEConfig{HeartbeatInterval: heartbeat},
+	})
+
+	return router
+}
+
+func TestRouter_Stream_EventStream(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream; charset=utf-8")
+
+		_, _ = io.WriteString(w, "data: 1\n\n")
+		http.NewResponseController(w).Flush()
+
+		time.Sleep(100 * time.Millisecond)
+
+		_, _ = io.WriteString(w, "data: 2\n\n")
+	}))
+	defer upstream.Close()
+
+	gateway := httptest.NewServer(newSSERouter(upstream.URL, 20*time.Millisecond))
+	defer gateway.Close()
+
+	resp, err := http.Get(gateway.URL + "/events")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer resp.Body.Close()
+
+	body, _ := io.ReadAll(resp.Body)
+
+	if !strings.HasPrefix(string(body), "data: 1\n\n") || !strings.HasSuffix(string(body), "data: 2\n\n") {
+		t.Errorf("expected events to be passed as is, got %q", body)
+	}
+
+	if !strings.Contains(string(body), ": heartbeat\n\n") {
+		t.Errorf("expected heartbeat between events, got %q", body)
+	}
+
+	if resp.Header.Get("X-Accel-Buffering") != "no" {
+		t.Errorf("expected proxy buffering to be disabled, got %v", resp.Header)
+	}
+}
+
+func TestRouter_Stream_EventStreamClientDisconnect(t *testing.T) {
+	canceled := make(chan struct{})
+
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+
+		_, _ = io.WriteString(w, "data: 1\n\n")
+		http.NewResponseController(w).Flush()
+
+		<-r.Context().Done()
+		close(canceled)
+	}))
+	defer upstream.Close()
+
+	gateway := httptest.NewServer(newSSERouter(upstream.URL, 0))
+	defer gateway.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, gateway.URL+"/events", nil)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if line, _ := bufio.NewReader(resp.Body).ReadString('\n'); line != "data: 1\n" {
+		t.Errorf("expected first event, got %q", line)
+	}
+
+	cancel()
+
+	select {
+	case <-canceled:
+	case <-time.After(time.Second):
+		t.Errorf("expected upstream request to be canceled after client disconnect")
+	}
+}
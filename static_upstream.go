@@ -0,0 +1,164 @@
+package tokka
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+)
+
+var errStaticFailure = errors.New("injected static upstream failure")
+
+// staticUpstream answers with a configured response without network I/O. It is an HTTP upstream with
+// a static transport, so retries, circuit breakers, caching and other policies apply as usual.
+type staticUpstream struct {
+	*httpUpstream
+}
+
+// Call makes the original request available to the body template and calls the upstream.
+func (u *staticUpstream) Call(ctx context.Context, original *http.Request, originalBody []byte, retryPolicy UpstreamRetryPolicy) *UpstreamResponse {
+	ctx = withTemplateScope(ctx, templateScopeFrom(ctx).withRequest(original, originalBody))
+
+	return u.httpUpstream.Call(ctx, original, originalBody, retryPolicy)
+}
+
+// requestKey reports that static responses are not cached, since they are built without network I/O and
+// their bodies may reference any part of the original request.
+func (u *staticUpstream) requestKey(context.Context, *http.Request, []byte, []string) (string, http.Header, bool) {
+	return "", nil, false
+}
+
+// staticTransport is an http.RoundTripper which answers every request with a configured response.
+type staticTransport struct {
+	status  int
+	headers map[string]string
+	body    any    // JSON body which is marshaled after templating.
+	text    string // Text body, used if body is nil.
+
+	latency       time.Duration
+	failureRate   float64 // Fraction of requests which fail, from 0 to 1.
+	failureStatus int     // Status of failed requests. Zero means a connection error.
+}
+
+func newStaticTransport(cfg UpstreamStaticConfig) (*staticTransport, error) {
+	t := &staticTransport{
+		status:        cfg.Status,
+		headers:       cfg.Headers,
+		latency:       cfg.Latency,
+		failureRate:   cfg.FailureRate,
+		failureStatus: cfg.FailureStatus,
+	}
+
+	if cfg.FailureRate < 0 || cfg.FailureRate > 1 {
+		return nil, errors.New("failure_rate must be between 0 and 1")
+	}
+
+	if cfg.Body != nil && cfg.BodyFile != "" {
+		return nil, errors.New("body and body_file are mutually exclusive")
+	}
+
+	switch body := cfg.Body.(type) {
+	case nil:
+	case string:
+		t.text = body
+	default:
+		t.body = body
+	}
+
+	if cfg.BodyFile != "" {
+		b, err := os.ReadFile(cfg.BodyFile)
+		if err != nil {
+			return nil, fmt.Errorf("cannot read body file: %w", err)
+		}
+
+		t.text = string(b)
+	}
+
+	return t, nil
+}
+
+func (t *staticTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Body != nil {
+		_ = req.Body.Close()
+	}
+
+	if t.latency > 0 {
+		timer := time.NewTimer(t.latency)
+		defer timer.Stop()
+
+		select {
+		case <-req.Context().Done():
+			return nil, req.Context().Err()
+		case <-timer.C:
+		}
+	}
+
+	status := t.status
+
+	if t.failureRate > 0 && rand.Float64() < t.failureRate { //nolint:gosec // failure injection does not need a secure random
+		if t.failureStatus == 0 {
+			return nil, errStaticFailure
+		}
+
+		status = t.failureStatus
+	}
+
+	header := make(http.Header, len(t.headers)+1)
+
+	body, err := t.render(req.Context(), header)
+	if err != nil {
+		return nil, err
+	}
+
+	for k, v := range t.headers {
+		header.Set(k, v)
+	}
+
+	return &http.Response{
+		Status:        strconv.Itoa(status) + " " + http.StatusText(status),
+		StatusCode:    status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          io.NopCloser(bytes.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Request:       req,
+	}, nil
+}
+
+// render resolves the body template against the scope of the request context. JSON bodies set the
+// default Content-Type.
+func (t *staticTransport) render(ctx context.Context, header http.Header) ([]byte, error) {
+	scope := templateScopeFrom(ctx)
+
+	if t.body == nil {
+		text, err := resolveTemplate(t.text, scope, nil)
+		if err != nil {
+			return nil, err
+		}
+
+		return []byte(text), nil
+	}
+
+	resolved, err := resolveTemplateValue(t.body, scope)
+	if err != nil {
+		return nil, err
+	}
+
+	body, err := json.Marshal(resolved)
+	if err != nil {
+		return nil, fmt.Errorf("cannot marshal static body: %w", err)
+	}
+
+	header.Set("Content-Type", "application/json")
+
+	return body, nil
+}
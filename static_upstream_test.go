@@ -0,0 +1,95 @@
+package tokka
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/starwalkn/tokka/internal/metric"
+)
+
+func TestStaticUpstream_Call(t *testing.T) {
+	bodyFile := filepath.Join(t.TempDir(), "user.txt")
+	if err := os.WriteFile(bodyFile, []byte("user ${path.id}"), 0o600); err != nil {
+		t.Fatalf("cannot write body file: %v", err)
+	}
+
+	upstreams := initUpstreams([]UpstreamConfig{
+		{
+			Name:    "json",
+			Timeout: time.Second,
+			Static: UpstreamStaticConfig{
+				Enabled: true,
+				Status:  http.StatusCreated,
+				Headers: map[string]string{"X-Stub": "true"},
+				Body:    map[string]any{"id": "${path.id}", "limit": "${query.limit}", "tags": []any{"a", "${query.tag}"}},
+			},
+		},
+		{
+			Name:    "file",
+			Timeout: time.Second,
+			Static:  UpstreamStaticConfig{Enabled: true, Status: http.StatusOK, BodyFile: bodyFile},
+		},
+		{
+			Name:    "failing",
+			Timeout: time.Second,
+			Static:  UpstreamStaticConfig{Enabled: true, Status: http.StatusOK, FailureRate: 1, FailureStatus: http.StatusServiceUnavailable},
+		},
+	}, metric.NewNop(), zap.NewNop())
+
+	req := httptest.NewRequest(http.MethodGet, "http://example.com/users/42?limit=10&tag=b", nil)
+	req = req.WithContext(withPathParams(req.Context(), map[string]string{"id": "42"}))
+
+	resp := upstreams[0].Call(req.Context(), req, nil, UpstreamRetryPolicy{})
+	if resp.Err != nil {
+		t.Fatalf("unexpected error: %v", resp.Err.Unwrap())
+	}
+
+	if resp.Status != http.StatusCreated || resp.Headers.Get("X-Stub") != "true" || resp.Headers.Get("Content-Type") != "application/json" {
+		t.Errorf("unexpected response %d %v", resp.Status, resp.Headers)
+	}
+
+	if string(resp.Body) != `{"id":"42","limit":"10","tags":["a","b"]}` {
+		t.Errorf("unexpected body %s", resp.Body)
+	}
+
+	resp = upstreams[1].Call(req.Context(), req, nil, UpstreamRetryPolicy{})
+	if resp.Err != nil || string(resp.Body) != "user 42" {
+		t.Errorf("expected templated body file, got %s (%v)", resp.Body, resp.Err)
+	}
+
+	resp = upstreams[2].Call(req.Context(), req, nil, UpstreamRetryPolicy{})
+	if resp.Err == nil || resp.Err.Kind != UpstreamBadStatus || resp.Err.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("expected injected bad status, got %+v", resp.Err)
+	}
+}
+
+func TestStaticTransport_Latency(t *testing.T) {
+	transport, err := newStaticTransport(UpstreamStaticConfig{Status: http.StatusOK, Latency: time.Second, FailureRate: 1})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	u := &staticUpstream{httpUpstream: &httpUpstream{
+		name:    "slow",
+		timeout: 20 * time.Millisecond,
+		client:  &http.Client{Transport: transport},
+	}}
+
+	req := httptest.NewRequest(http.MethodGet, "http://example.com/", nil)
+
+	resp := u.Call(req.Context(), req, nil, UpstreamRetryPolicy{})
+	if resp.Err == nil || resp.Err.Kind != UpstreamTimeout {
+		t.Errorf("expected timeout, got %+v", resp.Err)
+	}
+
+	if _, err = newStaticTransport(UpstreamStaticConfig{FailureRate: 2}); err == nil || !strings.Contains(err.Error(), "failure_rate") {
+		t.Errorf("expected failure rate error, got %v", err)
+	}
+}
@@ -0,0 +1,252 @@
+package tokka
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/starwalkn/tokka/internal/metric"
+)
+
+const (
+	routeModeBuffered = "buffered"
+	routeModeStream   = "stream"
+
+	streamBufferSize = 32 * 1024
+)
+
+// hopHeaders are hop-by-hop headers which are not forwarded by proxies (RFC 9110, section 7.6.1).
+var hopHeaders = []string{
+	"Connection",
+	"Keep-Alive",
+	"Proxy-Authenticate",
+	"Proxy-Authorization",
+	"Proxy-Connection",
+	"Te",
+	"Trailer",
+	"Transfer-Encoding",
+	"Upgrade",
+}
+
+// stream proxies the request to the single route upstream without buffering request and response bodies.
+// The upstream status and headers are preserved. Bodies are copied as they arrive, so slow clients slow
+// down reading from the upstream and vice versa.
+func (r *Router) stream(w http.ResponseWriter, req *http.Request, route *Route, requestID string) {
+	u, ok := route.Upstreams[0].(*httpUpstream)
+	if !ok {
+		r.log.Error("stream mode requires an http upstream", zap.String("route", route.Method+" "+route.Path))
+		WriteRequestError(w, req, ErrorCodeInternal, "internal error", http.StatusInternalServerError)
+
+		return
+	}
+
+	if limit := route.bodyLimit(); limit >= 0 {
+		if req.ContentLength > limit {
+			r.writeBodyError(w, req, errBodyTooLarge, route)
+			return
+		}
+
+		req.Body = http.MaxBytesReader(w, req.Body, limit)
+	}
+
+	hresp, uerr := u.openStream(req.Context(), req, nil)
+	if uerr != nil {
+		r.log.Error("upstream stream failed", zap.String("name", u.name), zap.Error(uerr.Unwrap()))
+		r.metrics.IncFailedRequestsTotal(metric.FailReasonUpstreamError)
+
+		jsonErr := (&defaultAggregator{log: r.log}).mapUpstreamError(uerr)
+		WriteRequestError(w, req, jsonErr.Code, jsonErr.Message, http.StatusBadGateway)
+
+		return
+	}
+	defer hresp.Body.Close()
+
+	r.proxyResponse(w, route, u, hresp, requestID)
+}
+
+// proxyResponse writes the upstream response status and headers (except hop-by-hop ones) and copies its body.
+// Event streams are flushed after every chunk and kept alive with heartbeats when the route configures them.
+func (r *Router) proxyResponse(w http.ResponseWriter, route *Route, u *httpUpstream, hresp *http.Response, requestID string) {
+	for name, values := range hresp.Header {
+		for _, v := range values {
+			w.Header().Add(name, v)
+		}
+	}
+
+	removeHopHeaders(w.Header())
+
+	if requestID != "" {
+		w.Header().Set("X-Request-ID", requestID)
+	}
+
+	copyBody := copyFlushing
+
+	if isEventStream(hresp.Header) {
+		// Disable response buffering of proxies in front of the gateway.
+		w.Header().Set("X-Accel-Buffering", "no")
+		w.Header().Del("Content-Length")
+
+		copyBody = func(w http.ResponseWriter, src io.Reader) error {
+			return copyEventStream(w, src, route.SSE.HeartbeatInterval)
+		}
+	}
+
+	w.WriteHeader(hresp.StatusCode)
+	r.metrics.IncResponsesTotal(hresp.StatusCode)
+
+	if err := copyBody(w, hresp.Body); err != nil && !errors.Is(err, context.Canceled) {
+		r.log.Warn("stream interrupted", zap.String("name", u.name), zap.Error(err))
+	}
+}
+
+// openStream sends the request with the streamed original body to the upstream and returns the response
+// with an unread body. The upstream timeout limits the time until response headers are received.
+// The header values are set on the upstream request in addition to the forwarded ones.
+func (u *httpUpstream) openStream(ctx context.Context, original *http.Request, header http.Header) (*http.Response, *UpstreamError) {
+	if u.circuitBreaker != nil && !u.circuitBreaker.Allow() {
+		return nil, &UpstreamError{
+			Kind: UpstreamCircuitOpen,
+			Err:  errors.New("upstream circuit breaker is open"),
+		}
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+
+	req, err := u.newRequest(ctx, u.url, original, nil)
+	if err != nil {
+		cancel()
+
+		return nil, &UpstreamError{
+			Kind: UpstreamInternal,
+			Err:  err,
+		}
+	}
+
+	for name, values := range header {
+		req.Header[name] = values
+	}
+
+	// WebSocket upstreams may be configured with ws:// and wss:// URLs.
+	switch req.URL.Scheme {
+	case "ws":
+		req.URL.Scheme = "http"
+	case "wss":
+		req.URL.Scheme = "https"
+	}
+
+	if original.Body != nil && original.Body != http.NoBody {
+		req.Body = original.Body
+		req.ContentLength = original.ContentLength
+		req.GetBody = nil
+	}
+
+	start := time.Now()
+
+	// Cancel the request if response headers do not arrive in time. The timer is stopped once they do,
+	// so the body can be streamed for as long as needed.
+	headersTimer := time.AfterFunc(u.timeout, cancel)
+
+	hresp, err := u.client.Do(req) //nolint:bodyclose // closed by the caller
+	if !headersTimer.Stop() && err != nil {
+		err = errors.Join(context.DeadlineExceeded, err)
+	}
+
+	if err != nil {
+		cancel()
+
+		kind := UpstreamConnection
+
+		switch {
+		case errors.Is(err, context.DeadlineExceeded):
+			kind = UpstreamTimeout
+		case errors.Is(err, context.Canceled):
+			kind = UpstreamCanceled
+		}
+
+		uerr := &UpstreamError{
+			Kind: kind,
+			Err:  err,
+		}
+
+		if u.circuitBreaker != nil {
+			u.circuitBreaker.Record(u.isBreakerFailure(uerr), time.Since(start))
+		}
+
+		return nil, uerr
+	}
+
+	if u.circuitBreaker != nil {
+		u.circuitBreaker.Record(hresp.StatusCode >= http.StatusInternalServerError, time.Since(start))
+	}
+
+	hresp.Body = &cancelOnClose{ReadCloser: hresp.Body, cancel: cancel}
+
+	return hresp, nil
+}
+
+// cancelOnClose releases the request context when the response body is closed.
+type cancelOnClose struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+// Write writes to the connection of protocol switching (101) responses, whose body is writable.
+func (c *cancelOnClose) Write(p []byte) (int, error) {
+	w, ok := c.ReadCloser.(io.Writer)
+	if !ok {
+		return 0, errors.ErrUnsupported
+	}
+
+	return w.Write(p)
+}
+
+func (c *cancelOnClose) Close() error {
+	defer c.cancel()
+
+	return c.ReadCloser.Close()
+}
+
+// copyFlushing copies src to w and flushes every chunk, so the client receives data as soon as it is read.
+func copyFlushing(w http.ResponseWriter, src io.Reader) error {
+	rc := http.NewResponseController(w)
+	buf := make([]byte, streamBufferSize)
+
+	for {
+		n, err := src.Read(buf)
+		if n > 0 {
+			if _, werr := w.Write(buf[:n]); werr != nil {
+				return werr
+			}
+
+			if ferr := rc.Flush(); ferr != nil && !errors.Is(ferr, http.ErrNotSupported) {
+				return ferr
+			}
+		}
+
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func removeHopHeaders(h http.Header) {
+	// Headers listed in Connection are hop-by-hop as well.
+	for _, value := range h.Values("Connection") {
+		for name := range strings.SplitSeq(value, ",") {
+			h.Del(strings.TrimSpace(name))
+		}
+	}
+
+	for _, name := range hopHeaders {
+		h.Del(name)
+	}
+}
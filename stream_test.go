@@ -0,0 +1,106 @@
+package tokka
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/starwalkn/tokka/internal/metric"
+)
+
+func TestRouter_Stream(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+
+		w.Header().Set("X-Upstream", "yes")
+		w.Header().Set("Connection", "X-Hop")
+		w.Header().Set("X-Hop", "secret")
+		w.WriteHeader(http.StatusCreated)
+
+		_, _ = w.Write(body)
+	}))
+	defer upstream.Close()
+
+	router := initMinimalRouter(1, metric.NewNop(), zap.NewNop())
+	router.Routes = append(router.Routes, Route{
+		Path:   "/upload",
+		Method: http.MethodPost,
+		Mode:   routeModeStream,
+		Upstreams: []Upstream{
+			&httpUpstream{
+				name:    "files",
+				url:     upstream.URL,
+				timeout: time.Second,
+				client:  http.DefaultClient,
+			},
+		},
+	})
+
+	// The body has an unknown length, so it is sent chunked.
+	pr, pw := io.Pipe()
+
+	go func() {
+		for _, chunk := range []string{"hello ", "streaming ", "world"} {
+			_, _ = pw.Write([]byte(chunk))
+		}
+
+		_ = pw.Close()
+	}()
+
+	req := httptest.NewRequest(http.MethodPost, "http://example.com/upload", pr)
+	req.ContentLength = -1
+
+	w := httptest.NewRecorder()
+
+	router.ServeHTTP(w, req)
+
+	if w.Code != http.StatusCreated {
+		t.Errorf("expected status %d, got %d", http.StatusCreated, w.Code)
+	}
+
+	if w.Body.String() != "hello streaming world" {
+		t.Errorf("expected streamed body, got %q", w.Body.String())
+	}
+
+	if w.Header().Get("X-Upstream") != "yes" {
+		t.Errorf("expected upstream header to be preserved")
+	}
+
+	if w.Header().Get("X-Hop") != "" || w.Header().Get("Connection") != "" {
+		t.Errorf("expected hop-by-hop headers to be removed, got %v", w.Header())
+	}
+}
+
+func TestRouter_Stream_UpstreamUnavailable(t *testing.T) {
+	router := initMinimalRouter(1, metric.NewNop(), zap.NewNop())
+	router.Routes = append(router.Routes, Route{
+		Path:   "/download",
+		Method: http.MethodGet,
+		Mode:   routeModeStream,
+		Upstreams: []Upstream{
+			&httpUpstream{
+				name:    "files",
+				url:     "http://127.0.0.1:1",
+				timeout: time.Second,
+				client:  http.DefaultClient,
+			},
+		},
+	})
+
+	w := httptest.NewRecorder()
+
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "http://example.com/download", nil))
+
+	if w.Code != http.StatusBadGateway {
+		t.Errorf("expected status %d, got %d", http.StatusBadGateway, w.Code)
+	}
+
+	if !strings.Contains(w.Body.String(), ErrorCodeUpstreamUnavailable) {
+		t.Errorf("expected %s error, got %s", ErrorCodeUpstreamUnavailable, w.Body.String())
+	}
+}
@@ -0,0 +1,255 @@
+package tokka
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// templateRefPattern matches references like ${upstreams.order.customer_id}.
+var templateRefPattern = regexp.MustCompile(`\$\{([^}]+)\}`)
+
+const (
+	templateSourceUpstreams = "upstreams"
+	templateSourcePath      = "path"
+	templateSourceQuery     = "query"
+	templateSourceBody      = "body"
+	templateSourceHeader    = "header"
+	templateSourceClaims    = "claims"
+)
+
+type ctxKeyTemplateScope struct{}
+
+// templateScope holds the values that can be referenced from upstream templates.
+type templateScope struct {
+	// upstreams contains decoded JSON bodies of already completed upstreams by their names.
+	upstreams map[string]any
+
+	// Values of the original request, available only in scopes built by withRequest.
+	path   map[string]string
+	query  url.Values
+	header http.Header
+	claims map[string]any
+	body   any // Decoded JSON body.
+}
+
+// withRequest returns a copy of the scope which can also reference path parameters, query parameters,
+// headers, claims and the JSON body of the original request. The scope may be nil.
+func (s *templateScope) withRequest(original *http.Request, originalBody []byte) *templateScope {
+	scope := &templateScope{
+		path:   PathParams(original.Context()),
+		query:  original.URL.Query(),
+		header: original.Header,
+		claims: ClaimsFromContext(original.Context()),
+	}
+
+	if s != nil {
+		scope.upstreams = s.upstreams
+	}
+
+	// Bodies which are not a JSON cannot be referenced.
+	if len(originalBody) > 0 {
+		_ = json.Unmarshal(originalBody, &scope.body)
+	}
+
+	return scope
+}
+
+func withTemplateScope(ctx context.Context, scope *templateScope) context.Context {
+	return context.WithValue(ctx, ctxKeyTemplateScope{}, scope)
+}
+
+func templateScopeFrom(ctx context.Context) *templateScope {
+	scope, _ := ctx.Value(ctxKeyTemplateScope{}).(*templateScope)
+	return scope
+}
+
+// lookup resolves a reference like "upstreams.order.customer_id", "path.id", "query.limit", "header.X-Tenant",
+// "claims.sub" or "body.user.name".
+func (s *templateScope) lookup(ref string) (any, bool) {
+	if s == nil {
+		return nil, false
+	}
+
+	source, rest, _ := strings.Cut(ref, ".")
+
+	switch source {
+	case templateSourceUpstreams:
+		name, path, _ := strings.Cut(rest, ".")
+
+		body, ok := s.upstreams[name]
+		if !ok {
+			return nil, false
+		}
+
+		return lookupPath(body, splitPath(path))
+	case templateSourcePath:
+		v, ok := s.path[rest]
+		return v, ok
+	case templateSourceQuery:
+		if !s.query.Has(rest) {
+			return nil, false
+		}
+
+		return s.query.Get(rest), true
+	case templateSourceHeader:
+		values := s.header.Values(rest)
+		if len(values) == 0 {
+			return nil, false
+		}
+
+		return values[0], true
+	case templateSourceClaims:
+		if s.claims == nil {
+			return nil, false
+		}
+
+		return lookupPath(s.claims, splitPath(rest))
+	case templateSourceBody:
+		if s.body == nil {
+			return nil, false
+		}
+
+		return lookupPath(s.body, splitPath(rest))
+	default:
+		return nil, false
+	}
+}
+
+// hasTemplateRefs reports whether s contains at least one template reference.
+func hasTemplateRefs(s string) bool {
+	return strings.Contains(s, "${")
+}
+
+// resolveTemplate replaces all references in s with values from the scope.
+// Every substituted value is passed through escape if it is not nil.
+func resolveTemplate(s string, scope *templateScope, escape func(string) string) (string, error) {
+	if !hasTemplateRefs(s) {
+		return s, nil
+	}
+
+	var resolveErr error
+
+	resolved := templateRefPattern.ReplaceAllStringFunc(s, func(match string) string {
+		ref := strings.TrimSpace(match[2 : len(match)-1])
+
+		v, ok := scope.lookup(ref)
+		if !ok {
+			if resolveErr == nil {
+				resolveErr = fmt.Errorf("unresolved template reference %q", ref)
+			}
+
+			return match
+		}
+
+		str := stringifyValue(v)
+		if escape != nil {
+			str = escape(str)
+		}
+
+		return str
+	})
+	if resolveErr != nil {
+		return "", resolveErr
+	}
+
+	return resolved, nil
+}
+
+// resolveTemplateValue resolves references in the strings of a decoded JSON value, recursing into objects
+// and arrays. A string which consists of a single reference is replaced with the referenced value as is,
+// so numbers, booleans and objects keep their types, and with nil if the value does not exist.
+func resolveTemplateValue(v any, scope *templateScope) (any, error) {
+	switch val := v.(type) {
+	case string:
+		if m := templateRefPattern.FindStringSubmatchIndex(val); m != nil && m[0] == 0 && m[1] == len(val) {
+			resolved, _ := scope.lookup(strings.TrimSpace(val[m[2]:m[3]]))
+			return resolved, nil
+		}
+
+		return resolveTemplate(val, scope, nil)
+	case map[string]any:
+		resolved := make(map[string]any, len(val))
+
+		for k, item := range val {
+			r, err := resolveTemplateValue(item, scope)
+			if err != nil {
+				return nil, err
+			}
+
+			resolved[k] = r
+		}
+
+		return resolved, nil
+	case []any:
+		resolved := make([]any, 0, len(val))
+
+		for _, item := range val {
+			r, err := resolveTemplateValue(item, scope)
+			if err != nil {
+				return nil, err
+			}
+
+			resolved = append(resolved, r)
+		}
+
+		return resolved, nil
+	default:
+		return v, nil
+	}
+}
+
+// templateUpstreamRefs returns the names of upstreams referenced in the strings of v, which is a string or
+// a decoded JSON value.
+func templateUpstreamRefs(v any) []string {
+	var names []string
+
+	switch val := v.(type) {
+	case string:
+		for _, m := range templateRefPattern.FindAllStringSubmatch(val, -1) {
+			source, rest, _ := strings.Cut(strings.TrimSpace(m[1]), ".")
+			if source != templateSourceUpstreams {
+				continue
+			}
+
+			name, _, _ := strings.Cut(rest, ".")
+			names = append(names, name)
+		}
+	case map[string]any:
+		for _, item := range val {
+			names = append(names, templateUpstreamRefs(item)...)
+		}
+	case []any:
+		for _, item := range val {
+			names = append(names, templateUpstreamRefs(item)...)
+		}
+	}
+
+	return names
+}
+
+// stringifyValue converts a decoded JSON value to its textual representation.
+func stringifyValue(v any) string {
+	switch val := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return val
+	case float64:
+		return strconv.FormatFloat(val, 'f', -1, 64)
+	case bool:
+		return strconv.FormatBool(val)
+	default:
+		b, err := json.Marshal(val)
+		if err != nil {
+			return ""
+		}
+
+		return string(b)
+	}
+}
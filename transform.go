@@ -0,0 +1,430 @@
+package tokka
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// UpstreamResponseTransform describes how an upstream response body is shaped before aggregation.
+//
+// All paths are dot-separated JSON paths (e.g. "data.items"), see lookupPath for how they cross arrays.
+// A rename is skipped if its target cannot be set. The steps are applied in the following order: extract,
+// allow, deny, rename, flatten.
+type UpstreamResponseTransform struct {
+	Extract string
+	Allow   []string
+	Deny    []string
+	Rename  map[string]string
+	Flatten []string
+}
+
+func (t UpstreamResponseTransform) enabled() bool {
+	return t.Extract != "" || len(t.Allow) > 0 || len(t.Deny) > 0 || len(t.Rename) > 0 || len(t.Flatten) > 0
+}
+
+// apply shapes the given JSON body. An empty body is returned as-is.
+func (t UpstreamResponseTransform) apply(body []byte) ([]byte, error) {
+	if len(body) == 0 {
+		return body, nil
+	}
+
+	var v any
+	if err := json.Unmarshal(body, &v); err != nil {
+		return nil, err
+	}
+
+	if t.Extract != "" {
+		v, _ = lookupPath(v, splitPath(t.Extract))
+	}
+
+	if len(t.Allow) > 0 {
+		paths := make([][]string, 0, len(t.Allow))
+		for _, p := range t.Allow {
+			paths = append(paths, splitPath(p))
+		}
+
+		v, _ = pickPaths(v, paths)
+	}
+
+	for _, p := range t.Deny {
+		v = deletePath(v, splitPath(p))
+	}
+
+	// Renames are applied in sorted order of their source paths to keep results deterministic.
+	for _, from := range slices.Sorted(maps.Keys(t.Rename)) {
+		v = movePath(v, splitPath(from), splitPath(t.Rename[from]))
+	}
+
+	for _, p := range t.Flatten {
+		flattenPath(v, splitPath(p))
+	}
+
+	return json.Marshal(v)
+}
+
+// UpstreamRequestTransform describes how the original request body is shaped before it is sent to an upstream.
+//
+// All paths are dot-separated JSON paths, see lookupPath. A rename is skipped if its target cannot be set,
+// while a value of Set which cannot be set fails the transform. Values of Set and Template may reference the original request and
+// responses of upstream dependencies (e.g. "${header.X-Tenant}", "${claims.sub}" or "${path.id}"). The steps
+// are applied in the following order: rename, remove, set. A Template replaces the body instead.
+type UpstreamRequestTransform struct {
+	Rename   map[string]string
+	Remove   []string
+	Set      map[string]any
+	Template any // JSON value, or text if it is a string.
+}
+
+func (t UpstreamRequestTransform) enabled() bool {
+	return len(t.Rename) > 0 || len(t.Remove) > 0 || len(t.Set) > 0 || t.Template != nil
+}
+
+// apply shapes the given JSON body. An empty body is shaped as an empty object.
+func (t UpstreamRequestTransform) apply(body []byte, scope *templateScope) ([]byte, error) {
+	if text, ok := t.Template.(string); ok {
+		resolved, err := resolveTemplate(text, scope, nil)
+		if err != nil {
+			return nil, err
+		}
+
+		return []byte(resolved), nil
+	}
+
+	if t.Template != nil {
+		resolved, err := resolveTemplateValue(t.Template, scope)
+		if err != nil {
+			return nil, err
+		}
+
+		return json.Marshal(resolved)
+	}
+
+	v := any(make(map[string]any))
+
+	if len(body) > 0 {
+		if err := json.Unmarshal(body, &v); err != nil {
+			return nil, err
+		}
+	}
+
+	if _, ok := v.(map[string]any); !ok && len(t.Set) > 0 {
+		return nil, errors.New("request body is not a JSON object")
+	}
+
+	for _, from := range slices.Sorted(maps.Keys(t.Rename)) {
+		v = movePath(v, splitPath(from), splitPath(t.Rename[from]))
+	}
+
+	for _, p := range t.Remove {
+		v = deletePath(v, splitPath(p))
+	}
+
+	for _, p := range slices.Sorted(maps.Keys(t.Set)) {
+		val, err := resolveTemplateValue(t.Set[p], scope)
+		if err != nil {
+			return nil, err
+		}
+
+		if !setPath(v, splitPath(p), val) {
+			return nil, fmt.Errorf("cannot set %q: the path crosses a value which is not an object", p)
+		}
+	}
+
+	return json.Marshal(v)
+}
+
+func splitPath(path string) []string {
+	path = strings.Trim(path, ".")
+	if path == "" {
+		return nil
+	}
+
+	return strings.Split(path, ".")
+}
+
+// lookupPath returns the value located at path.
+//
+// All path helpers cross arrays the same way: a segment which is an index selects that element, other
+// segments are applied to every element. Helpers addressing a single value, like lookupPath and setPath,
+// find nothing on paths applied to every element.
+func lookupPath(v any, path []string) (any, bool) {
+	for _, key := range path {
+		switch node := v.(type) {
+		case map[string]any:
+			next, ok := node[key]
+			if !ok {
+				return nil, false
+			}
+
+			v = next
+		case []any:
+			idx, ok := arrayIndex(node, key)
+			if !ok {
+				return nil, false
+			}
+
+			v = node[idx]
+		default:
+			return nil, false
+		}
+	}
+
+	return v, true
+}
+
+// arrayIndex returns the index of the array element selected by the path segment.
+func arrayIndex(arr []any, key string) (int, bool) {
+	idx, err := strconv.Atoi(key)
+	if err != nil || idx < 0 || idx >= len(arr) {
+		return 0, false
+	}
+
+	return idx, true
+}
+
+// pickPaths returns a copy of v that contains only the given paths.
+func pickPaths(v any, paths [][]string) (any, bool) {
+	switch node := v.(type) {
+	case map[string]any:
+		var (
+			out      = make(map[string]any)
+			children = make(map[string][][]string)
+		)
+
+		for _, p := range paths {
+			if len(p) == 0 {
+				continue
+			}
+
+			val, ok := node[p[0]]
+			if !ok {
+				continue
+			}
+
+			if len(p) == 1 {
+				out[p[0]] = val
+				continue
+			}
+
+			children[p[0]] = append(children[p[0]], p[1:])
+		}
+
+		for key, sub := range children {
+			if _, whole := out[key]; whole {
+				continue
+			}
+
+			if picked, ok := pickPaths(node[key], sub); ok {
+				out[key] = picked
+			}
+		}
+
+		return out, true
+	case []any:
+		out := make([]any, 0, len(node))
+
+		for i, elem := range node {
+			if picked, ok := pickElement(elem, i, paths); ok {
+				out = append(out, picked)
+			}
+		}
+
+		return out, true
+	default:
+		return nil, false
+	}
+}
+
+// pickElement picks the given paths from the array element with index i. Paths selecting other elements are
+// ignored, and the element is dropped if no path applies to it.
+func pickElement(elem any, i int, paths [][]string) (any, bool) {
+	var (
+		index = strconv.Itoa(i)
+		sub   = make([][]string, 0, len(paths))
+	)
+
+	for _, p := range paths {
+		if len(p) == 0 {
+			continue
+		}
+
+		if _, err := strconv.Atoi(p[0]); err != nil {
+			sub = append(sub, p)
+			continue
+		}
+
+		if p[0] != index {
+			continue
+		}
+
+		if len(p) == 1 {
+			return elem, true
+		}
+
+		sub = append(sub, p[1:])
+	}
+
+	if len(sub) == 0 {
+		return nil, false
+	}
+
+	return pickPaths(elem, sub)
+}
+
+// deletePath removes the value located at path and returns v, which is a new slice if an element of the
+// root array is removed.
+func deletePath(v any, path []string) any {
+	if len(path) == 0 {
+		return v
+	}
+
+	switch node := v.(type) {
+	case map[string]any:
+		if len(path) == 1 {
+			delete(node, path[0])
+			return node
+		}
+
+		if child, ok := node[path[0]]; ok {
+			node[path[0]] = deletePath(child, path[1:])
+		}
+	case []any:
+		idx, ok := arrayIndex(node, path[0])
+		if !ok {
+			if _, err := strconv.Atoi(path[0]); err == nil {
+				return node
+			}
+
+			for i, elem := range node {
+				node[i] = deletePath(elem, path)
+			}
+
+			return node
+		}
+
+		if len(path) == 1 {
+			return slices.Delete(node, idx, idx+1)
+		}
+
+		node[idx] = deletePath(node[idx], path[1:])
+	}
+
+	return v
+}
+
+// setPath sets the value located at path, creating missing intermediate objects. It reports false and leaves
+// root unchanged if the path crosses a value which is neither an object nor an array with the indexed element.
+func setPath(root any, path []string, val any) bool {
+	if len(path) == 0 {
+		return false
+	}
+
+	node := root
+
+	for i, key := range path {
+		last := i == len(path)-1
+
+		switch n := node.(type) {
+		case map[string]any:
+			if last {
+				n[key] = val
+				return true
+			}
+
+			next, ok := n[key]
+			if !ok {
+				next = make(map[string]any)
+				n[key] = next
+			}
+
+			node = next
+		case []any:
+			idx, ok := arrayIndex(n, key)
+			if !ok {
+				return false
+			}
+
+			if last {
+				n[idx] = val
+				return true
+			}
+
+			node = n[idx]
+		default:
+			return false
+		}
+	}
+
+	return false
+}
+
+// movePath moves the value located at from to the path to and returns root. The value is kept in place if it
+// cannot be set at the path to.
+func movePath(root any, from, to []string) any {
+	if len(from) == 0 || len(to) == 0 {
+		return root
+	}
+
+	val, ok := lookupPath(root, from)
+	if !ok {
+		return root
+	}
+
+	switch {
+	case hasPathPrefix(from, to):
+		// The value replaces its ancestor, so there is nothing left to delete.
+		setPath(root, to, val)
+		return root
+	case hasPathPrefix(to, from):
+		// The value is nested into a new object at its own path.
+		for i := len(to) - 1; i >= len(from); i-- {
+			val = map[string]any{to[i]: val}
+		}
+
+		setPath(root, from, val)
+
+		return root
+	}
+
+	if !setPath(root, to, val) {
+		return root
+	}
+
+	return deletePath(root, from)
+}
+
+// hasPathPrefix reports whether path starts with prefix.
+func hasPathPrefix(path, prefix []string) bool {
+	return len(path) >= len(prefix) && slices.Equal(path[:len(prefix)], prefix)
+}
+
+// flattenPath hoists the fields of the object located at path into its parent object.
+// Hoisted fields override existing fields of the parent with the same name.
+func flattenPath(root any, path []string) {
+	if len(path) == 0 {
+		return
+	}
+
+	parent, ok := lookupPath(root, path[:len(path)-1])
+	if !ok {
+		return
+	}
+
+	parentObj, ok := parent.(map[string]any)
+	if !ok {
+		return
+	}
+
+	nested, ok := parentObj[path[len(path)-1]].(map[string]any)
+	if !ok {
+		return
+	}
+
+	delete(parentObj, path[len(path)-1])
+	maps.Copy(parentObj, nested)
+}
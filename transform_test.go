@@ -0,0 +1,268 @@
+package tokka
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestUpstreamResponseTransform_Apply(t *testing.T) {
+	body := []byte(`{
+		"data": {
+			"items": [
+				{"id": 1, "name": "a", "secret": "x", "meta": {"rank": 1}},
+				{"id": 2, "name": "b", "secret": "y", "meta": {"rank": 2}}
+			],
+			"total": 2
+		},
+		"debug": {"trace": "abc"}
+	}`)
+
+	tests := []struct {
+		name      string
+		transform UpstreamResponseTransform
+		want      string
+	}{
+		{
+			name:      "extract",
+			transform: UpstreamResponseTransform{Extract: "data.total"},
+			want:      `2`,
+		},
+		{
+			name:      "allow across arrays",
+			transform: UpstreamResponseTransform{Allow: []string{"data.items.id", "data.total"}},
+			want:      `{"data":{"items":[{"id":1},{"id":2}],"total":2}}`,
+		},
+		{
+			name:      "deny across arrays",
+			transform: UpstreamResponseTransform{Extract: "data", Deny: []string{"items.secret", "items.meta"}},
+			want:      `{"items":[{"id":1,"name":"a"},{"id":2,"name":"b"}],"total":2}`,
+		},
+		{
+			name: "rename and move",
+			transform: UpstreamResponseTransform{
+				Allow:  []string{"data.total", "debug"},
+				Rename: map[string]string{"data.total": "count", "debug.trace": "meta.trace_id"},
+			},
+			want: `{"count":2,"data":{},"debug":{},"meta":{"trace_id":"abc"}}`,
+		},
+		{
+			name: "flatten",
+			transform: UpstreamResponseTransform{
+				Allow:   []string{"debug"},
+				Flatten: []string{"debug"},
+			},
+			want: `{"trace":"abc"}`,
+		},
+		{
+			name:      "missing extract path",
+			transform: UpstreamResponseTransform{Extract: "data.unknown"},
+			want:      `null`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.transform.apply(body)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			var gotValue, wantValue any
+			if err = json.Unmarshal(got, &gotValue); err != nil {
+				t.Fatalf("invalid JSON result: %v", err)
+			}
+
+			if err = json.Unmarshal([]byte(tt.want), &wantValue); err != nil {
+				t.Fatalf("invalid JSON expectation: %v", err)
+			}
+
+			if !reflect.DeepEqual(gotValue, wantValue) {
+				t.Errorf("got %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestUpstreamResponseTransform_Apply_Paths(t *testing.T) {
+	tests := []struct {
+		name      string
+		transform UpstreamResponseTransform
+		body      string
+		want      string
+	}{
+		{
+			name:      "rename through a value which is not an object",
+			transform: UpstreamResponseTransform{Rename: map[string]string{"b": "a.c"}},
+			body:      `{"a":"keep","b":1}`,
+			want:      `{"a":"keep","b":1}`,
+		},
+		{
+			name:      "rename in a root array",
+			transform: UpstreamResponseTransform{Extract: "items", Rename: map[string]string{"x": "y", "0.x": "0.y"}},
+			body:      `{"items":[{"x":1},{"x":2}]}`,
+			want:      `[{"y":1},{"x":2}]`,
+		},
+		{
+			name:      "rename of an indexed element",
+			transform: UpstreamResponseTransform{Rename: map[string]string{"items.0.x": "y"}},
+			body:      `{"items":[{"x":1},{"x":2}]}`,
+			want:      `{"items":[{},{"x":2}],"y":1}`,
+		},
+		{
+			name:      "rename into a child",
+			transform: UpstreamResponseTransform{Rename: map[string]string{"a": "a.b"}},
+			body:      `{"a":1}`,
+			want:      `{"a":{"b":1}}`,
+		},
+		{
+			name:      "rename into the parent",
+			transform: UpstreamResponseTransform{Rename: map[string]string{"a.b": "a"}},
+			body:      `{"a":{"b":1,"c":2}}`,
+			want:      `{"a":1}`,
+		},
+		{
+			name:      "allow and deny indexed elements",
+			transform: UpstreamResponseTransform{Allow: []string{"items.1", "items.0.x"}, Deny: []string{"items.1.z"}},
+			body:      `{"items":[{"x":1,"z":1},{"x":2,"z":2},{"x":3}]}`,
+			want:      `{"items":[{"x":1},{"x":2}]}`,
+		},
+		{
+			name:      "deny an element of a root array",
+			transform: UpstreamResponseTransform{Deny: []string{"0"}},
+			body:      `[1,2]`,
+			want:      `[2]`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.transform.apply([]byte(tt.body))
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			var gotValue, wantValue any
+			if err = json.Unmarshal(got, &gotValue); err != nil {
+				t.Fatalf("invalid JSON result: %v", err)
+			}
+
+			if err = json.Unmarshal([]byte(tt.want), &wantValue); err != nil {
+				t.Fatalf("invalid JSON expectation: %v", err)
+			}
+
+			if !reflect.DeepEqual(gotValue, wantValue) {
+				t.Errorf("got %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestUpstreamResponseTransform_Apply_InvalidJSON(t *testing.T) {
+	transform := UpstreamResponseTransform{Allow: []string{"a"}}
+
+	if _, err := transform.apply([]byte(`not json`)); err == nil {
+		t.Errorf("expected error, got nil")
+	}
+}
+
+func TestUpstreamRequestTransform_Apply(t *testing.T) {
+	body := []byte(`{"user":{"first_name":"Ann","password":"secret"},"items":[1,2]}`)
+
+	req := httptest.NewRequest(http.MethodPost, "http://example.com/users/42?dry_run=true", nil)
+	req.Header.Set("X-Tenant", "acme")
+	req = req.WithContext(WithClaims(withPathParams(req.Context(), map[string]string{"id": "42"}), map[string]any{
+		"sub":   "u-1",
+		"roles": []any{"admin"},
+	}))
+
+	scope := templateScopeFrom(req.Context()).withRequest(req, body)
+
+	tests := []struct {
+		name      string
+		transform UpstreamRequestTransform
+		body      []byte
+		want      string
+	}{
+		{
+			name: "rename, remove and set",
+			transform: UpstreamRequestTransform{
+				Rename: map[string]string{"user.first_name": "name"},
+				Remove: []string{"user.password"},
+				Set: map[string]any{
+					"tenant":        "${header.X-Tenant}",
+					"meta.owner":    "${claims.sub}",
+					"meta.roles":    "${claims.roles}",
+					"meta.user_id":  "user-${path.id}",
+					"meta.referrer": "${header.Referer}",
+				},
+			},
+			body: body,
+			want: `{"name":"Ann","user":{},"items":[1,2],"tenant":"acme","meta":{"owner":"u-1","roles":["admin"],"user_id":"user-42","referrer":null}}`,
+		},
+		{
+			name:      "rename through a value which is not an object",
+			transform: UpstreamRequestTransform{Rename: map[string]string{"name": "user.name"}},
+			body:      []byte(`{"user":"u1","name":"n"}`),
+			want:      `{"user":"u1","name":"n"}`,
+		},
+		{
+			name:      "set on empty body",
+			transform: UpstreamRequestTransform{Set: map[string]any{"id": "${path.id}"}},
+			want:      `{"id":"42"}`,
+		},
+		{
+			name: "template",
+			transform: UpstreamRequestTransform{Template: map[string]any{
+				"customer": map[string]any{"id": "${path.id}", "name": "${body.user.first_name}"},
+				"lines":    "${body.items}",
+				"dry_run":  "${query.dry_run}",
+			}},
+			body: body,
+			want: `{"customer":{"id":"42","name":"Ann"},"lines":[1,2],"dry_run":"true"}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.transform.apply(tt.body, scope)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			var gotValue, wantValue any
+			if err = json.Unmarshal(got, &gotValue); err != nil {
+				t.Fatalf("invalid JSON result: %v", err)
+			}
+
+			if err = json.Unmarshal([]byte(tt.want), &wantValue); err != nil {
+				t.Fatalf("invalid JSON expectation: %v", err)
+			}
+
+			if !reflect.DeepEqual(gotValue, wantValue) {
+				t.Errorf("got %s, want %s", got, tt.want)
+			}
+		})
+	}
+
+	text := UpstreamRequestTransform{Template: "tenant=${header.X-Tenant}&id=${path.id}"}
+
+	got, err := text.apply(body, scope)
+	if err != nil || string(got) != "tenant=acme&id=42" {
+		t.Errorf("expected text template body, got %s (%v)", got, err)
+	}
+
+	invalid := UpstreamRequestTransform{Set: map[string]any{"id": "${path.id}"}}
+
+	if _, err = invalid.apply([]byte(`[1]`), scope); err == nil {
+		t.Errorf("expected error for a body which is not an object, got nil")
+	}
+
+	conflict := UpstreamRequestTransform{Set: map[string]any{"user.id": "${path.id}"}}
+
+	if _, err = conflict.apply([]byte(`{"user":"u1"}`), scope); err == nil {
+		t.Errorf("expected error for a path crossing a value which is not an object, got nil")
+	}
+}
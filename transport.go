@@ -0,0 +1,137 @@
+package tokka
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	unixScheme  = "unix://"
+	protocolH2C = "h2c"
+)
+
+// transportKey identifies the connectivity of upstreams. Upstreams with the same key share a transport
+// and its connection pool.
+type transportKey struct {
+	socket string // Unix socket path. Empty for TCP.
+	h2c    bool   // HTTP/2 with prior knowledge over cleartext connections.
+}
+
+// transports creates upstream transports on demand.
+type transports map[transportKey]*http.Transport
+
+func (t transports) get(key transportKey) *http.Transport {
+	if transport, ok := t[key]; ok {
+		return transport
+	}
+
+	//nolint:mnd // be configurable in future
+	transport := &http.Transport{
+		MaxIdleConns:        100,
+		MaxIdleConnsPerHost: 10,
+		IdleConnTimeout:     90 * time.Second,
+		ForceAttemptHTTP2:   true,
+	}
+
+	if key.h2c {
+		// HTTP/2 is still negotiated with TLS for https URLs.
+		protocols := new(http.Protocols)
+		protocols.SetHTTP2(true)
+		protocols.SetUnencryptedHTTP2(true)
+
+		transport.Protocols = protocols
+	}
+
+	if key.socket != "" {
+		dialer := &net.Dialer{}
+
+		transport.DialContext = func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return dialer.DialContext(ctx, "unix", key.socket)
+		}
+	}
+
+	t[key] = transport
+
+	return transport
+}
+
+// isUnixURL reports whether the upstream URL is a Unix socket URL.
+func isUnixURL(rawURL string) bool {
+	return strings.HasPrefix(rawURL, unixScheme)
+}
+
+// resolveUnixURL splits a URL like unix:///run/app.sock:/users/{id} into the socket path and the HTTP URL
+// of requests sent over the socket. The request path defaults to "/".
+func resolveUnixURL(rawURL string) (string, string, error) {
+	socket, path, _ := strings.Cut(strings.TrimPrefix(rawURL, unixScheme), ":")
+
+	if !filepath.IsAbs(socket) {
+		return "", "", fmt.Errorf("socket path of %q is not absolute", rawURL)
+	}
+
+	if path == "" {
+		path = "/"
+	}
+
+	if !strings.HasPrefix(path, "/") {
+		return "", "", fmt.Errorf("request path of %q does not start with a slash", rawURL)
+	}
+
+	return socket, "http://localhost" + path, nil
+}
+
+// resolveUnixUpstream rewrites the unix:// URLs of the upstream to HTTP URLs of requests sent over the socket
+// and returns the socket path, or an empty string for TCP upstreams. Hedging and fallback URLs must use the
+// same socket as the upstream, because they share its transport.
+func resolveUnixUpstream(cfg *UpstreamConfig) (string, error) {
+	if !isUnixURL(cfg.URL) {
+		return "", nil
+	}
+
+	socket, target, err := resolveUnixURL(cfg.URL)
+	if err != nil {
+		return "", err
+	}
+
+	cfg.URL = target
+
+	resolve := func(rawURL string) (string, error) {
+		s, t, err := resolveUnixURL(rawURL)
+		if err != nil {
+			return "", err
+		}
+
+		if s != socket {
+			return "", errors.New("hedging and fallback urls must use the upstream socket")
+		}
+
+		return t, nil
+	}
+
+	hedgingURLs := make([]string, 0, len(cfg.Policy.HedgingConfig.URLs))
+
+	for _, u := range cfg.Policy.HedgingConfig.URLs {
+		t, err := resolve(u)
+		if err != nil {
+			return "", err
+		}
+
+		hedgingURLs = append(hedgingURLs, t)
+	}
+
+	cfg.Policy.HedgingConfig.URLs = hedgingURLs
+
+	if cfg.Policy.FallbackConfig.URL != "" {
+		if cfg.Policy.FallbackConfig.URL, err = resolve(cfg.Policy.FallbackConfig.URL); err != nil {
+			return "", err
+		}
+	}
+
+	return socket, nil
+}
@@ -0,0 +1,94 @@
+package tokka
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/starwalkn/tokka/internal/metric"
+)
+
+func TestResolveUnixURL(t *testing.T) {
+	tests := []struct {
+		url            string
+		expectedSocket string
+		expectedURL    string
+		expectedErr    bool
+	}{
+		{url: "unix:///run/app.sock", expectedSocket: "/run/app.sock", expectedURL: "http://localhost/"},
+		{url: "unix:///run/app.sock:/users/${path.id}?x=1", expectedSocket: "/run/app.sock", expectedURL: "http://localhost/users/${path.id}?x=1"},
+		{url: "unix://run/app.sock", expectedErr: true},
+		{url: "unix:///run/app.sock:users", expectedErr: true},
+	}
+
+	for _, tt := range tests {
+		socket, target, err := resolveUnixURL(tt.url)
+		if (err != nil) != tt.expectedErr {
+			t.Errorf("%s: unexpected error %v", tt.url, err)
+			continue
+		}
+
+		if socket != tt.expectedSocket || target != tt.expectedURL {
+			t.Errorf("%s: expected %s and %s, got %s and %s", tt.url, tt.expectedSocket, tt.expectedURL, socket, target)
+		}
+	}
+}
+
+func TestInitUpstreams_UnixSocketAndH2C(t *testing.T) {
+	// Socket paths are limited to ~100 bytes, which t.TempDir may exceed.
+	dir, err := os.MkdirTemp("", "tokka")
+	if err != nil {
+		t.Fatalf("cannot create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	socket := filepath.Join(dir, "app.sock")
+
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatalf("cannot listen on unix socket: %v", err)
+	}
+
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"path":"` + r.URL.Path + `","proto":"` + r.Proto + `"}`))
+	})
+
+	unixServer := &http.Server{Handler: handler, ReadHeaderTimeout: time.Second}
+	go func() { _ = unixServer.Serve(listener) }()
+	defer unixServer.Close()
+
+	h2cServer := httptest.NewUnstartedServer(handler)
+	h2cServer.Config.Protocols = new(http.Protocols)
+	h2cServer.Config.Protocols.SetUnencryptedHTTP2(true)
+	h2cServer.Start()
+	defer h2cServer.Close()
+
+	upstreams := initUpstreams([]UpstreamConfig{
+		{Name: "unix", URL: "unix://" + socket + ":/users/42", Timeout: time.Second},
+		{Name: "h2c", URL: h2cServer.URL + "/orders", Protocol: protocolH2C, Timeout: time.Second},
+	}, metric.NewNop(), zap.NewNop())
+
+	req := httptest.NewRequest(http.MethodGet, "http://example.com/", nil)
+
+	expected := []string{
+		`{"path":"/users/42","proto":"HTTP/1.1"}`,
+		`{"path":"/orders","proto":"HTTP/2.0"}`,
+	}
+
+	for i, u := range upstreams {
+		resp := u.Call(req.Context(), req, nil, UpstreamRetryPolicy{})
+		if resp.Err != nil {
+			t.Fatalf("%s: unexpected error: %v", u.Name(), resp.Err.Unwrap())
+		}
+
+		if string(resp.Body) != expected[i] {
+			t.Errorf("%s: expected body %s, got %s", u.Name(), expected[i], resp.Body)
+		}
+	}
+}
@@ -19,30 +19,59 @@ type UpstreamPolicy struct {
 	MaxResponseBodySize int64
 	RetryPolicy         UpstreamRetryPolicy
 	CircuitBreaker      UpstreamCircuitBreaker
+	Hedging             UpstreamHedgingPolicy
+	Fallback            UpstreamFallbackPolicy
+	Alias               string // Name of the upstream reported to clients. Empty if it is not configured.
+	Cache               UpstreamCachePolicy
+	Coalescing          UpstreamCoalescingPolicy
+	RequestTransform    UpstreamRequestTransform
+	ResponseTransform   UpstreamResponseTransform
+	DependsOn           []string            // Names of upstreams which must complete before this one.
+	When                []UpstreamCondition // Conditions which must hold for the upstream to be called.
 }
 
 type UpstreamRetryPolicy struct {
-	MaxRetries      int
-	RetryOnStatuses []int
-	BackoffDelay    time.Duration
+	MaxRetries         int
+	RetryOnStatuses    []int
+	BackoffDelay       time.Duration // Fixed delay or base delay of the exponential backoff.
+	Backoff            string        // Backoff strategy: fixed (default) or exponential.
+	BackoffMultiplier  float64       // Multiplier of the exponential backoff.
+	MaxBackoffDelay    time.Duration // Upper bound of the backoff delay.
+	Jitter             string        // Jitter: none (default), full or decorrelated.
+	RespectRetryAfter  bool          // Wait for the Retry-After delay of 429 and 503 responses.
+	RetryNonIdempotent bool          // Allow retries of non-idempotent methods like POST.
+	BudgetRatio        float64       // Maximum ratio of retries to requests. Zero means unlimited.
 }
 
 type UpstreamCircuitBreaker struct {
-	Enabled      bool
-	MaxFailures  int
-	ResetTimeout time.Duration
+	Enabled               bool
+	MaxFailures           int
+	ResetTimeout          time.Duration
+	FailureRateThreshold  float64       // Failure rate in percent which opens the breaker.
+	SlowCallRateThreshold float64       // Slow call rate in percent which opens the breaker.
+	SlowCallDuration      time.Duration // Calls longer than this are slow.
+	WindowType            string        // Rolling window type: count (default) or time.
+	WindowSize            int           // Number of calls in a count-based window.
+	WindowDuration        time.Duration // Duration of a time-based window.
+	MinimumRequests       int           // Minimum calls in the window before rates are evaluated.
+	HalfOpenProbes        int           // Number of trial calls in the half-open state.
 }
 
 type UpstreamResponse struct {
-	Status  int
-	Headers http.Header
-	Body    []byte
-	Err     *UpstreamError
+	Status   int
+	Headers  http.Header
+	Body     []byte
+	Err      *UpstreamError
+	Errors   []JSONError // Errors reported by the upstream in its response, e.g. GraphQL errors.
+	Skipped  bool        // The upstream was not called because its conditions did not hold.
+	Fallback bool        // The response is a fallback served instead of an upstream error.
+	Name     string      // Name of the upstream, set by the dispatcher.
+	Upstream string      // Alias of the upstream, set by the dispatcher.
 }
 
 type UpstreamError struct {
 	Kind       UpstreamErrorKind // Error kind for aggregator.
-	StatusCode int               // Only for bad statuses.
+	StatusCode int               // Only for bad statuses and policy violations.
 	Err        error             // Original error. Not for client!
 }
 
@@ -59,12 +88,32 @@ func (ue *UpstreamError) Unwrap() error {
 type UpstreamErrorKind string
 
 const (
-	UpstreamTimeout      UpstreamErrorKind = "timeout"
-	UpstreamCanceled     UpstreamErrorKind = "canceled"
-	UpstreamConnection   UpstreamErrorKind = "connection"
-	UpstreamBadStatus    UpstreamErrorKind = "bad_status"
-	UpstreamReadError    UpstreamErrorKind = "read_error"
-	UpstreamBodyTooLarge UpstreamErrorKind = "body_too_large"
-	UpstreamCircuitOpen  UpstreamErrorKind = "circuit_open"
-	UpstreamInternal     UpstreamErrorKind = "internal"
+	UpstreamTimeout          UpstreamErrorKind = "timeout"
+	UpstreamCanceled         UpstreamErrorKind = "canceled"
+	UpstreamConnection       UpstreamErrorKind = "connection"
+	UpstreamBadStatus        UpstreamErrorKind = "bad_status"
+	UpstreamReadError        UpstreamErrorKind = "read_error"
+	UpstreamBodyTooLarge     UpstreamErrorKind = "body_too_large"
+	UpstreamCircuitOpen      UpstreamErrorKind = "circuit_open"
+	UpstreamMalformed        UpstreamErrorKind = "malformed"
+	UpstreamGraphQLError     UpstreamErrorKind = "graphql_error"
+	UpstreamDependencyFailed UpstreamErrorKind = "dependency_failed"
+	UpstreamPolicyViolation  UpstreamErrorKind = "policy_violation"
+	UpstreamInternal         UpstreamErrorKind = "internal"
 )
+
+// upstreamErrorKinds are all upstream error kinds, which can be referenced by error mapping rules.
+var upstreamErrorKinds = []UpstreamErrorKind{
+	UpstreamTimeout,
+	UpstreamCanceled,
+	UpstreamConnection,
+	UpstreamBadStatus,
+	UpstreamReadError,
+	UpstreamBodyTooLarge,
+	UpstreamCircuitOpen,
+	UpstreamMalformed,
+	UpstreamGraphQLError,
+	UpstreamDependencyFailed,
+	UpstreamPolicyViolation,
+	UpstreamInternal,
+}
@@ -0,0 +1,339 @@
+package tokka
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/starwalkn/tokka/internal/metric"
+)
+
+const routeModeWebSocket = "websocket"
+
+// WebSocket frame constants (RFC 6455, section 5.2 and 7.4.1).
+const (
+	wsFinalBit           = 0x80
+	wsMaskBit            = 0x80
+	wsOpcodeMask         = 0x0F
+	wsOpcodeClose        = 0x8
+	wsCloseMessageTooBig = 1009
+)
+
+var (
+	errWebSocketMessageTooBig = errors.New("websocket message too big")
+	errWebSocketInvalidFrame  = errors.New("invalid websocket frame")
+	errWebSocketIdleTimeout   = errors.New("websocket connection idle timeout")
+)
+
+// webSocketHandshakeHeaders are client handshake headers which are always forwarded to the upstream.
+var webSocketHandshakeHeaders = []string{
+	"Sec-WebSocket-Key",
+	"Sec-WebSocket-Version",
+	"Sec-WebSocket-Protocol",
+	"Sec-WebSocket-Extensions",
+}
+
+// websocket performs the WebSocket handshake with the single route upstream and proxies frames in both
+// directions until either side closes the connection. Route middlewares run before, so authentication
+// and rate limiting apply to the handshake.
+func (r *Router) websocket(w http.ResponseWriter, req *http.Request, route *Route, requestID string) {
+	u, ok := route.Upstreams[0].(*httpUpstream)
+	if !ok {
+		r.log.Error("websocket mode requires an http upstream", zap.String("route", route.Method+" "+route.Path))
+		WriteRequestError(w, req, ErrorCodeInternal, "internal error", http.StatusInternalServerError)
+
+		return
+	}
+
+	if !isWebSocketUpgrade(req) {
+		w.Header().Set("Upgrade", "websocket")
+		WriteRequestError(w, req, ErrorCodeUpgradeRequired, "websocket upgrade required", http.StatusUpgradeRequired)
+
+		return
+	}
+
+	hresp, uerr := u.openStream(req.Context(), req, webSocketHeaders(req.Header))
+	if uerr != nil {
+		r.log.Error("upstream websocket handshake failed", zap.String("name", u.name), zap.Error(uerr.Unwrap()))
+		r.metrics.IncFailedRequestsTotal(metric.FailReasonUpstreamError)
+
+		jsonErr := (&defaultAggregator{log: r.log}).mapUpstreamError(uerr)
+		WriteRequestError(w, req, jsonErr.Code, jsonErr.Message, http.StatusBadGateway)
+
+		return
+	}
+	defer hresp.Body.Close()
+
+	// The upstream refused to upgrade the connection, so its response is returned to the client.
+	if hresp.StatusCode != http.StatusSwitchingProtocols {
+		r.proxyResponse(w, route, u, hresp, requestID)
+		return
+	}
+
+	upstreamConn, ok := hresp.Body.(io.ReadWriteCloser)
+	if !ok {
+		r.log.Error("upstream websocket connection is not writable", zap.String("name", u.name))
+		WriteRequestError(w, req, ErrorCodeInternal, "internal error", http.StatusInternalServerError)
+
+		return
+	}
+
+	clientConn, clientBuf, err := http.NewResponseController(w).Hijack()
+	if err != nil {
+		r.log.Error("cannot hijack websocket connection", zap.Error(err))
+		WriteRequestError(w, req, ErrorCodeInternal, "internal error", http.StatusInternalServerError)
+
+		return
+	}
+	defer clientConn.Close()
+
+	// Complete the client handshake with the upstream one, so the accept key and the negotiated
+	// subprotocol and extensions are passed as is.
+	header := hresp.Header.Clone()
+	if requestID != "" {
+		header.Set("X-Request-ID", requestID)
+	}
+
+	_, _ = clientBuf.WriteString("HTTP/1.1 101 Switching Protocols\r\n")
+	_ = header.Write(clientBuf)
+	_, _ = clientBuf.WriteString("\r\n")
+
+	if err = clientBuf.Flush(); err != nil {
+		r.log.Warn("cannot complete websocket handshake", zap.Error(err))
+		return
+	}
+
+	r.metrics.IncResponsesTotal(http.StatusSwitchingProtocols)
+	r.metrics.IncWebSocketConnections(route.Path)
+	defer r.metrics.DecWebSocketConnections(route.Path)
+
+	err = proxyWebSocket(clientConn, clientBuf.Reader, upstreamConn, route.WebSocket)
+
+	switch {
+	case errors.Is(err, errWebSocketMessageTooBig), errors.Is(err, errWebSocketInvalidFrame):
+		r.log.Warn("websocket connection closed", zap.String("name", u.name), zap.Error(err))
+	case err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, net.ErrClosed):
+		r.log.Debug("websocket connection closed", zap.String("name", u.name), zap.Error(err))
+	}
+}
+
+// proxyWebSocket copies frames between the client and the upstream until either side closes its connection,
+// a message exceeds the maximum size or no frames are received for the idle timeout. The returned error
+// is the reason the proxying stopped.
+func proxyWebSocket(clientConn net.Conn, clientReader io.Reader, upstreamConn io.ReadWriteCloser, cfg WebSocketConfig) error {
+	var (
+		once     sync.Once
+		idled    atomic.Bool
+		errs     = make(chan error, 2)
+		closeAll = func() {
+			once.Do(func() {
+				_ = clientConn.Close()
+				_ = upstreamConn.Close()
+			})
+		}
+	)
+
+	touch := func() {}
+
+	if cfg.IdleTimeout > 0 {
+		idle := time.AfterFunc(cfg.IdleTimeout, func() {
+			idled.Store(true)
+			closeAll()
+		})
+		defer idle.Stop()
+
+		touch = func() { idle.Reset(cfg.IdleTimeout) }
+	}
+
+	var (
+		upstreamReader = bufio.NewReaderSize(upstreamConn, streamBufferSize)
+		toClient       = &frameWriter{w: clientConn}
+		toUpstream     = &frameWriter{w: upstreamConn, masked: true}
+	)
+
+	go func() {
+		errs <- copyWebSocketFrames(toUpstream, &activityReader{Reader: clientReader, touch: touch}, toClient, cfg.MaxMessageSize)
+		closeAll()
+	}()
+
+	go func() {
+		errs <- copyWebSocketFrames(toClient, &activityReader{Reader: upstreamReader, touch: touch}, toUpstream, cfg.MaxMessageSize)
+		closeAll()
+	}()
+
+	err := <-errs
+	<-errs
+
+	if idled.Load() {
+		return errWebSocketIdleTimeout
+	}
+
+	return err
+}
+
+// copyWebSocketFrames copies frames from src to dst as is. If the payload of a data message exceeds
+// maxMessageSize, a close frame with the 1009 status is sent back to the peer of src through reply and
+// errWebSocketMessageTooBig is returned. Zero maxMessageSize means unlimited.
+func copyWebSocketFrames(dst *frameWriter, src io.Reader, reply *frameWriter, maxMessageSize int64) error {
+	var (
+		header  = make([]byte, 14) //nolint:mnd // the longest frame header
+		message int64
+	)
+
+	for {
+		if _, err := io.ReadFull(src, header[:2]); err != nil {
+			return err
+		}
+
+		n := 2
+		length := int64(header[1] &^ wsMaskBit)
+
+		switch length {
+		case 126: //nolint:mnd // 16-bit extended payload length
+			if _, err := io.ReadFull(src, header[n:n+2]); err != nil {
+				return err
+			}
+
+			length = int64(binary.BigEndian.Uint16(header[n:]))
+			n += 2
+		case 127: //nolint:mnd // 64-bit extended payload length
+			if _, err := io.ReadFull(src, header[n:n+8]); err != nil {
+				return err
+			}
+
+			length = int64(binary.BigEndian.Uint64(header[n:])) //nolint:gosec // the most significant bit must be 0
+			if length < 0 {
+				return errWebSocketInvalidFrame
+			}
+
+			n += 8
+		}
+
+		if header[1]&wsMaskBit != 0 {
+			if _, err := io.ReadFull(src, header[n:n+4]); err != nil {
+				return err
+			}
+
+			n += 4
+		}
+
+		// Control frames may be interleaved with fragments of data messages and do not count to their size.
+		if header[0]&wsOpcodeMask < wsOpcodeClose {
+			message += length
+
+			if maxMessageSize > 0 && message > maxMessageSize {
+				reply.writeClose(wsCloseMessageTooBig)
+				return errWebSocketMessageTooBig
+			}
+
+			if header[0]&wsFinalBit != 0 {
+				message = 0
+			}
+		}
+
+		if err := dst.writeFrame(header[:n], src, length); err != nil {
+			return err
+		}
+	}
+}
+
+// frameWriter writes whole frames to a connection, so frames copied from the other side are never
+// interleaved with close frames sent by the gateway. Frames are masked if they are sent to the upstream.
+type frameWriter struct {
+	mu     sync.Mutex
+	w      io.Writer
+	masked bool
+}
+
+// writeFrame writes the frame header followed by length bytes of payload read from src.
+func (fw *frameWriter) writeFrame(header []byte, src io.Reader, length int64) error {
+	fw.mu.Lock()
+	defer fw.mu.Unlock()
+
+	if _, err := fw.w.Write(header); err != nil {
+		return err
+	}
+
+	_, err := io.CopyN(fw.w, src, length)
+
+	return err
+}
+
+// writeClose writes a close frame with the given status code. The frame is dropped if another frame is
+// being written, since the connection is closed right after anyway.
+func (fw *frameWriter) writeClose(code uint16) {
+	if !fw.mu.TryLock() {
+		return
+	}
+	defer fw.mu.Unlock()
+
+	frame := []byte{wsFinalBit | wsOpcodeClose, 2}
+
+	if fw.masked {
+		// A zero masking key leaves the payload unchanged.
+		frame[1] |= wsMaskBit
+		frame = append(frame, 0, 0, 0, 0)
+	}
+
+	_, _ = fw.w.Write(binary.BigEndian.AppendUint16(frame, code))
+}
+
+// activityReader calls touch every time data is read.
+type activityReader struct {
+	io.Reader
+	touch func()
+}
+
+func (r *activityReader) Read(p []byte) (int, error) {
+	n, err := r.Reader.Read(p)
+	if n > 0 {
+		r.touch()
+	}
+
+	return n, err
+}
+
+// isWebSocketUpgrade reports whether the request is a WebSocket opening handshake.
+func isWebSocketUpgrade(req *http.Request) bool {
+	return req.Method == http.MethodGet &&
+		headerHasToken(req.Header, "Connection", "upgrade") &&
+		strings.EqualFold(req.Header.Get("Upgrade"), "websocket")
+}
+
+// webSocketHeaders returns the headers of the upstream handshake request.
+func webSocketHeaders(h http.Header) http.Header {
+	header := http.Header{
+		"Connection": []string{"Upgrade"},
+		"Upgrade":    []string{"websocket"},
+	}
+
+	for _, name := range webSocketHandshakeHeaders {
+		if values := h.Values(name); len(values) > 0 {
+			header[http.CanonicalHeaderKey(name)] = values
+		}
+	}
+
+	return header
+}
+
+// headerHasToken reports whether the comma-separated header values contain the token, ignoring case.
+func headerHasToken(h http.Header, name, token string) bool {
+	for _, value := range h.Values(name) {
+		for t := range strings.SplitSeq(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(t), token) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,172 @@
+package tokka
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/starwalkn/tokka/internal/metric"
+)
+
+// newEchoWebSocketServer returns an upstream which accepts WebSocket handshakes and echoes text frames.
+func newEchoWebSocketServer(t *testing.T) *httptest.Server {
+	t.Helper()
+
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !isWebSocketUpgrade(r) {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		conn, buf, err := http.NewResponseController(w).Hijack()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		_, _ = fmt.Fprintf(buf, "HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n"+
+			"Sec-WebSocket-Accept: %s\r\n\r\n", r.Header.Get("Sec-WebSocket-Key"))
+		_ = buf.Flush()
+
+		for {
+			payload, err := readMaskedFrame(buf.Reader)
+			if err != nil {
+				return
+			}
+
+			_, _ = conn.Write(append([]byte{0x81, byte(len(payload))}, payload...))
+		}
+	}))
+}
+
+// readMaskedFrame reads a short masked frame and returns its unmasked payload.
+func readMaskedFrame(r io.Reader) ([]byte, error) {
+	header := make([]byte, 6)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, err
+	}
+
+	payload := make([]byte, header[1]&0x7F)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return nil, err
+	}
+
+	for i := range payload {
+		payload[i] ^= header[2+i%4]
+	}
+
+	return payload, nil
+}
+
+func maskedFrame(payload string) []byte {
+	key := []byte{1, 2, 3, 4}
+	frame := append([]byte{0x81, 0x80 | byte(len(payload))}, key...)
+
+	for i := range len(payload) {
+		frame = append(frame, payload[i]^key[i%4])
+	}
+
+	return frame
+}
+
+func TestRouter_WebSocket(t *testing.T) {
+	upstream := newEchoWebSocketServer(t)
+	defer upstream.Close()
+
+	router := initMinimalRouter(1, metric.NewNop(), zap.NewNop())
+	router.Routes = append(router.Routes, Route{
+		Path:   "/ws",
+		Method: http.MethodGet,
+		Mode:   routeModeWebSocket,
+		Upstreams: []Upstream{
+			&httpUpstream{
+				name:    "echo",
+				url:     "ws" + upstream.URL[len("http"):],
+				timeout: time.Second,
+				client:  http.DefaultClient,
+			},
+		},
+		WebSocket: WebSocketConfig{MaxMessageSize: 16},
+	})
+
+	gateway := httptest.NewServer(router)
+	defer gateway.Close()
+
+	conn, err := net.Dial("tcp", gateway.Listener.Addr().String())
+	if err != nil {
+		t.Fatalf("cannot dial gateway: %v", err)
+	}
+	defer conn.Close()
+
+	_ = conn.SetDeadline(time.Now().Add(2 * time.Second))
+
+	_, _ = fmt.Fprint(conn, "GET /ws HTTP/1.1\r\nHost: example.com\r\nConnection: Upgrade\r\nUpgrade: websocket\r\n"+
+		"Sec-WebSocket-Key: key\r\nSec-WebSocket-Version: 13\r\n\r\n")
+
+	reader := bufio.NewReader(conn)
+
+	resp, err := http.ReadResponse(reader, nil)
+	if err != nil {
+		t.Fatalf("cannot read handshake response: %v", err)
+	}
+
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("expected status %d, got %d", http.StatusSwitchingProtocols, resp.StatusCode)
+	}
+
+	if resp.Header.Get("Sec-WebSocket-Accept") != "key" {
+		t.Errorf("expected upstream handshake headers, got %v", resp.Header)
+	}
+
+	_, _ = conn.Write(maskedFrame("hello"))
+
+	echo := make([]byte, 7)
+	if _, err = io.ReadFull(reader, echo); err != nil {
+		t.Fatalf("cannot read echo frame: %v", err)
+	}
+
+	if !bytes.Equal(echo, []byte("\x81\x05hello")) {
+		t.Errorf("expected echo frame, got %q", echo)
+	}
+
+	// Messages above the maximum size close the connection with the 1009 status sent to the client.
+	_, _ = conn.Write(maskedFrame("this message is too big"))
+
+	closeFrame := make([]byte, 4)
+	if _, err = io.ReadFull(reader, closeFrame); err != nil {
+		t.Fatalf("cannot read close frame: %v", err)
+	}
+
+	if !bytes.Equal(closeFrame, []byte{0x88, 0x02, 0x03, 0xF1}) {
+		t.Errorf("expected unmasked close frame with status 1009, got %x", closeFrame)
+	}
+
+	if _, err = reader.ReadByte(); err != io.EOF {
+		t.Errorf("expected connection to be closed, got %v", err)
+	}
+}
+
+func TestRouter_WebSocket_UpgradeRequired(t *testing.T) {
+	router := initMinimalRouter(1, metric.NewNop(), zap.NewNop())
+	router.Routes = append(router.Routes, Route{
+		Path:      "/ws",
+		Method:    http.MethodGet,
+		Mode:      routeModeWebSocket,
+		Upstreams: []Upstream{&httpUpstream{name: "echo", url: "http://127.0.0.1:1", client: http.DefaultClient}},
+	})
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "http://example.com/ws", nil))
+
+	if w.Code != http.StatusUpgradeRequired {
+		t.Errorf("expected status %d, got %d", http.StatusUpgradeRequired, w.Code)
+	}
+}